@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// cecQueueSize bounds how many CEC operations can wait for the worker.
+const cecQueueSize = 64
+
+// errCECQueueFull is returned when the CEC command queue has no room, so
+// callers back off instead of piling up behind a slow adapter.
+var errCECQueueFull = errors.New("CEC command queue is full")
+
+// cecJob is one operation submitted to the CEC worker.
+type cecJob struct {
+	ctx  context.Context
+	fn   func() error
+	done chan error
+}
+
+// CECQueue runs CEC operations one at a time, in submission order, on a
+// single worker goroutine. It is the only thing that touches cecConn once
+// the connection is published, so libcec never sees concurrent calls.
+type CECQueue struct {
+	jobs chan cecJob
+}
+
+// NewCECQueue creates a queue with room for size waiting operations and
+// starts its worker.
+func NewCECQueue(size int) *CECQueue {
+	q := &CECQueue{jobs: make(chan cecJob, size)}
+	go q.run()
+	return q
+}
+
+var cecQueue = NewCECQueue(cecQueueSize)
+
+// run executes jobs until the process exits. A job whose caller gave up
+// while it was queued is dropped without running.
+func (q *CECQueue) run() {
+	for job := range q.jobs {
+		if job.ctx.Err() != nil {
+			continue
+		}
+		job.done <- runCECJob(job.fn)
+	}
+}
+
+// runCECJob calls fn, turning a panic into an error so one bad job can't
+// take the worker, and every later CEC call, down with it.
+func runCECJob(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in queued CEC operation: %v", r)
+			err = fmt.Errorf("CEC operation panicked: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// Do queues fn and waits for its result. It returns errCECQueueFull at
+// once if the queue has no room, and ctx.Err() if ctx is done before fn
+// finishes. libcec calls can't be interrupted, so fn keeps the worker
+// until it returns even after Do gave up.
+func (q *CECQueue) Do(ctx context.Context, fn func() error) error {
+	job := cecJob{ctx: ctx, fn: fn, done: make(chan error, 1)}
+	select {
+	case q.jobs <- job:
+	default:
+		return errCECQueueFull
+	}
+
+	select {
+	case err := <-job.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Len reports how many operations are waiting for the worker.
+func (q *CECQueue) Len() int {
+	return len(q.jobs)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockWorker queues a job on q that runs until release is called, and
+// waits for the worker to start it. release may be called more than once.
+func blockWorker(t *testing.T, q *CECQueue) (release func()) {
+	t.Helper()
+	started, unblock := make(chan struct{}), make(chan struct{})
+	go q.Do(context.Background(), func() error {
+		close(started)
+		<-unblock
+		return nil
+	})
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("worker didn't start the blocking job")
+	}
+	var once sync.Once
+	return func() { once.Do(func() { close(unblock) }) }
+}
+
+func TestWithCECTimeoutSlowJob(t *testing.T) {
+	defer func(q *CECQueue) { cecQueue = q }(cecQueue)
+	cecQueue = NewCECQueue(cecQueueSize)
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	err := withCECTimeout(20*time.Millisecond, func() error {
+		<-unblock
+		return nil
+	})
+	if !errors.Is(err, errCECTimeout) {
+		t.Fatalf("err = %v, want errCECTimeout", err)
+	}
+	if status := cecErrorStatus(err); status != http.StatusGatewayTimeout {
+		t.Errorf("cecErrorStatus = %d, want %d", status, http.StatusGatewayTimeout)
+	}
+}
+
+func TestCECQueueSkipsExpiredJobs(t *testing.T) {
+	q := NewCECQueue(cecQueueSize)
+	release := blockWorker(t, q)
+
+	var ran atomic.Bool
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := q.Do(ctx, func() error {
+		ran.Store(true)
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	// Once the worker is free, the expired job must be dropped; the next
+	// job only runs after the worker has passed over it.
+	release()
+	if err := q.Do(context.Background(), func() error { return nil }); err != nil {
+		t.Fatalf("Do after release: %v", err)
+	}
+	if ran.Load() {
+		t.Error("job ran after its caller gave up")
+	}
+}
+
+func TestCECQueueFull(t *testing.T) {
+	q := NewCECQueue(1)
+	release := blockWorker(t, q)
+	defer release()
+
+	// Fill the one waiting slot.
+	waiting := make(chan error, 1)
+	go func() { waiting <- q.Do(context.Background(), func() error { return nil }) }()
+	deadline := time.Now().Add(time.Second)
+	for q.Len() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("second job never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err := q.Do(context.Background(), func() error { return nil })
+	if !errors.Is(err, errCECQueueFull) {
+		t.Fatalf("err = %v, want errCECQueueFull", err)
+	}
+	if status := cecErrorStatus(err); status != http.StatusServiceUnavailable {
+		t.Errorf("cecErrorStatus = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	release()
+	if err := <-waiting; err != nil {
+		t.Errorf("queued job: %v", err)
+	}
+}
+
+func TestCECQueueRecoversFromPanic(t *testing.T) {
+	q := NewCECQueue(cecQueueSize)
+
+	err := q.Do(context.Background(), func() error { panic("libcec went away") })
+	if err == nil || !strings.Contains(err.Error(), "libcec went away") {
+		t.Errorf("err = %v, want the panic as an error", err)
+	}
+
+	// The worker must still be there for the next job.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.Do(ctx, func() error { return nil }); err != nil {
+		t.Errorf("Do after panic: %v", err)
+	}
+}
@@ -1,18 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,46 +37,125 @@ import (
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/time/rate"
 )
 
 // version is set at build time via -ldflags "-X main.version=..."
 var version = "dev"
 
+// CECController is the set of CEC operations the API uses. *cec.Connection
+// implements it for a real adapter, and Simulator for -simulate.
+type CECController interface {
+	Close() error
+	GetLibInfo() string
+	GetAdapterInfo() (*cec.AdapterInfo, error)
+	GetCurrentConfiguration() (*cec.Configuration, error)
+	SetConfiguration(config *cec.Configuration) error
+	GetLogicalAddresses() []cec.LogicalAddress
+
+	GetActiveDevices() []cec.LogicalAddress
+	IsActiveDevice(address cec.LogicalAddress) bool
+	RescanDevicesWithSettle(settle time.Duration) error
+	PingDevice(address cec.LogicalAddress) (bool, error)
+	GetDeviceInfoContext(ctx context.Context, address cec.LogicalAddress) (*cec.Device, error)
+	GetDeviceInfoCachedContext(ctx context.Context, address cec.LogicalAddress, ttl time.Duration) (*cec.Device, error)
+	GetDeviceOSDName(address cec.LogicalAddress) (string, error)
+	GetDevicePhysicalAddress(address cec.LogicalAddress) (uint16, error)
+	GetDeviceVendorId(address cec.LogicalAddress) (uint64, error)
+	GetDevicePowerStatus(address cec.LogicalAddress) (cec.PowerStatus, error)
+	WaitForDeviceReadyContext(ctx context.Context, address cec.LogicalAddress, targetState cec.PowerStatus, poll time.Duration) error
+	GetBusTopology() *cec.BusTopology
+	RefreshBusTopology(timeout time.Duration) (*cec.BusTopology, *cec.TopologyRefresh)
+
+	PowerOn(address cec.LogicalAddress) error
+	Standby(address cec.LogicalAddress) error
+	GetActiveSource() (cec.LogicalAddress, error)
+	SwitchToDeviceWithOptions(address cec.LogicalAddress, opts cec.SwitchOptions) error
+	SwitchToHDMIPortWithOptions(port uint8, opts cec.SwitchOptions) error
+	SetStreamPath(physicalAddress uint16) error
+	SetInactiveView() error
+
+	VolumeUp(sendRelease bool) error
+	VolumeDown(sendRelease bool) error
+	AudioMute() error
+	AudioUnmute() error
+	ToggleMuteWithStatus() (*bool, error)
+	SendVolumeKey(address cec.LogicalAddress, key cec.Keycode) error
+	SetAudioVolume(level uint8) error
+	GetAudioStatus() (volume uint8, muted bool, err error)
+	SetAudioRate(rate cec.AudioRate) error
+
+	SendButton(address cec.LogicalAddress, key cec.Keycode) error
+	SendButtonTimed(address cec.LogicalAddress, key cec.Keycode, hold time.Duration) error
+	SetOSDString(address cec.LogicalAddress, duration cec.DisplayControl, message string) error
+	ClearOSDString(address cec.LogicalAddress) error
+	Transmit(command *cec.Command) error
+	SendVendorCommandWithID(destination cec.LogicalAddress, vendorID uint32, frames [][]uint8) error
+}
+
+var (
+	_ CECController = (*cec.Connection)(nil)
+	_ CECController = (*Simulator)(nil)
+)
+
 var (
-	cecConn    *cec.Connection
-	cecMutex   sync.Mutex
-	cecReady   bool // true once CEC adapter is opened successfully
+	// cecConn is only used from jobs on cecQueue, which runs them one at
+	// a time.
+	cecConn CECController
+
+	cecStateMu sync.Mutex // guards cecReady, cecAdapter and cecLibInfo
+	cecReady   bool       // true once CEC adapter is opened successfully
+	cecAdapter string     // path of the opened adapter
+	cecLibInfo string     // libcec version string of the open connection
+
 	logHandler *LogHandler
 	eventHub   *EventHub
 )
 
 // CECEvent represents a real-time event from the CEC bus.
 type CECEvent struct {
-	Type      string      `json:"type"`      // "key_press", "command", "source_activated", "power_change", "alert"
+	Type      string      `json:"type"`      // "key_press", "command", "feature_abort", "routing", "source_activated", "power_change", "alert"
 	Timestamp time.Time   `json:"timestamp"`
 	Data      interface{} `json:"data"`
 }
 
 // EventHub is a simple pub/sub hub for CEC events. Subscribers receive events on a channel.
 type EventHub struct {
-	mu          sync.RWMutex
-	subs        map[chan CECEvent]struct{}
-	bufferSize  int
+	mu         sync.RWMutex
+	subs       map[chan CECEvent]map[string]bool // event types wanted; nil means all
+	bufferSize int
 }
 
 // NewEventHub creates an event hub with the given subscriber channel buffer size.
 func NewEventHub(bufferSize int) *EventHub {
 	return &EventHub{
-		subs:       make(map[chan CECEvent]struct{}),
+		subs:       make(map[chan CECEvent]map[string]bool),
 		bufferSize: bufferSize,
 	}
 }
 
 // Subscribe returns a channel that receives events. Caller must call Unsubscribe when done.
 func (h *EventHub) Subscribe() chan CECEvent {
+	return h.SubscribeFiltered()
+}
+
+// SubscribeFiltered is like Subscribe but only delivers events whose Type
+// is one of types. With no types it delivers every event.
+func (h *EventHub) SubscribeFiltered(types ...string) chan CECEvent {
+	var filter map[string]bool
+	if len(types) > 0 {
+		filter = make(map[string]bool, len(types))
+		for _, t := range types {
+			filter[t] = true
+		}
+	}
 	ch := make(chan CECEvent, h.bufferSize)
 	h.mu.Lock()
-	h.subs[ch] = struct{}{}
+	h.subs[ch] = filter
 	h.mu.Unlock()
 	return ch
 }
@@ -75,12 +168,16 @@ func (h *EventHub) Unsubscribe(ch chan CECEvent) {
 	close(ch)
 }
 
-// Publish sends the event to all subscribers. Non-blocking: if a subscriber's channel is full, the event is dropped for that subscriber.
+// Publish sends the event to all subscribers that want its type. Non-blocking: if a subscriber's channel is full, the event is dropped for that subscriber.
 func (h *EventHub) Publish(ev CECEvent) {
 	ev.Timestamp = time.Now()
+	eventsPublishedTotal.WithLabelValues(ev.Type).Inc()
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	for ch := range h.subs {
+	for ch, filter := range h.subs {
+		if filter != nil && !filter[ev.Type] {
+			continue
+		}
 		select {
 		case ch <- ev:
 		default:
@@ -89,26 +186,71 @@ func (h *EventHub) Publish(ev CECEvent) {
 	}
 }
 
+// logLevelNames lists the accepted -log-level and ?level= values.
+const logLevelNames = "error, warning, notice, traffic, debug or all"
+
+// Limits on how many libcec messages LogHandler keeps.
+const (
+	defaultLogBufferSize = 100
+	maxLogBufferSize     = 100000
+)
+
 // LogHandler implements cec.CallbackHandler for logging
 type LogHandler struct {
-	LogMessages []LogMessage
-	mu          sync.RWMutex
-	maxMessages int
+	mu sync.RWMutex
+	// logs is a ring buffer of the last len(logs) messages. next is where
+	// the next message goes and count how many slots are filled.
+	logs  []LogMessage
+	next  int
+	count int
+
+	// consoleLevel is the least severe level printed to the console.
+	consoleLevel cec.LogLevel
+	// jsonLog, when set, prints libcec messages as JSON lines instead of
+	// through the standard logger.
+	jsonLog *slog.Logger
 }
 
 type LogMessage struct {
 	Level     string    `json:"level"`
 	Timestamp time.Time `json:"timestamp"`
 	Message   string    `json:"message"`
+
+	level cec.LogLevel
+}
+
+// LogFilter selects messages from LogHandler.GetLogs. The zero value
+// selects nothing; MinLevel must be set.
+type LogFilter struct {
+	MinLevel cec.LogLevel // least severe level returned
+	Since    time.Time    // if set, only messages at or after this time
+	Limit    int          // if > 0, only the most recent Limit matches
 }
 
-func NewLogHandler() *LogHandler {
+// NewLogHandler creates a log handler that keeps the last capacity
+// messages and prints those at consoleLevel or more severe, as JSON lines
+// through jsonLog if it is set.
+func NewLogHandler(capacity int, consoleLevel cec.LogLevel, jsonLog *slog.Logger) *LogHandler {
 	return &LogHandler{
-		LogMessages: make([]LogMessage, 0),
-		maxMessages: 100,
+		logs:         make([]LogMessage, capacity),
+		consoleLevel: consoleLevel,
+		jsonLog:      jsonLog,
 	}
 }
 
+// slogLevel maps a libcec log level to the nearest slog level.
+func slogLevel(level cec.LogLevel) slog.Level {
+	switch level {
+	case cec.LogLevelError:
+		return slog.LevelError
+	case cec.LogLevelWarning:
+		return slog.LevelWarn
+	case cec.LogLevelNotice:
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
 func (l *LogHandler) OnLogMessage(level cec.LogLevel, timestamp int64, message string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -121,43 +263,71 @@ func (l *LogHandler) OnLogMessage(level cec.LogLevel, timestamp int64, message s
 		Level:     level.String(),
 		Timestamp: logTime,
 		Message:   message,
+		level:     level,
 	}
 
-	l.LogMessages = append(l.LogMessages, logMsg)
-	if len(l.LogMessages) > l.maxMessages {
-		l.LogMessages = l.LogMessages[1:]
+	l.logs[l.next] = logMsg
+	l.next = (l.next + 1) % len(l.logs)
+	if l.count < len(l.logs) {
+		l.count++
 	}
+	noteFirmwareLogMessage(message)
 
-	// Also log to console if not traffic
-	if level != cec.LogLevelTraffic && level != cec.LogLevelDebug {
-		log.Printf("[CEC %s] %s", level.String(), message)
+	if !l.consoleLevel.Includes(level) {
+		return
 	}
+	if l.jsonLog != nil {
+		l.jsonLog.Log(context.Background(), slogLevel(level), message,
+			"source", "libcec",
+			"cec_level", level.String(),
+			"cec_time", logTime)
+		return
+	}
+	log.Printf("[CEC %s] %s", level.String(), message)
 }
 
 func (l *LogHandler) OnKeyPress(key cec.Keycode, duration uint32) {
 	log.Printf("Key pressed: %d, duration: %d", key, duration)
 	if eventHub != nil {
-		eventHub.Publish(CECEvent{
-			Type: "key_press",
-			Data: map[string]interface{}{
-				"keycode":  int(key),
-				"duration": duration,
-			},
-		})
+		data := map[string]interface{}{
+			"keycode":  int(key),
+			"duration": duration,
+		}
+		if name := key.Name(); name != "" {
+			data["key"] = name
+		}
+		eventHub.Publish(CECEvent{Type: "key_press", Data: data})
+	}
+	// libcec reports a key press with duration 0 and again on release with
+	// the hold time; only the press is forwarded.
+	if duration == 0 {
+		keyForwarder.HandleKeyPress(key)
 	}
 }
 
 func (l *LogHandler) OnCommand(command *cec.Command) {
-	log.Printf("Command received: %s -> %s, opcode: 0x%02X",
-		command.Initiator.String(), command.Destination.String(), command.Opcode)
+	recorder.Record(command)
+	log.Printf("Command received: %s -> %s, opcode: 0x%02X (%s)",
+		command.Initiator.String(), command.Destination.String(), uint8(command.Opcode), command.Opcode)
+	if command.Opcode == cec.OpcodeUserControlPressed {
+		keyForwarder.NoteKeySource(command.Initiator)
+	}
+	abort, isAbort := cec.ParseFeatureAbort(command)
+	if isAbort {
+		log.Printf("Feature abort: %v", abort)
+	}
 	if eventHub != nil {
 		data := map[string]interface{}{
 			"initiator":   int(command.Initiator),
 			"destination": int(command.Destination),
-			"opcode":      fmt.Sprintf("0x%02X", command.Opcode),
+			"opcode":      fmt.Sprintf("0x%02X", uint8(command.Opcode)),
+			"opcode_name": command.Opcode.String(),
+			"ack":         command.Ack,
+			"eom":         command.Eom,
 		}
 		// Emit power_change when we see ReportPowerStatus (initiator reports its status) or Standby
 		if command.Opcode == cec.OpcodeReportPowerStatus && len(command.Parameters) >= 1 {
+			powerCache.Record(command.Initiator, cec.PowerStatus(command.Parameters[0]))
 			eventHub.Publish(CECEvent{
 				Type: "power_change",
 				Data: map[string]interface{}{
@@ -167,6 +337,7 @@ func (l *LogHandler) OnCommand(command *cec.Command) {
 			})
 		}
 		if command.Opcode == cec.OpcodeStandby {
+			powerCache.Record(command.Initiator, cec.PowerStatusStandby)
 			eventHub.Publish(CECEvent{
 				Type: "power_change",
 				Data: map[string]interface{}{
@@ -175,7 +346,62 @@ func (l *LogHandler) OnCommand(command *cec.Command) {
 				},
 			})
 		}
+		if command.Opcode == cec.OpcodeSetOSDName {
+			data["osd_name"] = displayOSDName(command.Initiator, string(command.Parameters))
+		}
+		if decoded := cec.DecodeCommand(command); decoded != nil {
+			data["decoded"] = decoded
+		}
 		eventHub.Publish(CECEvent{Type: "command", Data: data})
+		if command.Opcode == cec.OpcodeSetStreamPath && len(command.Parameters) >= 2 {
+			physAddr := uint16(command.Parameters[0])<<8 | uint16(command.Parameters[1])
+			eventHub.Publish(CECEvent{
+				Type: "routing",
+				Data: map[string]interface{}{
+					"kind":             "set_stream_path",
+					"initiator":        int(command.Initiator),
+					"physical_address": cec.PhysicalAddressToString(physAddr),
+				},
+			})
+		}
+		if rc, ok := cec.ParseRoutingChange(command); ok {
+			eventHub.Publish(CECEvent{
+				Type: "routing",
+				Data: map[string]interface{}{
+					"kind":      "routing_change",
+					"initiator": int(rc.Initiator),
+					"from":      cec.PhysicalAddressToString(rc.From),
+					"from_port": int(rc.FromPort()),
+					"to":        cec.PhysicalAddressToString(rc.To),
+					"to_port":   int(rc.ToPort()),
+				},
+			})
+		} else if command.Opcode == cec.OpcodeRoutingChange {
+			log.Printf("Ignoring malformed Routing Change from %s (%d parameter bytes)", command.Initiator.String(), len(command.Parameters))
+		}
+		if isAbort {
+			eventHub.Publish(CECEvent{
+				Type: "feature_abort",
+				Data: map[string]interface{}{
+					"initiator":   int(abort.Initiator),
+					"destination": int(command.Destination),
+					"opcode":      fmt.Sprintf("0x%02X", uint8(abort.Opcode)),
+					"reason":      abort.Reason.String(),
+					"reason_code": int(abort.Reason),
+				},
+			})
+		}
+		if vc, ok := cec.ParseVendorCommand(command); ok {
+			eventHub.Publish(CECEvent{
+				Type: "vendor_command",
+				Data: map[string]interface{}{
+					"initiator":   int(vc.Initiator),
+					"destination": int(vc.Destination),
+					"vendor_id":   fmt.Sprintf("0x%06X", vc.VendorID),
+					"payload":     fmt.Sprintf("%X", vc.Payload),
+				},
+			})
+		}
 	}
 }
 
@@ -185,6 +411,10 @@ func (l *LogHandler) OnConfigurationChanged(config *cec.Configuration) {
 
 func (l *LogHandler) OnAlert(alert cec.Alert, param cec.Parameter) {
 	log.Printf("Alert: %d", alert)
+	if alert == cec.AlertConnectionLost {
+		// Don't block the libcec callback thread
+		go markCECConnectionLost()
+	}
 	if eventHub != nil {
 		eventHub.Publish(CECEvent{
 			Type: "alert",
@@ -230,21 +460,60 @@ func powerStatusFromByte(b uint8) string {
 	}
 }
 
-func (l *LogHandler) GetRecentLogs() []LogMessage {
+// ConsoleLevel returns the least severe level printed to the console.
+func (l *LogHandler) ConsoleLevel() cec.LogLevel {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.consoleLevel
+}
+
+// SetConsoleLevel changes the least severe level printed to the console.
+// Messages of every level are still recorded for GetLogs.
+func (l *LogHandler) SetConsoleLevel(level cec.LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.consoleLevel = level
+}
+
+// GetLogs returns the recorded messages matching f, oldest first.
+func (l *LogHandler) GetLogs(f LogFilter) []LogMessage {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	result := make([]LogMessage, len(l.LogMessages))
-	copy(result, l.LogMessages)
+	// Walk back from the newest message so Limit stops the scan early.
+	result := make([]LogMessage, 0)
+	for i := 0; i < l.count; i++ {
+		if f.Limit > 0 && len(result) == f.Limit {
+			break
+		}
+		m := l.logs[(l.next-1-i+len(l.logs))%len(l.logs)]
+		if !f.Since.IsZero() && m.Timestamp.Before(f.Since) {
+			continue
+		}
+		if f.MinLevel.Includes(m.level) {
+			result = append(result, m)
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
 	return result
 }
 
 // HTTP Handlers
 
 type Response struct {
-	Status  string      `json:"status"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
+	Status  string         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Data    interface{}    `json:"data,omitempty"`
+	Partial *PartialResult `json:"partial,omitempty"`
+}
+
+// PartialResult marks a response whose data is incomplete, e.g. a device
+// scan cut short by its deadline.
+type PartialResult struct {
+	Expected int `json:"expected"`
+	Returned int `json:"returned"`
 }
 
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -268,19 +537,228 @@ func respondSuccess(w http.ResponseWriter, message string, data interface{}) {
 	})
 }
 
+// partialContentStatus is the HTTP status for a response carrying partial
+// results: 200 by default, or 206 when api.partial_content_status is set.
+func partialContentStatus() int {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	if currentConfig.API.PartialContentStatus {
+		return http.StatusPartialContent
+	}
+	return http.StatusOK
+}
+
+// defaultCommandTimeout bounds a single CEC operation made by an HTTP
+// handler unless cec.command_timeout is set.
+const defaultCommandTimeout = 10 * time.Second
+
+// errCECTimeout is returned by withCEC when a CEC operation doesn't finish
+// within the command timeout.
+var errCECTimeout = errors.New("CEC operation timed out")
+
+// commandTimeout returns the configured CEC command timeout.
+func commandTimeout() time.Duration {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	if t := time.Duration(currentConfig.CEC.CommandTimeout); t > 0 {
+		return t
+	}
+	return defaultCommandTimeout
+}
+
+// withCEC runs fn on the CEC worker, giving up after the command timeout.
+func withCEC(fn func() error) error {
+	return withCECTimeout(commandTimeout(), fn)
+}
+
+// withCECTimeout runs fn on the CEC worker and returns an error wrapping
+// errCECTimeout if waiting in the queue and running fn take longer than
+// timeout. libcec calls can't be interrupted, so a call that hangs keeps
+// the worker until it returns; callers queued behind it then time out
+// instead of blocking forever. fn is skipped if the worker only gets to it
+// after the caller gave up.
+func withCECTimeout(timeout time.Duration, fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := cecQueue.Do(ctx, fn)
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w after %v", errCECTimeout, timeout)
+	}
+	return err
+}
+
+// withSwitchTimeout runs a source switch with opts on the CEC worker. The
+// timeout allows the command timeout for each attempt plus the pauses
+// between them, so retries aren't cut short by withCEC's single timeout.
+func withSwitchTimeout(opts cec.SwitchOptions, fn func() error) error {
+	return withCECTimeout(time.Duration(opts.Attempts())*commandTimeout()+opts.Pauses(), fn)
+}
+
+// respondCECError reports a failed CEC operation with the status
+// cecErrorStatus picks for it.
+func respondCECError(w http.ResponseWriter, err error) {
+	respondError(w, cecErrorStatus(err), err.Error())
+}
+
+// cecErrorStatus maps an error from a CEC operation to an HTTP status.
+func cecErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errCECTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, cec.ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, cec.ErrDeviceUnreachable), errors.Is(err, cec.ErrTimeout):
+		return http.StatusBadGateway
+	case errors.Is(err, cec.ErrNotInitialized), errors.Is(err, cec.ErrAdapterUnavailable),
+		errors.Is(err, errCECQueueFull):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// cecIsReady reports whether the CEC adapter has been opened.
+func cecIsReady() bool {
+	cecStateMu.Lock()
+	defer cecStateMu.Unlock()
+	return cecReady
+}
+
+// publishCECConnection hands an opened connection to the CEC worker and
+// marks the adapter ready.
+func publishCECConnection(conn CECController, adapter string) {
+	libInfo := ""
+	// Protect against any unexpected panics inside libcec.
+	func() {
+		defer func() {
+			if recover() != nil {
+				libInfo = "unavailable"
+			}
+		}()
+		libInfo = conn.GetLibInfo()
+	}()
+
+	// cecConn belongs to the worker, so it is swapped in by a job. Nothing
+	// is queued before the adapter is ready, but retry in case it's busy.
+	for cecQueue.Do(context.Background(), func() error { cecConn = conn; return nil }) == errCECQueueFull {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cecStateMu.Lock()
+	cecReady = true
+	cecAdapter = adapter
+	cecLibInfo = libInfo
+	cecStateMu.Unlock()
+}
+
+// markCECConnectionLost records that libcec lost the adapter. API requests
+// fail with 503 from then on, and MQTT consumers see cec_ready go offline.
+func markCECConnectionLost() {
+	cecStateMu.Lock()
+	wasReady := cecReady
+	cecReady = false
+	adapter := cecAdapter
+	cecStateMu.Unlock()
+	if !wasReady {
+		return
+	}
+	log.Printf("CEC adapter connection lost (%s)", adapter)
+	setAdapterState("connection_lost", adapter)
+	mqttNotifyCECReady(false)
+}
+
 // requireCEC checks whether the CEC adapter is available. If not, it sends a
 // 503 response and returns false so the caller can bail out.
 func requireCEC(w http.ResponseWriter) bool {
-	cecMutex.Lock()
-	ready := cecReady
-	cecMutex.Unlock()
-	if !ready {
+	if !cecIsReady() {
 		respondError(w, http.StatusServiceUnavailable, "CEC adapter not available")
 		return false
 	}
 	return true
 }
 
+// maxRequestBodyBytes bounds JSON request bodies; none of the endpoints need
+// more than a few hundred bytes.
+const maxRequestBodyBytes = 64 << 10
+
+// decodeJSONBody strictly decodes a single JSON object from the request body
+// into dst. Unknown fields, type mismatches and trailing data are rejected
+// with a 400 naming the offending field; it returns false after responding.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.More() {
+		err = errors.New("trailing data after JSON object")
+	}
+	if err == nil {
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxErr *http.MaxBytesError
+	var msg string
+	switch {
+	case errors.Is(err, io.EOF):
+		msg = "Request body is empty"
+	case errors.As(err, &syntaxErr):
+		msg = fmt.Sprintf("Malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		msg = "Malformed JSON: unexpected end of body"
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			msg = fmt.Sprintf("Request body must be a JSON object, got %s", typeErr.Value)
+		} else {
+			msg = fmt.Sprintf("Field '%s' must be %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		msg = fmt.Sprintf("Unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	case errors.As(err, &maxErr):
+		respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", maxErr.Limit))
+		return false
+	default:
+		msg = fmt.Sprintf("Invalid request body: %v", err)
+	}
+	respondError(w, http.StatusBadRequest, msg)
+	return false
+}
+
+// jsonTypeName describes a Go type the way API clients see it.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint8:
+		return "an integer between 0 and 255"
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Ptr:
+		return jsonTypeName(t.Elem())
+	}
+	return t.String()
+}
+
+// requireField responds with a 400 naming field when it is missing from the
+// request body.
+func requireField(w http.ResponseWriter, present bool, field string) bool {
+	if !present {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Missing required field '%s'", field))
+		return false
+	}
+	return true
+}
+
 // Device endpoints
 
 func deviceToMap(dev *cec.Device) map[string]interface{} {
@@ -300,49 +778,209 @@ func deviceToMap(dev *cec.Device) map[string]interface{} {
 		"vendor_name":      cec.GetVendorName(dev.VendorID),
 		"cec_version":      dev.CECVersion.String(),
 		"power_status":     dev.PowerStatus.String(),
-		"osd_name":         dev.OSDName,
+		"osd_name":         displayOSDName(dev.LogicalAddress, dev.OSDName),
 		"menu_language":    dev.MenuLanguage,
 		"is_active":        dev.IsActive,
 		"is_active_source": dev.IsActiveSource,
 	}
 }
 
+// deviceScanDeadline caps the time spent querying devices in one
+// /api/devices or /api/devices/batch request.
+const deviceScanDeadline = 20 * time.Second
+
+// withDeviceDeadline runs fn on the CEC worker with a context that gives the
+// next of remaining devices an equal share of what is left of ctx's
+// deadline, so one unresponsive device can't use up the whole budget.
+func withDeviceDeadline(ctx context.Context, remaining int, fn func(context.Context) error) error {
+	share := deviceScanDeadline
+	if deadline, ok := ctx.Deadline(); ok {
+		share = time.Until(deadline) / time.Duration(remaining)
+	}
+	ctx, cancel := context.WithTimeout(ctx, share)
+	defer cancel()
+	return withCEC(func() error { return fn(ctx) })
+}
+
 func getDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if !cecIsReady() && serveDeviceSnapshot(w) {
+		return
+	}
 	if !requireCEC(w) { return }
 	// Optionally force a rescan when requested by the client.
 	rescanParam := r.URL.Query().Get("rescan")
 
-	// Step 1: rescan (if requested) and get active address list — fast, hold lock briefly.
-	cecMutex.Lock()
-	if rescanParam == "1" || strings.EqualFold(rescanParam, "true") {
-		cecConn.RescanDevices()
+	// ?max_age=5s serves device info cached within that window.
+	var maxAge time.Duration
+	if raw := r.URL.Query().Get("max_age"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid max_age %q (use a duration such as 5s)", raw))
+			return
+		}
+		maxAge = d
+	}
+
+	// Step 1: rescan (if requested) and get active address list — fast, one job.
+	var addresses []cec.LogicalAddress
+	err := withCEC(func() error {
+		if rescanParam == "1" || strings.EqualFold(rescanParam, "true") {
+			cecConn.RescanDevicesWithSettle(rescanSettle)
+		}
+		addresses = cecConn.GetActiveDevices()
+		return nil
+	})
+	if err != nil {
+		respondCECError(w, err)
+		return
 	}
-	addresses := cecConn.GetActiveDevices()
-	cecMutex.Unlock()
 
 	// Step 2: query each device individually with a 20s overall deadline.
-	// Each GetDeviceInfo call does several CEC queries that can be slow.
-	deadline := time.After(20 * time.Second)
+	// Each GetDeviceInfo call does several CEC queries that can be slow, so
+	// each device also gets its own share of the budget.
+	ctx, cancel := context.WithTimeout(r.Context(), deviceScanDeadline)
+	defer cancel()
 	result := make([]map[string]interface{}, 0, len(addresses))
+	devices := make([]cec.Device, 0, len(addresses))
 
-	for _, addr := range addresses {
-		select {
-		case <-deadline:
+	for i, addr := range addresses {
+		if ctx.Err() != nil {
 			// Time's up — return what we have so far.
-			respondSuccess(w, fmt.Sprintf("Devices retrieved (partial: %d of %d, CEC bus slow)", len(result), len(addresses)), result)
+			respondJSON(w, partialContentStatus(), Response{
+				Status:  "success",
+				Message: fmt.Sprintf("Devices retrieved (partial: %d of %d, CEC bus slow)", len(result), len(addresses)),
+				Data:    result,
+				Partial: &PartialResult{Expected: len(addresses), Returned: len(result)},
+			})
 			return
-		default:
 		}
 
-		cecMutex.Lock()
-		dev, err := cecConn.GetDeviceInfo(addr)
-		cecMutex.Unlock()
+		var dev *cec.Device
+		err := withDeviceDeadline(ctx, len(addresses)-i, func(ctx context.Context) (err error) {
+			dev, err = cecConn.GetDeviceInfoCachedContext(ctx, addr, maxAge)
+			return err
+		})
 
 		if err == nil {
 			result = append(result, deviceToMap(dev))
+			devices = append(devices, *dev)
+		}
+	}
+
+	recordDeviceSnapshot(devices)
+	respondSuccess(w, "Devices retrieved", result)
+}
+
+// maxScanSettle bounds the settle time a /api/scan request may ask for.
+const maxScanSettle = 30 * time.Second
+
+// rescanSettle is how long a rescan waits for devices to answer, set with
+// -rescan-settle.
+var rescanSettle = cec.DefaultRescanSettle
+
+// POST /api/scan rescans the bus and returns only the active logical
+// addresses, skipping the per-device queries /api/devices makes. An
+// optional {"timeout_ms": N} body sets how long to wait for devices to
+// answer (default -rescan-settle).
+func scanHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		TimeoutMS *int `json:"timeout_ms"`
+	}
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &req) {
+		return
+	}
+	settle := rescanSettle
+	if req.TimeoutMS != nil {
+		settle = time.Duration(*req.TimeoutMS) * time.Millisecond
+		if settle < 0 || settle > maxScanSettle {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'timeout_ms' must be in range 0-%d", maxScanSettle.Milliseconds()))
+			return
+		}
+	}
+
+	var active []cec.LogicalAddress
+	start := time.Now()
+	err := withCECTimeout(settle+commandTimeout(), func() error {
+		if err := cecConn.RescanDevicesWithSettle(settle); err != nil {
+			return err
+		}
+		active = cecConn.GetActiveDevices()
+		return nil
+	})
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	addresses := make([]int, 0, len(active))
+	for _, addr := range active {
+		addresses = append(addresses, int(addr))
+	}
+	respondSuccess(w, fmt.Sprintf("%d devices found", len(addresses)), map[string]interface{}{
+		"addresses":   addresses,
+		"count":       len(addresses),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+// POST /api/devices/batch returns device info for the requested addresses,
+// keyed by address. Devices that fail, or aren't reached before the
+// deadline, get an error entry instead of failing the whole request.
+func getDevicesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		Addresses []int `json:"addresses"`
+	}
+	if !decodeJSONBody(w, r, &req) || !requireField(w, len(req.Addresses) > 0, "addresses") {
+		return
+	}
+	var addrs []cec.LogicalAddress
+	seen := make(map[int]bool)
+	for _, addr := range req.Addresses {
+		if addr < 0 || addr > 15 {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'addresses' contains invalid logical address %d (must be 0-15)", addr))
+			return
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, cec.LogicalAddress(addr))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), deviceScanDeadline)
+	defer cancel()
+	result := make(map[string]interface{}, len(addrs))
+	returned := 0
+	for i, addr := range addrs {
+		key := strconv.Itoa(int(addr))
+		if ctx.Err() != nil {
+			result[key] = map[string]interface{}{"error": "not queried: request deadline exceeded"}
+			continue
+		}
+
+		var dev *cec.Device
+		err := withDeviceDeadline(ctx, len(addrs)-i, func(ctx context.Context) (err error) {
+			dev, err = cecConn.GetDeviceInfoContext(ctx, addr)
+			return err
+		})
+		if err != nil {
+			result[key] = map[string]interface{}{"error": err.Error()}
+			continue
 		}
+		result[key] = deviceToMap(dev)
+		returned++
 	}
 
+	if returned < len(addrs) {
+		respondJSON(w, partialContentStatus(), Response{
+			Status:  "success",
+			Message: fmt.Sprintf("Devices retrieved (partial: %d of %d)", returned, len(addrs)),
+			Data:    result,
+			Partial: &PartialResult{Expected: len(addrs), Returned: returned},
+		})
+		return
+	}
 	respondSuccess(w, "Devices retrieved", result)
 }
 
@@ -357,12 +995,15 @@ func getDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
-
-	device, err := cecConn.GetDeviceInfo(cec.LogicalAddress(addr))
+	ctx, cancel := context.WithTimeout(r.Context(), commandTimeout())
+	defer cancel()
+	var device *cec.Device
+	err = withCEC(func() (err error) {
+		device, err = cecConn.GetDeviceInfoContext(ctx, cec.LogicalAddress(addr))
+		return err
+	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
 
@@ -374,7 +1015,7 @@ func getDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		"vendor_name":      cec.GetVendorName(device.VendorID),
 		"cec_version":      device.CECVersion.String(),
 		"power_status":     device.PowerStatus.String(),
-		"osd_name":         device.OSDName,
+		"osd_name":         displayOSDName(device.LogicalAddress, device.OSDName),
 		"menu_language":    device.MenuLanguage,
 		"is_active":        device.IsActive,
 		"is_active_source": device.IsActiveSource,
@@ -383,66 +1024,180 @@ func getDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	respondSuccess(w, "Device info retrieved", result)
 }
 
-// Power control endpoints
-
-func powerOnHandler(w http.ResponseWriter, r *http.Request) {
+// pingDeviceHandler polls a device and reports whether it acknowledged,
+// along with the round-trip time.
+func pingDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireCEC(w) { return }
-	vars := mux.Vars(r)
-	addrStr := vars["address"]
-
-	addr := 0 // TV by default
-	if addrStr != "" {
-		var err error
-		addr, err = strconv.Atoi(addrStr)
-		if err != nil || addr < 0 || addr > 15 {
-			respondError(w, http.StatusBadRequest, "Invalid logical address")
-			return
-		}
+	addr, err := strconv.Atoi(mux.Vars(r)["address"])
+	if err != nil || addr < 0 || addr > 14 {
+		respondError(w, http.StatusBadRequest, "Invalid logical address (must be 0-14)")
+		return
 	}
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
-
-	err := cecConn.PowerOn(cec.LogicalAddress(addr))
+	var acked bool
+	var rtt time.Duration
+	err = withCEC(func() (err error) {
+		start := time.Now()
+		acked, err = cecConn.PingDevice(cec.LogicalAddress(addr))
+		rtt = time.Since(start)
+		return err
+	})
+	if errors.Is(err, errCECTimeout) {
+		respondCECError(w, err)
+		return
+	}
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	respondSuccess(w, fmt.Sprintf("Power on command sent to device %d", addr), nil)
+	msg := fmt.Sprintf("Device %d acknowledged poll", addr)
+	if !acked {
+		msg = fmt.Sprintf("Device %d did not acknowledge poll", addr)
+	}
+	respondSuccess(w, msg, map[string]interface{}{
+		"logical_address": addr,
+		"acknowledged":    acked,
+		"rtt_ms":          float64(rtt.Microseconds()) / 1000,
+	})
 }
 
-func powerOffHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireCEC(w) { return }
-	vars := mux.Vars(r)
-	addrStr := vars["address"]
+// maxOSDNameOverrideLength bounds OSD name overrides. Overrides are only
+// shown by capi, never sent on the bus, so they may exceed the CEC limit.
+const maxOSDNameOverrideLength = 64
 
-	addr := 0 // TV by default
-	if addrStr != "" {
-		var err error
-		addr, err = strconv.Atoi(addrStr)
-		if err != nil || addr < 0 || addr > 15 {
-			respondError(w, http.StatusBadRequest, "Invalid logical address")
-			return
-		}
+// displayOSDName returns the OSD name to report for a device: the
+// configured override if there is one, otherwise the name the device
+// reported on the bus.
+func displayOSDName(addr cec.LogicalAddress, reported string) string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	if name, ok := currentConfig.OSDNameOverrides[addr]; ok {
+		return name
 	}
+	return reported
+}
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
+// osdOverrideAddress parses the {address} of an OSD override request.
+func osdOverrideAddress(w http.ResponseWriter, r *http.Request) (cec.LogicalAddress, bool) {
+	addr, err := strconv.Atoi(mux.Vars(r)["address"])
+	if err != nil || addr < 0 || addr > 14 {
+		respondError(w, http.StatusBadRequest, "Invalid logical address (must be 0-14)")
+		return 0, false
+	}
+	return cec.LogicalAddress(addr), true
+}
 
-	err := cecConn.Standby(cec.LogicalAddress(addr))
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+func getOSDOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	addr, ok := osdOverrideAddress(w, r)
+	if !ok {
 		return
 	}
 
-	respondSuccess(w, fmt.Sprintf("Standby command sent to device %d", addr), nil)
-}
+	configMu.RLock()
+	name, set := currentConfig.OSDNameOverrides[addr]
+	configMu.RUnlock()
 
-func getPowerStatusHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireCEC(w) { return }
-	vars := mux.Vars(r)
-	addrStr := vars["address"]
+	var override interface{}
+	if set {
+		override = name
+	}
+	respondSuccess(w, "OSD name override", map[string]interface{}{
+		"logical_address": int(addr),
+		"osd_name":        override,
+	})
+}
+
+// putOSDOverrideHandler sets the OSD name reported for a device, or clears
+// the override when osd_name is empty.
+func putOSDOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	addr, ok := osdOverrideAddress(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		OSDName *string `json:"osd_name"`
+	}
+	if !decodeJSONBody(w, r, &req) || !requireField(w, req.OSDName != nil, "osd_name") {
+		return
+	}
+	name := *req.OSDName
+	if name != "" {
+		if err := cec.ValidateDeviceName(name); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'osd_name' is invalid: %v", err))
+			return
+		}
+		if len(name) > maxOSDNameOverrideLength {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'osd_name' must be at most %d bytes", maxOSDNameOverrideLength))
+			return
+		}
+	}
+
+	configMu.Lock()
+	if name == "" {
+		delete(currentConfig.OSDNameOverrides, addr)
+	} else {
+		if currentConfig.OSDNameOverrides == nil {
+			currentConfig.OSDNameOverrides = make(map[cec.LogicalAddress]string)
+		}
+		currentConfig.OSDNameOverrides[addr] = name
+	}
+	cfg := currentConfig
+	configMu.Unlock()
+
+	if err := saveConfig(configFilePath, cfg); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
+		return
+	}
+
+	if name == "" {
+		respondSuccess(w, "OSD name override cleared", map[string]interface{}{
+			"logical_address": int(addr),
+			"osd_name":        nil,
+		})
+		return
+	}
+	respondSuccess(w, "OSD name override saved", map[string]interface{}{
+		"logical_address": int(addr),
+		"osd_name":        name,
+	})
+}
+
+// Power control endpoints
+
+func powerOnHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	vars := mux.Vars(r)
+	addrStr := vars["address"]
+
+	addr := 0 // TV by default
+	if addrStr != "" {
+		var err error
+		addr, err = strconv.Atoi(addrStr)
+		if err != nil || addr < 0 || addr > 15 {
+			respondError(w, http.StatusBadRequest, "Invalid logical address")
+			return
+		}
+	}
+	if rejectDuringQuietHours(w, r) {
+		return
+	}
+
+	err := withCEC(func() error { return cecConn.PowerOn(cec.LogicalAddress(addr)) })
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, fmt.Sprintf("Power on command sent to device %d", addr), nil)
+}
+
+func powerOffHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	vars := mux.Vars(r)
+	addrStr := vars["address"]
 
 	addr := 0 // TV by default
 	if addrStr != "" {
@@ -454,14 +1209,206 @@ func getPowerStatusHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
+	err := withCEC(func() error { return cecConn.Standby(cec.LogicalAddress(addr)) })
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, fmt.Sprintf("Standby command sent to device %d", addr), nil)
+}
+
+// powerOffAllHandler broadcasts Standby, turning off every device on the
+// bus that honours it.
+func powerOffAllHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	err := withCEC(func() error { return cecConn.Standby(cec.LogicalAddressBroadcast) })
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, "Standby command broadcast to all devices", map[string]interface{}{
+		"command":     "standby",
+		"destination": int(cec.LogicalAddressBroadcast),
+	})
+}
+
+// powerToggleHandler puts a device in standby if it is on or turning on,
+// and powers it on otherwise. The status query and the command run under
+// one CEC job, so nothing else can change the state in between. A
+// device that doesn't answer the query is powered on: TVs in deep standby
+// often don't.
+func powerToggleHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	addr, err := strconv.Atoi(mux.Vars(r)["address"])
+	if err != nil || addr < 0 || addr > 15 {
+		respondError(w, http.StatusBadRequest, "Invalid logical address")
+		return
+	}
+	_, quiet := quietHoursBlock(r)
+
+	var previous cec.PowerStatus
+	var action string
+	err = withCEC(func() error {
+		status, err := cecConn.GetDevicePowerStatus(cec.LogicalAddress(addr))
+		if err != nil {
+			status = cec.PowerStatusUnknown
+		}
+		previous = status
+		switch status {
+		case cec.PowerStatusOn, cec.PowerStatusInTransitionStandbyToOn:
+			action = "standby"
+			return cecConn.Standby(cec.LogicalAddress(addr))
+		}
+		action = "power_on"
+		if quiet {
+			return nil
+		}
+		return cecConn.PowerOn(cec.LogicalAddress(addr))
+	})
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+	if action == "power_on" && quiet {
+		if rejectDuringQuietHours(w, r) {
+			return
+		}
+		// Quiet hours ended while the status was being queried
+		if err := withCEC(func() error { return cecConn.PowerOn(cec.LogicalAddress(addr)) }); err != nil {
+			respondCECError(w, err)
+			return
+		}
+	}
+
+	respondSuccess(w, fmt.Sprintf("Device %d toggled (%s)", addr, action), map[string]interface{}{
+		"address":         addr,
+		"action":          action,
+		"previous_status": powerStatusFromByte(uint8(previous)),
+	})
+}
+
+// Limits on POST /api/power/wait.
+const (
+	defaultPowerWaitTimeout = 8 * time.Second
+	maxPowerWaitTimeout     = 60 * time.Second
+	minPowerWaitPoll        = 100 * time.Millisecond
+	maxPowerWaitPoll        = 5 * time.Second
+)
+
+// powerWaitHandler blocks until a device reports the requested power state,
+// so automations can power on the TV and then wait until it is ready for a
+// source switch. It answers 408 if the device doesn't get there in time.
+// Each status query is queued on its own and the handler sleeps between
+// them, so the CEC worker stays free; the wait stops as soon as the client
+// disconnects.
+func powerWaitHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		Address   int    `json:"address"`
+		State     string `json:"state"`
+		TimeoutMS *int   `json:"timeout_ms"`
+		PollMS    *int   `json:"poll_ms"`
+	}
+	if !decodeJSONBody(w, r, &req) || !requireField(w, req.State != "", "state") {
+		return
+	}
+	if req.Address < 0 || req.Address > 15 {
+		respondError(w, http.StatusBadRequest, "Invalid logical address")
+		return
+	}
+	var target cec.PowerStatus
+	switch req.State {
+	case "on":
+		target = cec.PowerStatusOn
+	case "standby":
+		target = cec.PowerStatusStandby
+	default:
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid state %q (use on or standby)", req.State))
+		return
+	}
+	timeout := defaultPowerWaitTimeout
+	if req.TimeoutMS != nil {
+		timeout = time.Duration(*req.TimeoutMS) * time.Millisecond
+		if timeout <= 0 || timeout > maxPowerWaitTimeout {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("timeout_ms must be in range 1-%d", maxPowerWaitTimeout.Milliseconds()))
+			return
+		}
+	}
+	poll := cec.DefaultPowerPollInterval
+	if req.PollMS != nil {
+		poll = time.Duration(*req.PollMS) * time.Millisecond
+		if poll < minPowerWaitPoll || poll > maxPowerWaitPoll {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("poll_ms must be in range %d-%d", minPowerWaitPoll.Milliseconds(), maxPowerWaitPoll.Milliseconds()))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	start := time.Now()
+	timer := time.NewTimer(poll)
+	defer timer.Stop()
+	for {
+		// Each query is its own job, so other requests keep flowing between polls
+		var status cec.PowerStatus
+		err := withCEC(func() error {
+			var err error
+			status, err = cecConn.GetDevicePowerStatus(cec.LogicalAddress(req.Address))
+			return err
+		})
+		if err == nil && status == target {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			if r.Context().Err() == nil {
+				respondError(w, http.StatusRequestTimeout, fmt.Sprintf("Device %d did not reach %s within %v", req.Address, req.State, timeout))
+			}
+			// Otherwise the client went away; nobody is left to answer
+			return
+		case <-timer.C:
+			timer.Reset(poll)
+		}
+	}
+	respondSuccess(w, fmt.Sprintf("Device %d is %s", req.Address, req.State), map[string]interface{}{
+		"address":    req.Address,
+		"state":      req.State,
+		"elapsed_ms": time.Since(start).Milliseconds(),
+	})
+}
+
+func getPowerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	vars := mux.Vars(r)
+	addrStr := vars["address"]
+
+	if list := r.URL.Query().Get("addresses"); list != "" && addrStr == "" {
+		getPowerStatusesHandler(w, list)
+		return
+	}
+
+	addr := 0 // TV by default
+	if addrStr != "" {
+		var err error
+		addr, err = strconv.Atoi(addrStr)
+		if err != nil || addr < 0 || addr > 15 {
+			respondError(w, http.StatusBadRequest, "Invalid logical address")
+			return
+		}
+	}
 
-	status, err := cecConn.GetDevicePowerStatus(cec.LogicalAddress(addr))
+	var status cec.PowerStatus
+	err := withCEC(func() (err error) {
+		status, err = cecConn.GetDevicePowerStatus(cec.LogicalAddress(addr))
+		return err
+	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
+	powerCache.Record(cec.LogicalAddress(addr), status)
 
 	respondSuccess(w, "Power status retrieved", map[string]interface{}{
 		"address": addr,
@@ -476,9 +1423,6 @@ func volumeUpHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	addrStr := vars["address"]
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
-
 	if addrStr != "" {
 		// Send volume key directly to a specific device
 		addr, err := strconv.Atoi(addrStr)
@@ -486,9 +1430,9 @@ func volumeUpHandler(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusBadRequest, "invalid address")
 			return
 		}
-		err = cecConn.SendVolumeKey(cec.LogicalAddress(addr), cec.KeycodeVolumeUp)
+		err = withCEC(func() error { return cecConn.SendVolumeKey(cec.LogicalAddress(addr), cec.KeycodeVolumeUp) })
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+			respondCECError(w, err)
 			return
 		}
 		respondSuccess(w, fmt.Sprintf("Volume up sent to device %d", addr), nil)
@@ -496,9 +1440,9 @@ func volumeUpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Default: send to audio system via libcec
-	err := cecConn.VolumeUp(true)
+	err := withCEC(func() error { return cecConn.VolumeUp(true) })
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
 	respondSuccess(w, "Volume up command sent", nil)
@@ -509,27 +1453,24 @@ func volumeDownHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	addrStr := vars["address"]
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
-
 	if addrStr != "" {
 		addr, err := strconv.Atoi(addrStr)
 		if err != nil || addr < 0 || addr > 15 {
 			respondError(w, http.StatusBadRequest, "invalid address")
 			return
 		}
-		err = cecConn.SendVolumeKey(cec.LogicalAddress(addr), cec.KeycodeVolumeDown)
+		err = withCEC(func() error { return cecConn.SendVolumeKey(cec.LogicalAddress(addr), cec.KeycodeVolumeDown) })
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+			respondCECError(w, err)
 			return
 		}
 		respondSuccess(w, fmt.Sprintf("Volume down sent to device %d", addr), nil)
 		return
 	}
 
-	err := cecConn.VolumeDown(true)
+	err := withCEC(func() error { return cecConn.VolumeDown(true) })
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
 	respondSuccess(w, "Volume down command sent", nil)
@@ -540,48 +1481,192 @@ func muteHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	addrStr := vars["address"]
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
-
 	if addrStr != "" {
 		addr, err := strconv.Atoi(addrStr)
 		if err != nil || addr < 0 || addr > 15 {
 			respondError(w, http.StatusBadRequest, "invalid address")
 			return
 		}
-		err = cecConn.SendVolumeKey(cec.LogicalAddress(addr), cec.KeycodeMute)
+		err = withCEC(func() error { return cecConn.SendVolumeKey(cec.LogicalAddress(addr), cec.KeycodeMute) })
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, err.Error())
+			respondCECError(w, err)
 			return
 		}
 		respondSuccess(w, fmt.Sprintf("Mute sent to device %d", addr), nil)
 		return
 	}
 
-	err := cecConn.AudioToggleMute()
+	var muted *bool
+	err := withCEC(func() error {
+		var err error
+		muted, err = cecConn.ToggleMuteWithStatus()
+		return err
+	})
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+	msg := "Mute toggled (audio system did not report mute state)"
+	if muted != nil && *muted {
+		msg = "Audio muted"
+	} else if muted != nil {
+		msg = "Audio unmuted"
+	}
+	respondSuccess(w, msg, map[string]interface{}{
+		"muted": muted,
+	})
+}
+
+// setMuteHandler returns a handler that explicitly mutes or unmutes the
+// audio system, so the resulting state doesn't depend on the starting state.
+func setMuteHandler(muted bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireCEC(w) { return }
+		err := withCEC(func() error {
+			if muted {
+				return cecConn.AudioMute()
+			}
+			return cecConn.AudioUnmute()
+		})
+		if err != nil {
+			respondCECError(w, err)
+			return
+		}
+		msg := "Audio unmuted"
+		if muted {
+			msg = "Audio muted"
+		}
+		respondSuccess(w, msg, map[string]interface{}{
+			"muted": muted,
+		})
+	}
+}
+
+// volumeSettleDelay gives the audio system time to apply a new volume
+// before it is asked to report it.
+const volumeSettleDelay = 300 * time.Millisecond
+
+func setVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		Level *int `json:"level"`
+	}
+	if !decodeJSONBody(w, r, &req) || !requireField(w, req.Level != nil, "level") {
+		return
+	}
+	if *req.Level < 0 || *req.Level > 100 {
+		respondError(w, http.StatusBadRequest, "Field 'level' must be in range 0-100")
+		return
+	}
+
+	var reported interface{}
+	var muted interface{}
+	err := withCEC(func() error {
+		if err := cecConn.SetAudioVolume(uint8(*req.Level)); err != nil {
+			return err
+		}
+		time.Sleep(volumeSettleDelay)
+		if volume, m, err := cecConn.GetAudioStatus(); err == nil {
+			reported, muted = int(volume), m
+		}
+		return nil
+	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
-	respondSuccess(w, "Mute toggle command sent", nil)
+
+	respondSuccess(w, fmt.Sprintf("Volume set to %d", *req.Level), map[string]interface{}{
+		"level":  *req.Level,
+		"volume": reported,
+		"muted":  muted,
+	})
 }
 
 // Source control endpoints
 
+// getActiveSourceHandler reports the active source with the details
+// GetDeviceInfo finds for it. libcec reports an unknown active source as
+// logical address 15, which is answered with "active": false rather than
+// a made-up device.
 func getActiveSourceHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireCEC(w) { return }
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
+	var addr cec.LogicalAddress
+	err := withCEC(func() (err error) {
+		addr, err = cecConn.GetActiveSource()
+		return err
+	})
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	if addr == cec.LogicalAddressUnknown {
+		respondSuccess(w, "Active source unknown", map[string]interface{}{
+			"active":  false,
+			"address": int(addr),
+			"name":    "Unknown",
+			"reason":  "no device has announced itself as the active source",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), commandTimeout())
+	defer cancel()
+	var device *cec.Device
+	deviceErr := withCEC(func() (err error) {
+		device, err = cecConn.GetDeviceInfoContext(ctx, addr)
+		return err
+	})
+
+	data := map[string]interface{}{
+		"active":           true,
+		"address":          int(addr),
+		"name":             addr.String(),
+		"display_name":     addr.String(),
+		"osd_name":         nil,
+		"vendor_id":        nil,
+		"vendor_name":      nil,
+		"physical_address": nil,
+		"hdmi_port":        nil,
+		"device":           nil,
+	}
+	if deviceErr != nil {
+		// Still report the address; the details stay null
+		log.Printf("Active source %d: device info unavailable: %v", addr, deviceErr)
+		respondSuccess(w, "Active source retrieved (device info unavailable)", data)
+		return
+	}
+	if name := displayOSDName(addr, device.OSDName); name != "" {
+		data["osd_name"] = name
+		data["display_name"] = name
+	}
+	if device.VendorID != 0 {
+		data["vendor_id"] = fmt.Sprintf("0x%06X", device.VendorID)
+		data["vendor_name"] = cec.GetVendorName(device.VendorID)
+	}
+	if phys := device.PhysicalAddress; phys != 0xFFFF {
+		data["physical_address"] = cec.PhysicalAddressToString(phys)
+		data["hdmi_port"] = int((phys >> 12) & 0xF)
+	}
+	data["device"] = deviceToMap(device)
 
-	addr, err := cecConn.GetActiveSource()
+	respondSuccess(w, "Active source retrieved", data)
+}
+
+// setInactiveSourceHandler sends Inactive Source, telling the TV that the
+// adapter no longer has anything to show so it can switch to another input.
+func setInactiveSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	err := withCEC(func() error { return cecConn.SetInactiveView() })
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
 
-	respondSuccess(w, "Active source retrieved", map[string]interface{}{
-		"address": int(addr),
-		"name":    addr.String(),
+	respondSuccess(w, "Inactive source sent", map[string]interface{}{
+		"command":     "inactive_source",
+		"destination": int(cec.LogicalAddressTV),
 	})
 }
 
@@ -596,16 +1681,39 @@ func setActiveSourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
+	opts, ok := switchOptionsFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if rejectDuringQuietHours(w, r) {
+		return
+	}
 
-	err = cecConn.SwitchToDevice(cec.LogicalAddress(addr))
+	var onBus bool
+	var physAddr uint16
+	err = withSwitchTimeout(opts, func() (err error) {
+		target := cec.LogicalAddress(addr)
+		if onBus = cecConn.IsActiveDevice(target); !onBus {
+			return nil
+		}
+		if physAddr, err = cecConn.GetDevicePhysicalAddress(target); err != nil {
+			return err
+		}
+		return cecConn.SwitchToDeviceWithOptions(target, opts)
+	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
+		return
+	}
+	if !onBus {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Device %d is not on the CEC bus", addr))
 		return
 	}
 
-	respondSuccess(w, fmt.Sprintf("Switched to device %d", addr), nil)
+	respondSuccess(w, fmt.Sprintf("Switched to device %d", addr), map[string]interface{}{
+		"address":          addr,
+		"physical_address": cec.PhysicalAddressToString(physAddr),
+	})
 }
 
 func setHDMIPortHandler(w http.ResponseWriter, r *http.Request) {
@@ -619,632 +1727,3857 @@ func setHDMIPortHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
+	opts, ok := switchOptionsFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if rejectDuringQuietHours(w, r) {
+		return
+	}
 
-	err = cecConn.SwitchToHDMIPort(uint8(port))
+	err = withSwitchTimeout(opts, func() error { return cecConn.SwitchToHDMIPortWithOptions(uint8(port), opts) })
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
 
 	respondSuccess(w, fmt.Sprintf("Switched to HDMI port %d", port), nil)
 }
 
-// Navigation endpoints
-
-func sendKeyHandler(w http.ResponseWriter, r *http.Request) {
+// setStreamPathHandler broadcasts Set Stream Path for a physical address.
+func setStreamPathHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireCEC(w) { return }
 	var req struct {
-		Address int    `json:"address"`
-		Key     string `json:"key"`
-		Keycode int    `json:"keycode"`
+		Physical string `json:"physical"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 
-	if req.Address < 0 || req.Address > 15 {
-		respondError(w, http.StatusBadRequest, "Invalid logical address")
+	if !requireField(w, req.Physical != "", "physical") {
 		return
 	}
-
-	// Require that either a known key name or an explicit keycode is provided.
-	if req.Key == "" && req.Keycode == 0 {
-		respondError(w, http.StatusBadRequest, "Either 'key' or 'keycode' must be provided (and keycode 0 must be specified via 'key': 'select')")
+	physAddr, err := cec.ParsePhysicalAddress(req.Physical)
+	if err != nil || physAddr == 0xFFFF || cec.PhysicalAddressToString(physAddr) != req.Physical {
+		respondError(w, http.StatusBadRequest, "Field 'physical' must be a physical address such as 2.0.0.0")
 		return
 	}
-
-	var keycode cec.Keycode
-
-	// Map string keys to keycodes if provided
-	if req.Key != "" {
-		keyMap := map[string]cec.Keycode{
-			"up":     cec.KeycodeUp,
-			"down":   cec.KeycodeDown,
-			"left":   cec.KeycodeLeft,
-			"right":  cec.KeycodeRight,
-			"select": cec.KeycodeSelect,
-			"enter":  cec.KeycodeEnter,
-			"back":   cec.KeycodeExit,
-			"home":   cec.KeycodeRootMenu,
-			"menu":   cec.KeycodeSetupMenu,
-			"play":   cec.KeycodePlay,
-			"pause":  cec.KeycodePause,
-			"stop":   cec.KeycodeStop,
-		}
-		if k, ok := keyMap[req.Key]; ok {
-			keycode = k
-		} else {
-			respondError(w, http.StatusBadRequest, "Unsupported key name")
-			return
-		}
-	} else {
-		// No key string; validate raw keycode range explicitly.
-		if req.Keycode < 0 || req.Keycode > 0xFF {
-			respondError(w, http.StatusBadRequest, "Keycode must be in range 0-255")
-			return
-		}
-		keycode = cec.Keycode(req.Keycode)
+	if rejectDuringQuietHours(w, r) {
+		return
 	}
 
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
-
-	err := cecConn.SendButton(cec.LogicalAddress(req.Address), keycode)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if err := withCEC(func() error { return cecConn.SetStreamPath(physAddr) }); err != nil {
+		respondCECError(w, err)
 		return
 	}
 
-	respondSuccess(w, "Key command sent", nil)
+	respondSuccess(w, fmt.Sprintf("Set Stream Path sent for %s", req.Physical), map[string]interface{}{
+		"physical_address": req.Physical,
+	})
 }
 
-// Raw command endpoint
+// defaultInputCount is the number of HDMI inputs /api/inputs lists when no
+// device has been seen on a higher port. CEC can't ask the TV how many
+// inputs it has.
+const defaultInputCount = 4
 
-func rawCommandHandler(w http.ResponseWriter, r *http.Request) {
+// getInputsHandler lists the TV's HDMI inputs with the devices on each, as
+// far as the bus topology reveals them. Empty ports are listed too, since
+// they can still be selected.
+func getInputsHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireCEC(w) { return }
-	var req struct {
-		Initiator   int     `json:"initiator"`
-		Destination int     `json:"destination"`
-		Opcode      int     `json:"opcode"`
-		Parameters  []uint8 `json:"parameters"`
+	count := defaultInputCount
+	if countStr := r.URL.Query().Get("count"); countStr != "" {
+		n, err := strconv.Atoi(countStr)
+		if err != nil || n < 1 || n > 15 {
+			respondError(w, http.StatusBadRequest, "Invalid count (must be 1-15)")
+			return
+		}
+		count = n
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	var topo *cec.BusTopology
+	activePort := 0
+	err := withCEC(func() error {
+		topo = cecConn.GetBusTopology()
+		if active, err := cecConn.GetActiveSource(); err == nil && active != cec.LogicalAddressUnknown {
+			if phys, err := cecConn.GetDevicePhysicalAddress(active); err == nil && phys != 0xFFFF {
+				activePort = int((phys >> 12) & 0xF)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondCECError(w, err)
 		return
 	}
 
-	// Validate logical addresses
-	if req.Initiator < 0 || req.Initiator > 15 {
-		respondError(w, http.StatusBadRequest, "Invalid initiator logical address (must be 0-15)")
-		return
+	if int(topo.KnownPortCount) > count {
+		count = int(topo.KnownPortCount)
 	}
-	if req.Destination < 0 || req.Destination > 15 {
-		respondError(w, http.StatusBadRequest, "Invalid destination logical address (must be 0-15)")
-		return
+	devicesByPort := make(map[int][]cec.LogicalAddress, len(topo.ActivePorts))
+	for _, p := range topo.ActivePorts {
+		devicesByPort[int(p.Port)] = p.Devices
 	}
 
-	// Validate opcode
-	if req.Opcode < 0 || req.Opcode > 0xFF {
-		respondError(w, http.StatusBadRequest, "Invalid opcode (must be 0-255)")
-		return
+	inputs := make([]map[string]interface{}, 0, count)
+	for port := 1; port <= count; port++ {
+		devices := make([]map[string]interface{}, 0, len(devicesByPort[port]))
+		for _, addr := range devicesByPort[port] {
+			name := displayOSDName(addr, cecOSDName(addr))
+			if name == "" {
+				name = addr.String()
+			}
+			devices = append(devices, map[string]interface{}{
+				"logical_address": int(addr),
+				"name":            name,
+			})
+		}
+		inputs = append(inputs, map[string]interface{}{
+			"port":             port,
+			"physical_address": cec.PhysicalAddressToString(uint16(port) << 12),
+			"devices":          devices,
+			"active":           port == activePort,
+		})
 	}
 
-	// Conservative limit on parameter bytes for a single CEC frame.
-	const maxCECParameters = 14
-	if len(req.Parameters) > maxCECParameters {
-		respondError(w, http.StatusBadRequest, fmt.Sprintf("Too many parameters (max %d)", maxCECParameters))
-		return
-	}
+	respondSuccess(w, "Inputs retrieved", inputs)
+}
 
-	cmd := &cec.Command{
-		Initiator:   cec.LogicalAddress(req.Initiator),
-		Destination: cec.LogicalAddress(req.Destination),
-		Opcode:      cec.Opcode(req.Opcode),
-		OpcodeSet:   true,
-		Parameters:  req.Parameters,
+// getSourceListHandler lists every device a source switch can select: the
+// active devices on the TV's HDMI ports, apart from the adapter itself,
+// sorted by port and then physical address.
+func getSourceListHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	type source struct {
+		addr cec.LogicalAddress
+		phys uint16
+		name string
 	}
-
-	cecMutex.Lock()
-	defer cecMutex.Unlock()
-
-	err := cecConn.Transmit(cmd)
+	var sources []source
+	active := cec.LogicalAddressUnknown
+	err := withCEC(func() error {
+		topo := cecConn.GetBusTopology()
+		own := make(map[cec.LogicalAddress]bool)
+		for _, addr := range cecConn.GetLogicalAddresses() {
+			own[addr] = true
+		}
+		for _, p := range topo.ActivePorts {
+			for _, addr := range p.Devices {
+				if own[addr] {
+					continue
+				}
+				phys, err := cecConn.GetDevicePhysicalAddress(addr)
+				if err != nil {
+					continue
+				}
+				name, _ := cecConn.GetDeviceOSDName(addr)
+				sources = append(sources, source{addr, phys, name})
+			}
+		}
+		if addr, err := cecConn.GetActiveSource(); err == nil {
+			active = addr
+		}
+		return nil
+	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondCECError(w, err)
 		return
 	}
 
-	respondSuccess(w, "Raw command sent", nil)
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].phys != sources[j].phys {
+			return sources[i].phys < sources[j].phys
+		}
+		return sources[i].addr < sources[j].addr
+	})
+	result := make([]map[string]interface{}, 0, len(sources))
+	for _, src := range sources {
+		name := displayOSDName(src.addr, src.name)
+		if name == "" {
+			name = src.addr.String()
+		}
+		result = append(result, map[string]interface{}{
+			"logical_address":  int(src.addr),
+			"physical_address": cec.PhysicalAddressToString(src.phys),
+			"hdmi_port":        int((src.phys >> 12) & 0xF),
+			"name":             name,
+			"active_source":    src.addr == active,
+		})
+	}
+	respondSuccess(w, fmt.Sprintf("%d sources", len(result)), result)
 }
 
-// Logs endpoint
+// defaultSwitchOptions returns the source-switch options from the config file.
+func defaultSwitchOptions() cec.SwitchOptions {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	opts := cec.DefaultSwitchOptions()
+	opts.Wake = !currentConfig.CEC.SkipWake
+	opts.WakeMode, _ = cec.ParseWakeMode(currentConfig.CEC.WakeMode)
+	opts.Retries = currentConfig.CEC.SwitchRetries
+	opts.RetryDelay = time.Duration(currentConfig.CEC.SwitchRetryDelay)
+	return opts
+}
 
-func getLogsHandler(w http.ResponseWriter, r *http.Request) {
-	logs := logHandler.GetRecentLogs()
-	respondSuccess(w, "Logs retrieved", logs)
+// switchOptionsFromRequest builds source-switch options from the config
+// defaults, overridden by the optional ?wake=0|1 query parameter. On an
+// invalid value it sends a 400 response and returns false.
+func switchOptionsFromRequest(w http.ResponseWriter, r *http.Request) (cec.SwitchOptions, bool) {
+	opts := defaultSwitchOptions()
+	wakeParam := r.URL.Query().Get("wake")
+	switch {
+	case wakeParam == "":
+	case wakeParam == "1" || strings.EqualFold(wakeParam, "true"):
+		opts.Wake = true
+	case wakeParam == "0" || strings.EqualFold(wakeParam, "false"):
+		opts.Wake = false
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid wake value (must be 0, 1, true or false)")
+		return opts, false
+	}
+	if retriesParam := r.URL.Query().Get("retries"); retriesParam != "" {
+		retries, err := strconv.Atoi(retriesParam)
+		if err != nil || retries < 0 || retries > cec.MaxSwitchRetries {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid retries value (must be 0-%d)", cec.MaxSwitchRetries))
+			return opts, false
+		}
+		opts.Retries = retries
+	}
+	return opts, true
 }
 
-// SSE endpoint: GET /api/events streams CEC events as Server-Sent Events.
-func eventsSSEHandler(w http.ResponseWriter, r *http.Request) {
-	if eventHub == nil {
-		respondError(w, http.StatusInternalServerError, "event hub not initialized")
-		return
+// Navigation endpoints
+
+// maxKeyHoldMS bounds the hold_ms a /api/key request may ask for.
+const maxKeyHoldMS = 5000
+
+func sendKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		Address *int   `json:"address"`
+		Key     string `json:"key"`
+		Keycode *int   `json:"keycode"` // nil when omitted; 0 is Select
+		HoldMS  *int   `json:"hold_ms"`
 	}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		respondError(w, http.StatusInternalServerError, "streaming unsupported")
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.WriteHeader(http.StatusOK)
-	flusher.Flush()
+	if !requireField(w, req.Address != nil, "address") {
+		return
+	}
+	if *req.Address < 0 || *req.Address > 15 {
+		respondError(w, http.StatusBadRequest, "Field 'address' must be a logical address (0-15)")
+		return
+	}
 
-	ch := eventHub.Subscribe()
-	defer eventHub.Unsubscribe(ch)
+	// Require a key name or an explicit keycode; "keycode": 0 is Select.
+	if req.Key == "" && req.Keycode == nil {
+		respondError(w, http.StatusBadRequest, "Either 'key' or 'keycode' must be provided")
+		return
+	}
 
-	// Send keepalive comment every 15s so proxies don't close the connection
-	ticker := time.NewTicker(15 * time.Second)
-	defer ticker.Stop()
+	var keycode cec.Keycode
 
-	for {
-		select {
-		case ev, ok := <-ch:
-			if !ok {
-				return
-			}
-			body, err := json.Marshal(ev)
-			if err != nil {
-				continue
-			}
-			fmt.Fprintf(w, "data: %s\n\n", body)
-			flusher.Flush()
-		case <-ticker.C:
-			fmt.Fprintf(w, ": keepalive\n\n")
-			flusher.Flush()
-		case <-r.Context().Done():
+	// Map string keys to keycodes if provided
+	if req.Key != "" {
+		if k, ok := cec.KeycodeByName(req.Key); ok {
+			keycode = k
+		} else {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'key' has unsupported key name %q", req.Key))
+			return
+		}
+	} else {
+		// No key string; validate raw keycode range explicitly.
+		if *req.Keycode < 0 || *req.Keycode > 0xFF {
+			respondError(w, http.StatusBadRequest, "Field 'keycode' must be in range 0-255")
 			return
 		}
+		keycode = cec.Keycode(*req.Keycode)
 	}
+	hold := cec.DefaultButtonHold
+	if req.HoldMS != nil {
+		if *req.HoldMS < 0 || *req.HoldMS > maxKeyHoldMS {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'hold_ms' must be in range 0-%d", maxKeyHoldMS))
+			return
+		}
+		hold = time.Duration(*req.HoldMS) * time.Millisecond
+	}
+	if isWakeKey(keycode) && rejectDuringQuietHours(w, r) {
+		return
+	}
+	if rejectRateLimited(w, keyLimiter) {
+		return
+	}
+
+	err := withCEC(func() error { return cecConn.SendButtonTimed(cec.LogicalAddress(*req.Address), keycode, hold) })
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, "Key command sent", nil)
 }
 
-// Topology endpoint
+// Limits for /api/key/sequence.
+const (
+	maxKeySequence    = 64
+	maxKeyDelayMS     = 5000
+	defaultKeyDelayMS = 250
+)
 
-func getTopologyHandler(w http.ResponseWriter, r *http.Request) {
+// POST /api/key/sequence sends several keys to one device with a delay
+// between them, stopping at the first key that fails.
+func sendKeySequenceHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireCEC(w) { return }
-	cecMutex.Lock()
-	topo := cecConn.GetBusTopology()
-	ownAddrs := cecConn.GetLogicalAddresses()
-	cecMutex.Unlock()
-
-	// Build port list with device names for the UI
-	type portDetail struct {
-		Port    int      `json:"port"`
-		Devices []string `json:"devices"`
+	var req struct {
+		Address *int     `json:"address"`
+		Keys    []string `json:"keys"`
+		DelayMS *int     `json:"delay_ms"`
+		HoldMS  *int     `json:"hold_ms"`
 	}
-	ports := make([]portDetail, 0, len(topo.ActivePorts))
-	for _, p := range topo.ActivePorts {
-		names := make([]string, 0, len(p.Devices))
-		for _, addr := range p.Devices {
-			cecMutex.Lock()
-			name, _ := cecConn.GetDeviceOSDName(addr)
-			cecMutex.Unlock()
-			if name == "" {
-				name = addr.String()
-			}
-			names = append(names, name)
+	if !decodeJSONBody(w, r, &req) ||
+		!requireField(w, req.Address != nil, "address") ||
+		!requireField(w, len(req.Keys) > 0, "keys") {
+		return
+	}
+	if *req.Address < 0 || *req.Address > 15 {
+		respondError(w, http.StatusBadRequest, "Field 'address' must be a logical address (0-15)")
+		return
+	}
+	if len(req.Keys) > maxKeySequence {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'keys' has %d keys (max %d)", len(req.Keys), maxKeySequence))
+		return
+	}
+	delay := defaultKeyDelayMS * time.Millisecond
+	if req.DelayMS != nil {
+		if *req.DelayMS < 0 || *req.DelayMS > maxKeyDelayMS {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'delay_ms' must be in range 0-%d", maxKeyDelayMS))
+			return
 		}
-		ports = append(ports, portDetail{Port: int(p.Port), Devices: names})
+		delay = time.Duration(*req.DelayMS) * time.Millisecond
+	}
+	hold := cec.DefaultButtonHold
+	if req.HoldMS != nil {
+		if *req.HoldMS < 0 || *req.HoldMS > maxKeyHoldMS {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'hold_ms' must be in range 0-%d", maxKeyHoldMS))
+			return
+		}
+		hold = time.Duration(*req.HoldMS) * time.Millisecond
 	}
 
-	ownAddrInts := make([]int, len(ownAddrs))
-	for i, a := range ownAddrs {
-		ownAddrInts[i] = int(a)
+	keycodes := make([]cec.Keycode, len(req.Keys))
+	wakes := false
+	for i, name := range req.Keys {
+		k, ok := cec.KeycodeByName(name)
+		if !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'keys' has unsupported key name %q at index %d", name, i))
+			return
+		}
+		keycodes[i] = k
+		wakes = wakes || isWakeKey(k)
+	}
+	if wakes && rejectDuringQuietHours(w, r) {
+		return
 	}
 
-	respondSuccess(w, "Bus topology retrieved", map[string]interface{}{
-		"own_addresses":    ownAddrInts,
-		"own_port":         int(topo.OwnPort),
-		"known_port_count": int(topo.KnownPortCount),
-		"active_ports":     ports,
+	address := cec.LogicalAddress(*req.Address)
+	for i, k := range keycodes {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		if err := withCEC(func() error { return cecConn.SendButtonTimed(address, k, hold) }); err != nil {
+			respondJSON(w, cecErrorStatus(err), Response{
+				Status:  "error",
+				Message: fmt.Sprintf("Key %d (%s) failed: %v", i, req.Keys[i], err),
+				Data: map[string]interface{}{
+					"sent":         i,
+					"failed_index": i,
+				},
+			})
+			return
+		}
+	}
+
+	respondSuccess(w, fmt.Sprintf("Sent %d keys", len(keycodes)), map[string]interface{}{
+		"sent": len(keycodes),
 	})
 }
 
-// Audio status endpoint
+// OSD endpoints
 
-func getAudioStatusHandler(w http.ResponseWriter, r *http.Request) {
+// osdDurations maps the duration names accepted by /api/osd to display
+// controls.
+var osdDurations = map[string]cec.DisplayControl{
+	"default":        cec.DisplayControlDefaultTime,
+	"until_cleared":  cec.DisplayControlUntilCleared,
+	"clear_previous": cec.DisplayControlClearPrevious,
+}
+
+// osdHandler shows a text message on a device's screen.
+func osdHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireCEC(w) { return }
-	cecMutex.Lock()
-	volume, muted, err := cecConn.GetAudioStatus()
-	cecMutex.Unlock()
+	var req struct {
+		Address  *int    `json:"address"`
+		Message  *string `json:"message"`
+		Duration string  `json:"duration"`
+	}
 
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 
-	respondSuccess(w, "Audio status retrieved", map[string]interface{}{
-		"volume": int(volume),
-		"muted":  muted,
-	})
+	if !requireField(w, req.Address != nil, "address") || !requireField(w, req.Message != nil, "message") {
+		return
+	}
+	if *req.Address < 0 || *req.Address > 15 {
+		respondError(w, http.StatusBadRequest, "Field 'address' must be a logical address (0-15)")
+		return
+	}
+	if err := cec.ValidateOSDString(*req.Message); err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'message' is invalid: %v (messages are not truncated)", err))
+		return
+	}
+	duration := cec.DisplayControlDefaultTime
+	if req.Duration != "" {
+		d, ok := osdDurations[req.Duration]
+		if !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'duration' must be one of default, until_cleared, clear_previous (got %q)", req.Duration))
+			return
+		}
+		duration = d
+	}
+
+	if err := withCEC(func() error {
+		return cecConn.SetOSDString(cec.LogicalAddress(*req.Address), duration, *req.Message)
+	}); err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, fmt.Sprintf("OSD message shown on device %d", *req.Address), nil)
 }
 
-// ── Configuration persistence ──────────────────────────────────────────
+// osdClearHandler removes an OSD message previously shown on a device.
+func osdClearHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		Address *int `json:"address"`
+	}
 
-// MQTTConfig holds MQTT broker connection settings.
-type MQTTConfig struct {
-	Broker string `json:"broker"`
-	User   string `json:"user"`
-	Pass   string `json:"pass"`
-	Prefix string `json:"prefix"`
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if !requireField(w, req.Address != nil, "address") {
+		return
+	}
+	if *req.Address < 0 || *req.Address > 15 {
+		respondError(w, http.StatusBadRequest, "Field 'address' must be a logical address (0-15)")
+		return
+	}
+
+	if err := withCEC(func() error { return cecConn.ClearOSDString(cec.LogicalAddress(*req.Address)) }); err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, fmt.Sprintf("OSD message cleared on device %d", *req.Address), nil)
 }
 
-// Config is the on-disk configuration file format.
-type Config struct {
-	MQTT MQTTConfig `json:"mqtt"`
+// Raw command endpoint
+
+// rawCommandRequest is the body of POST /api/command and the payload of
+// the {prefix}/command/raw MQTT topic.
+type rawCommandRequest struct {
+	Initiator   *int    `json:"initiator"`
+	Destination *int    `json:"destination"`
+	Opcode      *int    `json:"opcode"`
+	Parameters  []uint8 `json:"parameters"`
 }
 
-var (
-	currentConfig  Config
-	configMu       sync.RWMutex
-	configFilePath string
-)
+// buildRawCommand validates a raw command request and builds the frame to
+// transmit. The error message is suitable for returning to the client.
+func buildRawCommand(req rawCommandRequest) (*cec.Command, error) {
+	switch {
+	case req.Initiator == nil:
+		return nil, errors.New("Missing required field 'initiator'")
+	case req.Destination == nil:
+		return nil, errors.New("Missing required field 'destination'")
+	case req.Opcode == nil:
+		return nil, errors.New("Missing required field 'opcode'")
+	}
 
-// loadConfig reads and parses the config file. Returns zero Config if not found.
-func loadConfig(path string) Config {
-	var cfg Config
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return cfg
+	// Validate logical addresses
+	if *req.Initiator < 0 || *req.Initiator > 15 {
+		return nil, errors.New("Field 'initiator' must be a logical address (0-15)")
+	}
+	if *req.Destination < 0 || *req.Destination > 15 {
+		return nil, errors.New("Field 'destination' must be a logical address (0-15)")
 	}
-	_ = json.Unmarshal(data, &cfg)
-	return cfg
-}
 
-// saveConfig atomically writes the config file.
-func saveConfig(path string, cfg Config) error {
-	data, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		return err
+	// Validate opcode
+	if *req.Opcode < 0 || *req.Opcode > 0xFF {
+		return nil, errors.New("Field 'opcode' must be in range 0-255")
 	}
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0600); err != nil {
-		return err
+
+	cmd := &cec.Command{
+		Initiator:   cec.LogicalAddress(*req.Initiator),
+		Destination: cec.LogicalAddress(*req.Destination),
+		Opcode:      cec.Opcode(*req.Opcode),
+		OpcodeSet:   true,
+		Parameters:  req.Parameters,
 	}
-	return os.Rename(tmp, path)
+	if err := cmd.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid command: %v", err)
+	}
+	return cmd, nil
 }
 
-// ── MQTT bridge ────────────────────────────────────────────────────────
+func rawCommandHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req rawCommandRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	cmd, err := buildRawCommand(req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isWakeOpcode(cmd.Opcode) && rejectDuringQuietHours(w, r) {
+		return
+	}
+	if rejectRateLimited(w, commandLimiter) {
+		return
+	}
+
+	err = withCEC(func() error { return cecConn.Transmit(cmd) })
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, "Raw command sent", nil)
+}
+
+// vendorCommandHandler sends a Vendor Command With ID. The body carries
+// either one frame's vendor data in "payload" or, for vendor commands that
+// span several frames, the data of each frame in "frames", sent in order.
+func vendorCommandHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		Destination *int      `json:"destination"`
+		VendorID    *int      `json:"vendor_id"`
+		Payload     []uint8   `json:"payload"`
+		Frames      [][]uint8 `json:"frames"`
+	}
+
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if !requireField(w, req.Destination != nil, "destination") ||
+		!requireField(w, req.VendorID != nil, "vendor_id") {
+		return
+	}
+	if *req.Destination < 0 || *req.Destination > 15 {
+		respondError(w, http.StatusBadRequest, "Field 'destination' must be a logical address (0-15)")
+		return
+	}
+	if *req.VendorID < 0 || *req.VendorID > 0xFFFFFF {
+		respondError(w, http.StatusBadRequest, "Field 'vendor_id' must be a 24-bit vendor ID")
+		return
+	}
+	if req.Payload != nil && req.Frames != nil {
+		respondError(w, http.StatusBadRequest, "Use either 'payload' or 'frames', not both")
+		return
+	}
+	dest := cec.LogicalAddress(*req.Destination)
+	vendorID := uint32(*req.VendorID)
+	frames := req.Frames
+	if frames == nil {
+		frames = [][]uint8{req.Payload}
+	}
+
+	if _, err := cec.VendorCommandParameters(vendorID, frames); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if rejectRateLimited(w, commandLimiter) {
+		return
+	}
+
+	if err := withCEC(func() error { return cecConn.SendVendorCommandWithID(dest, vendorID, frames) }); err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, fmt.Sprintf("Vendor command sent in %d frame(s)", len(frames)), map[string]interface{}{
+		"frames": len(frames),
+	})
+}
+
+// Logs endpoint
+
+// getLogsHandler returns recent libcec messages. ?level= keeps only
+// messages at that level or more severe, ?since= those at or after an
+// RFC 3339 time, and ?limit= the most recent matches.
+func getLogsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := LogFilter{MinLevel: cec.LogLevelAll}
+	q := r.URL.Query()
+	if raw := q.Get("level"); raw != "" {
+		level, ok := cec.ParseLogLevel(raw)
+		if !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid level %q (use %s)", raw, logLevelNames))
+			return
+		}
+		filter.MinLevel = level
+	}
+	if raw := q.Get("since"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid since %q (use an RFC 3339 time such as 2026-02-12T10:30:00Z)", raw))
+			return
+		}
+		filter.Since = t
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			respondError(w, http.StatusBadRequest, "Invalid limit (must be a positive integer)")
+			return
+		}
+		filter.Limit = n
+	}
+	logs := logHandler.GetLogs(filter)
+	respondSuccess(w, "Logs retrieved", logs)
+}
+
+func getLogLevelSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	respondSuccess(w, "Log level", map[string]interface{}{
+		"level": strings.ToLower(logHandler.ConsoleLevel().String()),
+	})
+}
+
+// postLogLevelSettingsHandler changes which libcec messages are printed to
+// the console until the next restart, e.g. to watch bus traffic while
+// debugging. libcec always hands every level to the callback, so there is
+// no libcec verbosity to raise and /api/logs keeps its history.
+func postLogLevelSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	if !decodeJSONBody(w, r, &req) || !requireField(w, req.Level != "", "level") {
+		return
+	}
+	level, ok := cec.ParseLogLevel(req.Level)
+	if !ok {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'level' must be %s", logLevelNames))
+		return
+	}
+
+	old := logHandler.ConsoleLevel()
+	logHandler.SetConsoleLevel(level)
+	name := strings.ToLower(level.String())
+	if level != old {
+		log.Printf("Console log level changed from %s to %s", strings.ToLower(old.String()), name)
+	}
+	respondSuccess(w, fmt.Sprintf("Log level set to %s", name), map[string]interface{}{
+		"old_level": strings.ToLower(old.String()),
+		"level":     name,
+	})
+}
+
+// commandFlagFilter selects command events by their ack and eom flags.
+// A nil field matches either value.
+type commandFlagFilter struct {
+	ack *bool
+	eom *bool
+}
+
+// parseCommandFlagFilter reads the optional ack and eom query parameters.
+func parseCommandFlagFilter(r *http.Request) (commandFlagFilter, error) {
+	var f commandFlagFilter
+	for name, dst := range map[string]**bool{"ack": &f.ack, "eom": &f.eom} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s %q (use true or false)", name, raw)
+		}
+		*dst = &v
+	}
+	return f, nil
+}
+
+// matches reports whether ev passes the filter. Only command events are
+// filtered; every other event type passes through.
+func (f commandFlagFilter) matches(ev CECEvent) bool {
+	if ev.Type != "command" || (f.ack == nil && f.eom == nil) {
+		return true
+	}
+	data, ok := ev.Data.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	if f.ack != nil && data["ack"] != *f.ack {
+		return false
+	}
+	if f.eom != nil && data["eom"] != *f.eom {
+		return false
+	}
+	return true
+}
+
+// SSE endpoint: GET /api/events streams CEC events as Server-Sent Events.
+// Optional ?ack= and ?eom= (true/false) restrict command events to frames
+// with those flags, and ?types= to a comma-separated list of event types.
+func eventsSSEHandler(w http.ResponseWriter, r *http.Request) {
+	if eventHub == nil {
+		respondError(w, http.StatusInternalServerError, "event hub not initialized")
+		return
+	}
+
+	filter, err := parseCommandFlagFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := eventHub.SubscribeFiltered(parseEventTypes(r)...)
+	defer eventHub.Unsubscribe(ch)
+
+	// Send keepalive comment every 15s so proxies don't close the connection
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !filter.matches(ev) {
+				continue
+			}
+			body, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", body)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-serverDone(r):
+			return
+		}
+	}
+}
+
+// Topology endpoint
+
+func getTopologyHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var topo *cec.BusTopology
+	var ownAddrs []cec.LogicalAddress
+	err := withCEC(func() error {
+		topo = cecConn.GetBusTopology()
+		ownAddrs = cecConn.GetLogicalAddresses()
+		return nil
+	})
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, "Bus topology retrieved", topologyToMap(topo, ownAddrs))
+}
+
+// topologyRefreshTimeout bounds how long POST /api/topology/refresh spends
+// re-querying physical addresses.
+const topologyRefreshTimeout = 5 * time.Second
+
+// refreshTopologyHandler re-queries every active device's physical address
+// before rebuilding the topology, e.g. after HDMI cables were replugged.
+func refreshTopologyHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var topo *cec.BusTopology
+	var refresh *cec.TopologyRefresh
+	var ownAddrs []cec.LogicalAddress
+	err := withCECTimeout(topologyRefreshTimeout+commandTimeout(), func() error {
+		topo, refresh = cecConn.RefreshBusTopology(topologyRefreshTimeout)
+		ownAddrs = cecConn.GetLogicalAddresses()
+		return nil
+	})
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	data := topologyToMap(topo, ownAddrs)
+	data["refresh"] = map[string]interface{}{
+		"queried":     addressesToInts(refresh.Queried),
+		"failed":      addressesToInts(refresh.Failed),
+		"skipped":     addressesToInts(refresh.Skipped),
+		"duration_ms": refresh.Duration.Milliseconds(),
+	}
+	respondSuccess(w, "Bus topology refreshed", data)
+}
+
+// cecOSDName returns the OSD name libcec knows for addr, or "" if it has
+// none or the CEC worker is unavailable.
+func cecOSDName(addr cec.LogicalAddress) string {
+	var name string
+	withCEC(func() error {
+		name, _ = cecConn.GetDeviceOSDName(addr)
+		return nil
+	})
+	return name
+}
+
+// addressesToInts converts logical addresses to plain ints for JSON output.
+func addressesToInts(addrs []cec.LogicalAddress) []int {
+	out := make([]int, len(addrs))
+	for i, a := range addrs {
+		out[i] = int(a)
+	}
+	return out
+}
+
+// topologyToMap builds the topology response, resolving device names for
+// the UI.
+func topologyToMap(topo *cec.BusTopology, ownAddrs []cec.LogicalAddress) map[string]interface{} {
+	// Build port list with device names for the UI
+	type portDetail struct {
+		Port    int      `json:"port"`
+		Devices []string `json:"devices"`
+	}
+	ports := make([]portDetail, 0, len(topo.ActivePorts))
+	for _, p := range topo.ActivePorts {
+		names := make([]string, 0, len(p.Devices))
+		for _, addr := range p.Devices {
+			name := displayOSDName(addr, cecOSDName(addr))
+			if name == "" {
+				name = addr.String()
+			}
+			names = append(names, name)
+		}
+		ports = append(ports, portDetail{Port: int(p.Port), Devices: names})
+	}
+
+	if len(topo.Conflicts) > 0 {
+		log.Printf("Warning: %d physical address conflict(s) on the CEC bus", len(topo.Conflicts))
+	}
+
+	return map[string]interface{}{
+		"own_addresses":    addressesToInts(ownAddrs),
+		"own_port":         int(topo.OwnPort),
+		"known_port_count": int(topo.KnownPortCount),
+		"active_ports":     ports,
+		"conflicts":        conflictsToMaps(topo.Conflicts),
+	}
+}
+
+// conflictsToMaps converts physical address conflicts into JSON-friendly maps
+// with dot-notation addresses.
+func conflictsToMaps(conflicts []cec.AddressConflict) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(conflicts))
+	for _, c := range conflicts {
+		devices := make([]int, len(c.Devices))
+		for i, a := range c.Devices {
+			devices[i] = int(a)
+		}
+		result = append(result, map[string]interface{}{
+			"physical_address": cec.PhysicalAddressToString(c.PhysicalAddress),
+			"devices":          devices,
+		})
+	}
+	return result
+}
+
+// Adapter endpoint
+
+// applyMenuLanguage sets the menu language the adapter advertises on the bus.
+func applyMenuLanguage(conn CECController, lang string) {
+	config, err := conn.GetCurrentConfiguration()
+	if err != nil {
+		log.Printf("Failed to set menu language %q: %v", lang, err)
+		return
+	}
+	config.MenuLanguage = lang
+	if err := conn.SetConfiguration(config); err != nil {
+		log.Printf("Failed to set menu language %q: %v", lang, err)
+		return
+	}
+	log.Printf("Adapter menu language set to %q", lang)
+}
+
+// applyAdapterAddress pins the adapter's physical address or HDMI port as
+// set in the cec section of the config. It does nothing if neither is set.
+func applyAdapterAddress(conn CECController, cfg CECConfig) {
+	if cfg.PhysicalAddress == "" && cfg.HDMIPort == 0 {
+		return
+	}
+	config, err := conn.GetCurrentConfiguration()
+	if err == nil {
+		err = setAdapterAddress(config, cfg)
+	}
+	if err == nil {
+		err = conn.SetConfiguration(config)
+	}
+	if err != nil {
+		log.Printf("Failed to set adapter address: %v", err)
+		return
+	}
+	log.Printf("Adapter physical address %s, HDMI port %d", physicalOrAuto(config.PhysicalAddress), config.HDMIPort)
+}
+
+// setAdapterAddress updates config with the physical address or HDMI port
+// from cfg. libcec only uses the HDMI port when no physical address is set,
+// so pinning a port clears the physical address. With neither set, libcec
+// goes back to detecting the address.
+func setAdapterAddress(config *cec.Configuration, cfg CECConfig) error {
+	switch {
+	case cfg.PhysicalAddress != "":
+		addr, err := cec.ParsePhysicalAddress(cfg.PhysicalAddress)
+		if err != nil {
+			return fmt.Errorf("invalid physical address %q: %w", cfg.PhysicalAddress, err)
+		}
+		config.PhysicalAddress = addr
+	case cfg.HDMIPort != 0:
+		config.PhysicalAddress = 0
+		config.HDMIPort = uint8(cfg.HDMIPort)
+	default:
+		config.PhysicalAddress = 0xFFFF // auto-detect, as cec.Open sets it
+		config.HDMIPort = 0
+	}
+	return nil
+}
+
+// physicalOrAuto formats a configured physical address, where 0 and 0xFFFF
+// mean libcec detects it.
+func physicalOrAuto(addr uint16) string {
+	if addr == 0 || addr == 0xFFFF {
+		return "auto"
+	}
+	return cec.PhysicalAddressToString(addr)
+}
+
+// physicalAddressOrNil formats a physical address, mapping the 0xFFFF
+// "unknown / auto-detect" sentinel to null.
+func physicalAddressOrNil(addr uint16) interface{} {
+	if addr == 0xFFFF {
+		return nil
+	}
+	return cec.PhysicalAddressToString(addr)
+}
+
+// getAdapterHandler reports the adapter's configured base device, HDMI port
+// and physical address next to the physical address detected on the bus.
+func getAdapterHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var info *cec.AdapterInfo
+	err := withCEC(func() error {
+		var err error
+		info, err = cecConn.GetAdapterInfo()
+		return err
+	})
+	cecStateMu.Lock()
+	path := cecAdapter
+	cecStateMu.Unlock()
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	for _, m := range info.Mismatches {
+		log.Printf("Warning: adapter %s", m)
+	}
+
+	respondSuccess(w, "Adapter info retrieved", map[string]interface{}{
+		"path":                        path,
+		"logical_addresses":           addressesToInts(info.LogicalAddresses),
+		"base_device":                 int(info.BaseDevice),
+		"base_device_name":            info.BaseDevice.String(),
+		"hdmi_port":                   int(info.HDMIPort),
+		"menu_language":               info.MenuLanguage,
+		"configured_physical_address": physicalAddressOrNil(info.ConfiguredPhysicalAddress),
+		"expected_physical_address":   physicalAddressOrNil(info.ExpectedPhysicalAddress),
+		"detected_physical_address":   physicalAddressOrNil(info.DetectedPhysicalAddress),
+		"physical_address_mismatch":   len(info.Mismatches) > 0,
+		"mismatches":                  info.Mismatches,
+	})
+}
+
+// configuredVendorNames holds the vendor names loaded from vendor_names at
+// startup.
+var configuredVendorNames map[uint64]string
+
+// parseVendorNames converts the vendor_names config section into vendor
+// IDs, skipping entries with a bad ID or an empty name.
+func parseVendorNames(names map[string]string) map[uint64]string {
+	ids := make(map[uint64]string, len(names))
+	for key, name := range names {
+		id, err := strconv.ParseUint(key, 0, 64)
+		if err != nil || id > 0xFFFFFF {
+			log.Printf("Ignoring invalid vendor_names key %q (must be a 24-bit vendor ID such as 0x00A0DE)", key)
+			continue
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			log.Printf("Ignoring empty vendor_names entry for %s", key)
+			continue
+		}
+		ids[id] = name
+	}
+	return ids
+}
+
+// getVendorsHandler lists the vendor names in effect: the built-in ones
+// merged with those from the vendor_names config section.
+func getVendorsHandler(w http.ResponseWriter, r *http.Request) {
+	names := cec.VendorNames()
+	ids := make([]uint64, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	vendors := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		source := "builtin"
+		if _, ok := configuredVendorNames[id]; ok {
+			source = "config"
+		}
+		vendors = append(vendors, map[string]interface{}{
+			"vendor_id": fmt.Sprintf("0x%06X", id),
+			"name":      names[id],
+			"source":    source,
+		})
+	}
+	respondSuccess(w, fmt.Sprintf("%d vendors", len(vendors)), vendors)
+}
+
+// libcecVersionString formats a libcec version number (0xMMmmpp) as
+// major.minor.patch.
+func libcecVersionString(v uint32) string {
+	return fmt.Sprintf("%d.%d.%d", v>>16&0xFF, v>>8&0xFF, v&0xFF)
+}
+
+// getAdapterConfigHandler returns libcec's current configuration as-is.
+func getAdapterConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var config *cec.Configuration
+	err := withCEC(func() error {
+		var err error
+		config, err = cecConn.GetCurrentConfiguration()
+		return err
+	})
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, fmt.Sprintf("Adapter configuration unavailable: %v", err))
+		return
+	}
+
+	respondSuccess(w, "Adapter configuration retrieved", map[string]interface{}{
+		"device_name":        config.DeviceName,
+		"device_type":        config.DeviceType.String(),
+		"physical_address":   physicalAddressOrNil(config.PhysicalAddress),
+		"base_device":        int(config.BaseDevice),
+		"hdmi_port":          int(config.HDMIPort),
+		"client_version":     libcecVersionString(config.ClientVersion),
+		"client_version_raw": fmt.Sprintf("0x%06X", config.ClientVersion),
+		"server_version":     libcecVersionString(config.ServerVersion),
+		"server_version_raw": fmt.Sprintf("0x%06X", config.ServerVersion),
+		"menu_language":      config.MenuLanguage,
+	})
+}
+
+// Diagnostics endpoint
+
+func getDiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	if !cecIsReady() {
+		// Without an adapter the bus can't be inspected; explain why instead.
+		status := getAdapterStatus()
+		warnings := make([]string, 0, len(status.Hints)+1)
+		if status.LastError != "" {
+			warnings = append(warnings, fmt.Sprintf("CEC adapter not available after %d attempt(s): %s", status.Attempts, status.LastError))
+		}
+		warnings = append(warnings, status.Hints...)
+		respondSuccess(w, "Diagnostics retrieved (CEC adapter not available)", map[string]interface{}{
+			"warnings":          warnings,
+			"address_conflicts": make([]map[string]interface{}, 0),
+			"adapter":           status,
+		})
+		return
+	}
+
+	var topo *cec.BusTopology
+	var adapterInfo *cec.AdapterInfo
+	var adapterErr error
+	if err := withCEC(func() error {
+		topo = cecConn.GetBusTopology()
+		adapterInfo, adapterErr = cecConn.GetAdapterInfo()
+		return nil
+	}); err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	warnings := make([]string, 0)
+	firmware := getFirmwareStatus()
+	if firmware.UpgradeRecommended {
+		warnings = append(warnings, fmt.Sprintf("Adapter firmware upgrade recommended (%s); old firmware is a common cause of unreliable CEC", firmware.Reason))
+	}
+	if adapterErr == nil {
+		for _, m := range adapterInfo.Mismatches {
+			warnings = append(warnings, fmt.Sprintf("Adapter %s; source switching may select the wrong input", m))
+		}
+	}
+	for _, c := range topo.Conflicts {
+		names := make([]string, len(c.Devices))
+		for i, a := range c.Devices {
+			names[i] = fmt.Sprintf("%d (%s)", a, a.String())
+		}
+		warnings = append(warnings, fmt.Sprintf(
+			"Physical address %s is claimed by devices %s; source switching to this address may select the wrong device",
+			cec.PhysicalAddressToString(c.PhysicalAddress), strings.Join(names, ", ")))
+	}
+
+	respondSuccess(w, "Diagnostics retrieved", map[string]interface{}{
+		"warnings":                     warnings,
+		"address_conflicts":            conflictsToMaps(topo.Conflicts),
+		"adapter":                      getAdapterStatus(),
+		"firmware":                     firmware,
+		"firmware_upgrade_recommended": firmware.UpgradeRecommended,
+	})
+}
+
+// Audio status endpoint
+
+func getAudioStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var volume uint8
+	var muted bool
+	err := withCEC(func() (err error) {
+		volume, muted, err = cecConn.GetAudioStatus()
+		return err
+	})
+	if errors.Is(err, cec.ErrAudioStatusUnknown) {
+		respondError(w, http.StatusServiceUnavailable, "Audio system did not report its status (is one connected?)")
+		return
+	}
+	if err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, "Audio status retrieved", map[string]interface{}{
+		"volume": int(volume),
+		"muted":  muted,
+	})
+}
+
+// audioRates maps the rate names accepted by /api/audio/rate to Set Audio
+// Rate operands.
+var audioRates = map[string]cec.AudioRate{
+	"off":             cec.AudioRateOff,
+	"wide_standard":   cec.AudioRateWideStandard,
+	"wide_fast":       cec.AudioRateWideFast,
+	"wide_slow":       cec.AudioRateWideSlow,
+	"narrow_standard": cec.AudioRateNarrowStandard,
+	"narrow_fast":     cec.AudioRateNarrowFast,
+	"narrow_slow":     cec.AudioRateNarrowSlow,
+}
+
+func setAudioRateHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var req struct {
+		Rate string `json:"rate"`
+	}
+	if !decodeJSONBody(w, r, &req) || !requireField(w, req.Rate != "", "rate") {
+		return
+	}
+	rate, ok := audioRates[req.Rate]
+	if !ok {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'rate' has unsupported value %q (must be off, wide_standard, wide_fast, wide_slow, narrow_standard, narrow_fast or narrow_slow)", req.Rate))
+		return
+	}
+
+	if err := withCEC(func() error { return cecConn.SetAudioRate(rate) }); err != nil {
+		respondCECError(w, err)
+		return
+	}
+
+	respondSuccess(w, fmt.Sprintf("Audio rate set to %s", rate), map[string]interface{}{
+		"rate": req.Rate,
+	})
+}
+
+// ── Device presence monitor ────────────────────────────────────────────
+
+// PresenceMonitor periodically polls the list of active devices and
+// publishes device_added / device_removed events when it changes. A device
+// has to be missing for absentPolls consecutive polls before it is reported
+// as removed, so a single missed poll doesn't cause events to flap.
+type PresenceMonitor struct {
+	mu          sync.RWMutex
+	listDevices func() ([]cec.LogicalAddress, bool)
+	absentPolls int
+	present     map[cec.LogicalAddress]bool
+	missed      map[cec.LogicalAddress]int
+	lastPoll    time.Time
+	initialized bool
+}
+
+// NewPresenceMonitor creates a monitor that obtains the active device list
+// from listDevices. listDevices returns false when the list is unavailable
+// (e.g. the adapter isn't ready), in which case the poll is skipped.
+func NewPresenceMonitor(listDevices func() ([]cec.LogicalAddress, bool), absentPolls int) *PresenceMonitor {
+	if absentPolls < 1 {
+		absentPolls = 1
+	}
+	return &PresenceMonitor{
+		listDevices: listDevices,
+		absentPolls: absentPolls,
+		present:     make(map[cec.LogicalAddress]bool),
+		missed:      make(map[cec.LogicalAddress]int),
+	}
+}
+
+// Poll fetches the active device list once and returns the devices that were
+// added or removed since the previous poll. The first successful poll only
+// establishes a baseline and reports no changes.
+func (m *PresenceMonitor) Poll() (added, removed []cec.LogicalAddress) {
+	addrs, ok := m.listDevices()
+	if !ok {
+		return nil, nil
+	}
+
+	seen := make(map[cec.LogicalAddress]bool, len(addrs))
+	for _, a := range addrs {
+		seen[a] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastPoll = time.Now()
+	if !m.initialized {
+		m.initialized = true
+		for a := range seen {
+			m.present[a] = true
+		}
+		return nil, nil
+	}
+
+	for a := range seen {
+		// Reappearing within the grace period cancels the pending removal
+		delete(m.missed, a)
+		if !m.present[a] {
+			m.present[a] = true
+			added = append(added, a)
+		}
+	}
+	for a := range m.present {
+		if seen[a] {
+			continue
+		}
+		m.missed[a]++
+		if m.missed[a] >= m.absentPolls {
+			delete(m.present, a)
+			delete(m.missed, a)
+			removed = append(removed, a)
+		}
+	}
+
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return added, removed
+}
+
+// Run polls every interval and publishes presence events to the EventHub.
+// It never returns.
+func (m *PresenceMonitor) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		added, removed := m.Poll()
+		for _, a := range added {
+			log.Printf("Device %d (%s) appeared on the bus", a, a.String())
+			eventHub.Publish(CECEvent{Type: "device_added", Data: map[string]interface{}{"address": int(a)}})
+		}
+		for _, a := range removed {
+			log.Printf("Device %d (%s) dropped off the bus", a, a.String())
+			eventHub.Publish(CECEvent{Type: "device_removed", Data: map[string]interface{}{"address": int(a)}})
+		}
+	}
+}
+
+// Snapshot returns the debounced presence state: every device currently
+// considered present, with the number of consecutive polls it has been
+// missing from (0 if it was seen in the last poll).
+func (m *PresenceMonitor) Snapshot() (devices map[cec.LogicalAddress]int, lastPoll time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	devices = make(map[cec.LogicalAddress]int, len(m.present))
+	for a := range m.present {
+		devices[a] = m.missed[a]
+	}
+	return devices, m.lastPoll
+}
+
+var presenceMonitor *PresenceMonitor
+
+// activeDevicesIfReady is the PresenceMonitor device source backed by the
+// real CEC connection.
+func activeDevicesIfReady() ([]cec.LogicalAddress, bool) {
+	if !cecIsReady() {
+		return nil, false
+	}
+	var active []cec.LogicalAddress
+	err := withCEC(func() error {
+		active = cecConn.GetActiveDevices()
+		return nil
+	})
+	return active, err == nil
+}
+
+func getPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	if presenceMonitor == nil {
+		respondError(w, http.StatusServiceUnavailable, "Presence monitoring is disabled")
+		return
+	}
+
+	devices, lastPoll := presenceMonitor.Snapshot()
+	addrs := make([]int, 0, len(devices))
+	for a := range devices {
+		addrs = append(addrs, int(a))
+	}
+	sort.Ints(addrs)
+
+	result := make([]map[string]interface{}, 0, len(addrs))
+	for _, a := range addrs {
+		result = append(result, map[string]interface{}{
+			"address":      a,
+			"name":         cec.LogicalAddress(a).String(),
+			"missed_polls": devices[cec.LogicalAddress(a)],
+		})
+	}
+
+	var lastPollValue interface{}
+	if !lastPoll.IsZero() {
+		lastPollValue = lastPoll
+	}
+
+	respondSuccess(w, "Presence retrieved", map[string]interface{}{
+		"devices":   result,
+		"last_poll": lastPollValue,
+	})
+}
+
+// ── Power status cache ─────────────────────────────────────────────────
+
+const (
+	// powerStatusCacheTTL is how long a known power status is reused
+	// instead of querying the device again.
+	powerStatusCacheTTL = 5 * time.Second
+	// powerStatusQueryTimeout bounds each device query in a multi-address
+	// power status request.
+	powerStatusQueryTimeout = 3 * time.Second
+)
+
+type cachedPowerStatus struct {
+	status cec.PowerStatus
+	at     time.Time
+}
+
+// PowerStatusCache remembers the last power status seen for each device,
+// whether from a query or a Report Power Status frame on the bus.
+type PowerStatusCache struct {
+	mu      sync.Mutex
+	entries map[cec.LogicalAddress]cachedPowerStatus
+}
+
+var powerCache = &PowerStatusCache{entries: make(map[cec.LogicalAddress]cachedPowerStatus)}
+
+// Record stores the status of a device.
+func (c *PowerStatusCache) Record(addr cec.LogicalAddress, status cec.PowerStatus) {
+	c.mu.Lock()
+	c.entries[addr] = cachedPowerStatus{status: status, at: time.Now()}
+	c.mu.Unlock()
+}
+
+// Fresh returns the cached status of a device if it is younger than
+// powerStatusCacheTTL.
+func (c *PowerStatusCache) Fresh(addr cec.LogicalAddress) (cec.PowerStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[addr]
+	if !ok || time.Since(e.at) > powerStatusCacheTTL {
+		return cec.PowerStatusUnknown, false
+	}
+	return e.status, true
+}
+
+// queryPowerStatus asks a device for its power status, giving up after
+// timeout.
+func queryPowerStatus(addr cec.LogicalAddress, timeout time.Duration) (cec.PowerStatus, error) {
+	var status cec.PowerStatus
+	err := withCECTimeout(timeout, func() (err error) {
+		status, err = cecConn.GetDevicePowerStatus(addr)
+		return err
+	})
+	if err != nil {
+		return cec.PowerStatusUnknown, err
+	}
+	powerCache.Record(addr, status)
+	return status, nil
+}
+
+// parseAddressList parses a comma-separated list of logical addresses,
+// dropping duplicates.
+func parseAddressList(list string) ([]cec.LogicalAddress, error) {
+	var addrs []cec.LogicalAddress
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		addr, err := strconv.Atoi(part)
+		if err != nil || addr < 0 || addr > 15 {
+			return nil, fmt.Errorf("invalid logical address %q", part)
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, cec.LogicalAddress(addr))
+		}
+	}
+	return addrs, nil
+}
+
+// getPowerStatusesHandler reports the power status of several devices.
+// Devices that fail or time out are listed with an error instead of
+// failing the whole request.
+func getPowerStatusesHandler(w http.ResponseWriter, list string) {
+	var addrs []cec.LogicalAddress
+	if list == "all" {
+		// Every device currently on the bus, read once so the set can't
+		// change partway through the request.
+		err := withCEC(func() error {
+			addrs = cecConn.GetActiveDevices()
+			return nil
+		})
+		if err != nil {
+			respondCECError(w, err)
+			return
+		}
+	} else {
+		var err error
+		if addrs, err = parseAddressList(list); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	statuses := make(map[string]interface{}, len(addrs))
+	returned := 0
+	for _, addr := range addrs {
+		key := strconv.Itoa(int(addr))
+		if status, ok := powerCache.Fresh(addr); ok {
+			statuses[key] = map[string]interface{}{"status": status.String(), "cached": true}
+			returned++
+			continue
+		}
+		status, err := queryPowerStatus(addr, powerStatusQueryTimeout)
+		if err != nil {
+			statuses[key] = map[string]interface{}{"error": err.Error()}
+			continue
+		}
+		statuses[key] = map[string]interface{}{"status": status.String(), "cached": false}
+		returned++
+	}
+
+	if returned < len(addrs) {
+		respondJSON(w, partialContentStatus(), Response{
+			Status:  "success",
+			Message: fmt.Sprintf("Power status retrieved (partial: %d of %d)", returned, len(addrs)),
+			Data:    statuses,
+			Partial: &PartialResult{Expected: len(addrs), Returned: returned},
+		})
+		return
+	}
+	respondSuccess(w, "Power status retrieved", statuses)
+}
+
+// ── Rate limiting ──────────────────────────────────────────────────────
+
+// Token buckets for the endpoints that put frames on the bus as fast as
+// clients send them. Each is shared by the HTTP and MQTT paths, since both
+// hit the same adapter. They allow everything until setCECRate is called.
+var (
+	commandLimiter = rate.NewLimiter(rate.Inf, 0) // /api/command, /api/command/vendor, MQTT raw
+	keyLimiter     = rate.NewLimiter(rate.Inf, 0) // /api/key, MQTT key
+)
+
+// setCECRate limits each bucket to perSec requests per second, with a
+// burst of one second's worth. Zero or less removes the limit. The buckets
+// are replaced rather than reconfigured so the burst is available at once:
+// changing a rate.Inf limiter keeps its empty bucket. Call it before the
+// HTTP server and MQTT bridge start.
+func setCECRate(perSec float64) {
+	limit, burst := rate.Inf, 0
+	if perSec > 0 {
+		limit, burst = rate.Limit(perSec), int(math.Ceil(perSec))
+	}
+	commandLimiter = rate.NewLimiter(limit, burst)
+	keyLimiter = rate.NewLimiter(limit, burst)
+}
+
+// rejectRateLimited answers 429 and returns true if l has no token left.
+func rejectRateLimited(w http.ResponseWriter, l *rate.Limiter) bool {
+	if l.Allow() {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	respondError(w, http.StatusTooManyRequests, fmt.Sprintf("Too many CEC commands (limit %g/s)", float64(l.Limit())))
+	return true
+}
+
+// ── Quiet hours ────────────────────────────────────────────────────────
+
+// QuietHoursConfig is a daily window, in local time, during which commands
+// that could turn on or wake a device are rejected. Start and End are
+// "HH:MM"; a window whose end is before its start spans midnight. Leaving
+// either empty disables quiet hours.
+type QuietHoursConfig struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// parseClock parses an "HH:MM" time of day into minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (must be HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks that both ends of an enabled window parse.
+func (q QuietHoursConfig) Validate() error {
+	if q.Start == "" && q.End == "" {
+		return nil
+	}
+	if _, err := parseClock(q.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if _, err := parseClock(q.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	return nil
+}
+
+// Active reports whether now falls inside the window.
+func (q QuietHoursConfig) Active(now time.Time) bool {
+	start, err1 := parseClock(q.Start)
+	end, err2 := parseClock(q.End)
+	if err1 != nil || err2 != nil || start == end {
+		return false
+	}
+	m := now.Hour()*60 + now.Minute()
+	if start < end {
+		return m >= start && m < end
+	}
+	return m >= start || m < end
+}
+
+// quietHoursActive returns the configured window and whether it is in
+// effect right now.
+func quietHoursActive() (QuietHoursConfig, bool) {
+	configMu.RLock()
+	q := currentConfig.QuietHours
+	configMu.RUnlock()
+	return q, q.Active(time.Now())
+}
+
+// quietHoursBlock reports whether quiet hours block wake commands for this
+// request, i.e. they are in effect and the request doesn't carry ?force=1.
+func quietHoursBlock(r *http.Request) (QuietHoursConfig, bool) {
+	force := r.URL.Query().Get("force")
+	if force == "1" || strings.EqualFold(force, "true") {
+		return QuietHoursConfig{}, false
+	}
+	return quietHoursActive()
+}
+
+// rejectDuringQuietHours responds 423 Locked and returns true if quiet
+// hours are in effect and the request doesn't carry ?force=1.
+func rejectDuringQuietHours(w http.ResponseWriter, r *http.Request) bool {
+	q, active := quietHoursBlock(r)
+	if !active {
+		return false
+	}
+	respondError(w, http.StatusLocked, fmt.Sprintf(
+		"Quiet hours in effect (%s-%s): power-on, wake and source switching are blocked; add ?force=1 to override",
+		q.Start, q.End))
+	return true
+}
+
+// isWakeOpcode reports whether a raw command can turn on the TV or take
+// over its input.
+func isWakeOpcode(op cec.Opcode) bool {
+	switch op {
+	case cec.OpcodeImageViewOn, cec.OpcodeTextViewOn, cec.OpcodeActiveSource, cec.OpcodeSetStreamPath:
+		return true
+	}
+	return false
+}
+
+// isWakeKey reports whether a remote key can turn on a device: Power,
+// Power Toggle Function (0x6B) and Power On Function (0x6D).
+func isWakeKey(k cec.Keycode) bool {
+	return k == cec.KeycodePower || k == 0x6B || k == 0x6D
+}
+
+// ── Event log file ─────────────────────────────────────────────────────
+
+const (
+	defaultEventLogMaxSizeMB = 10
+	defaultEventLogMaxFiles  = 3
+)
+
+// EventFileLogger appends CEC events to a newline-delimited JSON file with
+// size-based rotation (path, path.1, path.2, ...).
+type EventFileLogger struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f          *os.File
+	size       int64
+	writeError bool // true while writes are failing, to avoid log spam
+}
+
+// NewEventFileLogger opens (or creates) the event log at path for appending.
+func NewEventFileLogger(path string, maxSizeMB, maxFiles int) (*EventFileLogger, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultEventLogMaxSizeMB
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultEventLogMaxFiles
+	}
+	l := &EventFileLogger{
+		path:     path,
+		maxSize:  int64(maxSizeMB) * 1024 * 1024,
+		maxFiles: maxFiles,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *EventFileLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 -> path.N, ..., path -> path.1, dropping anything
+// beyond maxFiles, and reopens an empty file at path.
+func (l *EventFileLogger) rotate() error {
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// Write appends one event as a JSON line. Errors (e.g. disk full) are logged
+// once and the event is dropped; logging resumes when writes succeed again.
+func (l *EventFileLogger) Write(ev CECEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	if l.f == nil {
+		// A previous rotation failed to reopen the file; try again
+		if err := l.open(); err != nil {
+			l.reportError(err)
+			return
+		}
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			l.reportError(err)
+			return
+		}
+	}
+
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		l.reportError(err)
+		return
+	}
+	if l.writeError {
+		log.Printf("Event log %s: writes recovered", l.path)
+		l.writeError = false
+	}
+}
+
+func (l *EventFileLogger) reportError(err error) {
+	if !l.writeError {
+		log.Printf("Event log %s: write failed, dropping events: %v", l.path, err)
+		l.writeError = true
+	}
+}
+
+// Close closes the underlying file.
+func (l *EventFileLogger) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// runEventFileLogger subscribes to the EventHub and writes every event to
+// the log until the hub closes the channel.
+func runEventFileLogger(l *EventFileLogger) {
+	ch := eventHub.Subscribe()
+	for ev := range ch {
+		l.Write(ev)
+	}
+	l.Close()
+}
+
+// ── Key forwarding ─────────────────────────────────────────────────────
+
+// KeyForward maps a remote key received by the adapter to a key press sent
+// to another device, e.g. the TV remote's volume keys to the amplifier.
+type KeyForward struct {
+	Source      *int `json:"source,omitempty"`       // initiator to match (omit to match any)
+	Keycode     int  `json:"keycode"`                // received keycode
+	Dest        int  `json:"dest"`                   // logical address to send to
+	DestKeycode *int `json:"dest_keycode,omitempty"` // keycode to send (defaults to Keycode)
+}
+
+const (
+	// keySourceWindow is how long the initiator of a User Control Pressed
+	// frame is trusted as the source of the following key press callback.
+	keySourceWindow = 500 * time.Millisecond
+	// keyEchoWindow suppresses forwarding a key that arrives right after we
+	// forwarded the same key, so two devices can't bounce it forever.
+	keyEchoWindow = 500 * time.Millisecond
+)
+
+// KeyForwarder applies KeyForward rules to key presses received from libcec.
+type KeyForwarder struct {
+	mu            sync.Mutex
+	rules         []KeyForward
+	lastSource    cec.LogicalAddress
+	lastSourceAt  time.Time
+	lastForwarded cec.Keycode
+	lastForwardAt time.Time
+}
+
+var keyForwarder = &KeyForwarder{}
+
+// validateKeyForwards checks that every rule uses valid addresses and keycodes.
+func validateKeyForwards(rules []KeyForward) error {
+	for i, r := range rules {
+		if r.Source != nil && (*r.Source < 0 || *r.Source > 15) {
+			return fmt.Errorf("key_forwards[%d]: invalid source address %d", i, *r.Source)
+		}
+		if r.Dest < 0 || r.Dest > 15 {
+			return fmt.Errorf("key_forwards[%d]: invalid dest address %d", i, r.Dest)
+		}
+		if r.Keycode < 0 || r.Keycode > 0xFF {
+			return fmt.Errorf("key_forwards[%d]: invalid keycode %d", i, r.Keycode)
+		}
+		if r.DestKeycode != nil && (*r.DestKeycode < 0 || *r.DestKeycode > 0xFF) {
+			return fmt.Errorf("key_forwards[%d]: invalid dest_keycode %d", i, *r.DestKeycode)
+		}
+		if r.Source != nil && *r.Source == r.Dest {
+			return fmt.Errorf("key_forwards[%d]: source and dest are both %d", i, r.Dest)
+		}
+	}
+	return nil
+}
+
+// SetRules replaces the forwarding rules.
+func (f *KeyForwarder) SetRules(rules []KeyForward) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+}
+
+// NoteKeySource records the initiator of a User Control Pressed frame, which
+// libcec's key press callback doesn't carry.
+func (f *KeyForwarder) NoteKeySource(addr cec.LogicalAddress) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastSource = addr
+	f.lastSourceAt = time.Now()
+}
+
+// match returns the destination and keycode of the first rule matching key
+// from the most recent source, if any.
+func (f *KeyForwarder) match(key cec.Keycode) (cec.LogicalAddress, cec.Keycode, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if key == f.lastForwarded && now.Sub(f.lastForwardAt) < keyEchoWindow {
+		return 0, 0, false
+	}
+
+	haveSource := now.Sub(f.lastSourceAt) < keySourceWindow
+	for _, r := range f.rules {
+		if r.Keycode != int(key) {
+			continue
+		}
+		if r.Source != nil && (!haveSource || cec.LogicalAddress(*r.Source) != f.lastSource) {
+			continue
+		}
+		if haveSource && cec.LogicalAddress(r.Dest) == f.lastSource {
+			continue // never send a key back to where it came from
+		}
+		destKey := key
+		if r.DestKeycode != nil {
+			destKey = cec.Keycode(*r.DestKeycode)
+		}
+		f.lastForwarded = destKey
+		f.lastForwardAt = now
+		return cec.LogicalAddress(r.Dest), destKey, true
+	}
+	return 0, 0, false
+}
+
+// HandleKeyPress forwards key according to the first matching rule. The key
+// is sent from a separate goroutine so the libcec callback isn't blocked.
+func (f *KeyForwarder) HandleKeyPress(key cec.Keycode) {
+	dest, destKey, ok := f.match(key)
+	if !ok {
+		return
+	}
+
+	go func() {
+		if !cecIsReady() {
+			return
+		}
+		self := false
+		err := withCEC(func() error {
+			for _, own := range cecConn.GetLogicalAddresses() {
+				if own == dest {
+					self = true // forwarding to ourselves would loop
+					return nil
+				}
+			}
+			return cecConn.SendButton(dest, destKey)
+		})
+		if self {
+			return
+		}
+		if err != nil {
+			log.Printf("Key forward 0x%02X -> device %d (0x%02X) failed: %v", key, dest, destKey, err)
+			return
+		}
+		log.Printf("Forwarded key 0x%02X -> device %d (0x%02X)", key, dest, destKey)
+	}()
+}
+
+// ── CEC initialization ─────────────────────────────────────────────────
+
+// Default backoff between CEC initialization attempts.
+const (
+	defaultInitBackoff    = 3 * time.Second
+	defaultInitMaxBackoff = 60 * time.Second
+)
+
+// Backoff is an exponential backoff between a minimum and maximum delay.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	current time.Duration
+}
+
+// Validate checks that both delays are positive and Initial <= Max.
+func (b *Backoff) Validate() error {
+	if b.Initial <= 0 {
+		return fmt.Errorf("initial backoff must be positive, got %v", b.Initial)
+	}
+	if b.Max <= 0 {
+		return fmt.Errorf("max backoff must be positive, got %v", b.Max)
+	}
+	if b.Initial > b.Max {
+		return fmt.Errorf("initial backoff %v exceeds max backoff %v", b.Initial, b.Max)
+	}
+	return nil
+}
+
+// Next returns the delay before the next attempt, doubling it each call up
+// to Max.
+func (b *Backoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.Initial
+	} else if b.current < b.Max {
+		b.current *= 2
+		if b.current > b.Max {
+			b.current = b.Max
+		}
+	}
+	return b.current
+}
+
+// persistentFailureAttempts is the number of consecutive failed attempts
+// after which adapter problems are reported as persistent.
+const persistentFailureAttempts = 3
+
+// AdapterSearchStatus describes the progress of opening the CEC adapter, so
+// a failing retry loop is visible through the API.
+type AdapterSearchStatus struct {
+	State               string    `json:"state"` // "initializing", "searching", "opening", "ready", "init_failed", "not_found", "open_failed", "connection_lost"
+	Attempts            int       `json:"attempts"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	PersistentFailure   bool      `json:"persistent_failure"`
+	Adapter             string    `json:"adapter,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	Hints               []string  `json:"hints,omitempty"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+var (
+	adapterStatusMu sync.Mutex
+	adapterStatus   = AdapterSearchStatus{State: "initializing"}
+)
+
+// getAdapterStatus returns a copy of the current adapter search status.
+func getAdapterStatus() AdapterSearchStatus {
+	adapterStatusMu.Lock()
+	defer adapterStatusMu.Unlock()
+	st := adapterStatus
+	st.Hints = append([]string(nil), adapterStatus.Hints...)
+	return st
+}
+
+// setAdapterState records progress of the current attempt.
+func setAdapterState(state, adapter string) {
+	adapterStatusMu.Lock()
+	defer adapterStatusMu.Unlock()
+	adapterStatus.State = state
+	adapterStatus.Adapter = adapter
+	adapterStatus.UpdatedAt = time.Now()
+	if state == "ready" {
+		adapterStatus.ConsecutiveFailures = 0
+		adapterStatus.PersistentFailure = false
+		adapterStatus.LastError = ""
+		adapterStatus.Hints = nil
+	}
+}
+
+// recordAdapterFailure records a failed attempt along with remediation
+// hints for it.
+func recordAdapterFailure(state, adapter string, err error) {
+	hints := adapterHints(state, adapter)
+	adapterStatusMu.Lock()
+	defer adapterStatusMu.Unlock()
+	adapterStatus.State = state
+	adapterStatus.Adapter = adapter
+	adapterStatus.ConsecutiveFailures++
+	adapterStatus.PersistentFailure = adapterStatus.ConsecutiveFailures >= persistentFailureAttempts
+	adapterStatus.LastError = ""
+	if err != nil {
+		adapterStatus.LastError = err.Error()
+	}
+	adapterStatus.Hints = hints
+	adapterStatus.UpdatedAt = time.Now()
+}
+
+// adapterDeviceGlobs are device nodes CEC adapters commonly appear as.
+var adapterDeviceGlobs = []string{"/dev/ttyACM*", "/dev/cec*", "/dev/vchiq"}
+
+// adapterHints suggests likely causes for a failed attempt, based on the
+// stage that failed and what is visible under /dev.
+func adapterHints(state, adapter string) []string {
+	switch state {
+	case "init_failed":
+		return []string{"libcec failed to initialise: check that libcec is installed and matches the version capi was built against"}
+	case "open_failed":
+		hints := []string{fmt.Sprintf("Adapter %s was found but could not be opened: it may be in use by another program (e.g. Kodi or cec-client)", adapter)}
+		if strings.HasPrefix(adapter, "/dev/") {
+			hints = append(hints, devicePermissionHints([]string{adapter})...)
+		}
+		return hints
+	case "not_found":
+		var nodes []string
+		for _, pattern := range adapterDeviceGlobs {
+			matches, _ := filepath.Glob(pattern)
+			nodes = append(nodes, matches...)
+		}
+		if len(nodes) == 0 {
+			return []string{"No adapter device nodes found (/dev/ttyACM*, /dev/cec*): check that the adapter is plugged in, or pass its path with -adapter"}
+		}
+		hints := devicePermissionHints(nodes)
+		if len(hints) == 0 {
+			hints = append(hints, fmt.Sprintf("Device nodes exist (%s) but libcec detected no adapter: check that libcec supports this adapter, or pass its path with -adapter", strings.Join(nodes, ", ")))
+		}
+		return hints
+	}
+	return nil
+}
+
+// devicePermissionHints returns a hint for each device node the service
+// cannot open for reading and writing.
+func devicePermissionHints(nodes []string) []string {
+	var hints []string
+	for _, node := range nodes {
+		// access(2) checks permissions without opening the device, which
+		// could reset some adapters.
+		const readWrite = 0x4 | 0x2
+		if err := syscall.Access(node, readWrite); errors.Is(err, os.ErrPermission) {
+			group := "dialout"
+			if strings.HasPrefix(node, "/dev/cec") || node == "/dev/vchiq" {
+				group = "video"
+			}
+			hints = append(hints, fmt.Sprintf("Permission denied on %s: add the service user to the %s group", node, group))
+		}
+	}
+	return hints
+}
+
+// initCEC opens the CEC adapter, retrying with backoff until it succeeds,
+// then publishes the connection and starts the MQTT bridge if configured.
+// An empty adapterPath auto-detects the first adapter.
+func initCEC(deviceName, adapterPath string, deviceType cec.DeviceType, backoff Backoff) {
+	for {
+		if conn, adapter, ok := openCEC(deviceName, adapterPath, deviceType, &backoff); ok {
+			log.Println("CEC connection established")
+			log.Println(conn.GetLibInfo())
+
+			if lang := currentConfig.CEC.MenuLanguage; lang != "" {
+				applyMenuLanguage(conn, lang)
+			}
+			applyAdapterAddress(conn, currentConfig.CEC)
+			checkAdapterFirmware(conn)
+
+			// Wait for CEC bus to settle
+			time.Sleep(2 * time.Second)
+
+			publishCECConnection(conn, adapter)
+			setAdapterState("ready", adapter)
+			mqttNotifyCECReady(true)
+
+			log.Println("CEC adapter is ready")
+
+			// Start MQTT bridge if configured
+			if currentConfig.MQTT.Broker != "" {
+				startMQTT(currentConfig.MQTT)
+			}
+			return
+		}
+	}
+}
+
+// openCEC makes one attempt to initialize libcec and open the adapter. On
+// failure it records why, sleeps for the next backoff delay and returns false.
+func openCEC(deviceName, adapterPath string, deviceType cec.DeviceType, backoff *Backoff) (*cec.Connection, string, bool) {
+	adapterStatusMu.Lock()
+	adapterStatus.Attempts++
+	adapterStatusMu.Unlock()
+
+	log.Println("Initializing CEC connection...")
+	setAdapterState("initializing", "")
+	conn, err := cec.Open(deviceName, deviceType)
+	if err != nil {
+		delay := backoff.Next()
+		log.Printf("Failed to initialize CEC: %v — retrying in %v", err, delay)
+		recordAdapterFailure("init_failed", "", err)
+		time.Sleep(delay)
+		return nil, "", false
+	}
+
+	conn.SetCallbackHandler(logHandler)
+	conn.OnTransmit(recordTransmit)
+
+	// Find adapter
+	adapter := adapterPath
+	if adapter == "" {
+		log.Println("Searching for CEC adapters...")
+		setAdapterState("searching", "")
+		adapters, err := conn.FindAdapters()
+		if err != nil || len(adapters) == 0 {
+			delay := backoff.Next()
+			log.Printf("No CEC adapters found — retrying in %v", delay)
+			if err == nil {
+				err = errors.New("no CEC adapters found")
+			}
+			recordAdapterFailure("not_found", "", err)
+			conn.Close()
+			time.Sleep(delay)
+			return nil, "", false
+		}
+		if adapters[0].Comm != "" && strings.HasPrefix(adapters[0].Comm, "/dev/") {
+			adapter = adapters[0].Comm
+		} else {
+			adapter = adapters[0].Path
+		}
+		log.Printf("Found adapter: %s", adapter)
+	}
+
+	// Open adapter
+	log.Printf("Opening CEC adapter: %s", adapter)
+	setAdapterState("opening", adapter)
+	if err := conn.OpenAdapter(adapter); err != nil {
+		delay := backoff.Next()
+		log.Printf("Failed to open CEC adapter: %v — retrying in %v", err, delay)
+		recordAdapterFailure("open_failed", adapter, err)
+		conn.Close()
+		time.Sleep(delay)
+		return nil, "", false
+	}
+
+	return conn, adapter, true
+}
+
+// FirmwareStatus reports whether the adapter's firmware is older than the
+// latest version libcec knows about. Old Pulse-Eight firmware is a common
+// cause of flaky behaviour.
+type FirmwareStatus struct {
+	UpgradeRecommended bool   `json:"firmware_upgrade_recommended"`
+	Version            int    `json:"firmware_version,omitempty"`    // 0 when the adapter doesn't report one
+	BuildDate          string `json:"firmware_build_date,omitempty"` // RFC 3339
+	LatestVersion      int    `json:"latest_firmware_version,omitempty"`
+	Reason             string `json:"reason,omitempty"`
+}
+
+var (
+	firmwareMu     sync.Mutex
+	firmwareStatus FirmwareStatus
+	firmwareWarned bool
+)
+
+// getFirmwareStatus returns a copy of the adapter firmware status.
+func getFirmwareStatus() FirmwareStatus {
+	firmwareMu.Lock()
+	defer firmwareMu.Unlock()
+	return firmwareStatus
+}
+
+// recommendFirmwareUpgrade flags the firmware as outdated and logs a
+// warning the first time.
+func recommendFirmwareUpgrade(reason string) {
+	firmwareMu.Lock()
+	defer firmwareMu.Unlock()
+	firmwareStatus.UpgradeRecommended = true
+	if firmwareStatus.Reason == "" {
+		firmwareStatus.Reason = reason
+	}
+	if !firmwareWarned {
+		firmwareWarned = true
+		log.Printf("Warning: CEC adapter firmware upgrade recommended: %s", reason)
+	}
+}
+
+// checkAdapterFirmware compares the opened adapter's firmware against the
+// latest version known to libcec.
+func checkAdapterFirmware(conn *cec.Connection) {
+	config, err := conn.GetCurrentConfiguration()
+	if err != nil {
+		return
+	}
+
+	firmwareMu.Lock()
+	firmwareStatus.Version = int(config.FirmwareVersion)
+	firmwareStatus.LatestVersion = int(cec.LatestAdapterFirmwareVersion)
+	if config.FirmwareBuildDate != 0 {
+		firmwareStatus.BuildDate = time.Unix(int64(config.FirmwareBuildDate), 0).UTC().Format(time.RFC3339)
+	}
+	firmwareMu.Unlock()
+
+	if cec.FirmwareUpgradeRecommended(config.FirmwareVersion, config.FirmwareBuildDate) {
+		recommendFirmwareUpgrade(fmt.Sprintf("adapter runs firmware v%d, latest is v%d",
+			config.FirmwareVersion, cec.LatestAdapterFirmwareVersion))
+	}
+}
+
+// noteFirmwareLogMessage flags the firmware as outdated when libcec logs
+// that the adapter should be upgraded.
+func noteFirmwareLogMessage(message string) {
+	lower := strings.ToLower(message)
+	if strings.Contains(lower, "firmware") &&
+		(strings.Contains(lower, "upgrade") || strings.Contains(lower, "outdated")) {
+		recommendFirmwareUpgrade("libcec: " + message)
+	}
+}
+
+// ── Configuration persistence ──────────────────────────────────────────
+
+// MQTTConfig holds MQTT broker connection settings.
+type MQTTConfig struct {
+	Broker string `json:"broker"`
+	User   string `json:"user"`
+	Pass   string `json:"pass"`
+	Prefix string `json:"prefix"`
+	// Discovery publishes Home Assistant MQTT discovery configs on connect.
+	Discovery bool `json:"discovery,omitempty"`
+	// CACert is a PEM file of CA certificates to verify a TLS broker
+	// against instead of the system roots.
+	CACert string `json:"ca_cert,omitempty"`
+	// InsecureSkipVerify disables verification of the broker's TLS
+	// certificate, for self-signed brokers.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// QoS and Retain apply to {prefix}/event publishes.
+	QoS    byte `json:"qos,omitempty"`
+	Retain bool `json:"retain,omitempty"`
+}
+
+// AuthConfig holds HTTP API authentication settings.
+type AuthConfig struct {
+	// Token, when set, is required as "Authorization: Bearer <token>" on
+	// every /api endpoint except health checks.
+	Token string `json:"token,omitempty"`
+}
+
+// APIConfig holds HTTP API behaviour settings.
+type APIConfig struct {
+	// PartialContentStatus returns 206 Partial Content instead of 200 when
+	// a device scan hits its deadline before every device was queried.
+	PartialContentStatus bool `json:"partial_content_status"`
+}
+
+// CECConfig holds CEC bus behaviour settings.
+type CECConfig struct {
+	// SkipWake disables the Image View On wake-up sent before source
+	// switching. Can be overridden per request with ?wake=0|1.
+	SkipWake bool `json:"skip_wake"`
+	// WakeMode is the wake-up opcode: "image_view_on" (the default) or
+	// "text_view_on", for TVs that ignore Image View On.
+	WakeMode string `json:"wake_mode,omitempty"`
+	// SwitchRetries re-sends source switches this many more times, stopping
+	// early once the TV reports the new active source. Can be overridden per
+	// request with ?retries=N.
+	SwitchRetries    int      `json:"switch_retries,omitempty"`
+	SwitchRetryDelay Duration `json:"switch_retry_delay,omitempty"`
+	// MenuLanguage is the ISO 639-2 menu language the adapter advertises
+	// (e.g. "deu"). Empty keeps libcec's default.
+	MenuLanguage string `json:"menu_language,omitempty"`
+	// InitBackoff and InitMaxBackoff bound the exponential backoff between
+	// attempts to open the adapter (defaults 3s and 60s).
+	InitBackoff    Duration `json:"init_backoff,omitempty"`
+	InitMaxBackoff Duration `json:"init_max_backoff,omitempty"`
+	// CommandTimeout bounds each CEC operation made by an HTTP handler;
+	// requests that exceed it get 504 Gateway Timeout (default 10s).
+	CommandTimeout Duration `json:"command_timeout,omitempty"`
+	// DeviceName is the adapter's OSD name. An explicit -name overrides it.
+	DeviceName string `json:"device_name,omitempty"`
+	// HDMIPort and PhysicalAddress pin the adapter's HDMI port (on the TV)
+	// or full physical address instead of auto-detecting it. The physical
+	// address, in dot notation, takes precedence.
+	HDMIPort        int    `json:"hdmi_port,omitempty"`
+	PhysicalAddress string `json:"physical_address,omitempty"`
+	// AdapterPath pins the adapter (e.g. "/dev/cec0") instead of using the
+	// first one found. Overridden by -adapter.
+	AdapterPath string `json:"adapter_path,omitempty"`
+	// DeviceType is the device type the adapter registers as, e.g.
+	// "playback_device" (default "recording_device"). Overridden by
+	// -device-type.
+	DeviceType string `json:"device_type,omitempty"`
+}
+
+// Duration is a time.Duration that is written to config.json as a string
+// such as "3s" or "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"3s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config is the on-disk configuration file format.
+type Config struct {
+	MQTT MQTTConfig `json:"mqtt"`
+	CEC  CECConfig  `json:"cec"`
+	API  APIConfig  `json:"api"`
+	Auth AuthConfig `json:"auth"`
+
+	// Bind is the HTTP listen address set with /api/settings/bind. The
+	// -bind flag takes precedence when given.
+	Bind string `json:"bind,omitempty"`
+
+	// EventLogFile, when set, appends every CEC event to this file as one
+	// JSON object per line. The file is rotated when it would exceed
+	// EventLogMaxSizeMB, keeping EventLogMaxFiles rotated copies.
+	EventLogFile      string `json:"event_log_file,omitempty"`
+	EventLogMaxSizeMB int    `json:"event_log_max_size_mb,omitempty"`
+	EventLogMaxFiles  int    `json:"event_log_max_files,omitempty"`
+
+	// KeyForwards translates remote keys received by the adapter into key
+	// presses sent to other devices.
+	KeyForwards []KeyForward `json:"key_forwards,omitempty"`
+
+	// QuietHours blocks power-on, wake and source switching commands
+	// during a daily window.
+	QuietHours QuietHoursConfig `json:"quiet_hours"`
+
+	// OSDNameOverrides replaces the OSD name reported by a device, keyed by
+	// logical address, wherever capi reports osd_name.
+	OSDNameOverrides map[cec.LogicalAddress]string `json:"osd_name_overrides,omitempty"`
+
+	// VendorNames adds to or overrides the built-in vendor names, keyed by
+	// vendor ID in hex (e.g. "0x00A0DE").
+	VendorNames map[string]string `json:"vendor_names,omitempty"`
+
+	// Scenes maps a scene name to the steps run by POST
+	// /api/scenes/{name}/run.
+	Scenes map[string][]SceneStep `json:"scenes,omitempty"`
+
+	// Schedules run a scene or a single action at times given by cron
+	// expressions.
+	Schedules []Schedule `json:"schedules,omitempty"`
+
+	// GitHubToken authenticates update checks and downloads, raising the
+	// GitHub API rate limit. $GITHUB_TOKEN takes precedence.
+	GitHubToken string `json:"github_token,omitempty"`
+}
+
+var (
+	currentConfig  Config
+	configMu       sync.RWMutex
+	configFilePath string
+)
+
+// loadConfig reads and parses the config file. Returns zero Config if not found.
+func loadConfig(path string) Config {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg
+	}
+	_ = json.Unmarshal(data, &cfg)
+	return cfg
+}
+
+// saveConfig atomically writes the config file.
+func saveConfig(path string, cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ── Device list snapshot ───────────────────────────────────────────────
+
+// DeviceSnapshot is the last complete /api/devices result, saved next to
+// config.json so the device list can be served right after a restart while
+// the adapter is still being opened.
+type DeviceSnapshot struct {
+	SavedAt time.Time    `json:"saved_at"`
+	Devices []cec.Device `json:"devices"`
+}
+
+var (
+	deviceSnapshot      *DeviceSnapshot
+	deviceSnapshotMu    sync.Mutex
+	deviceCacheFilePath string
+)
+
+// loadDeviceCache reads the saved device snapshot. Returns nil if there is
+// none or it can't be parsed.
+func loadDeviceCache(path string) *DeviceSnapshot {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var snap DeviceSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		log.Printf("Ignoring unreadable device cache %s: %v", path, err)
+		return nil
+	}
+	return &snap
+}
+
+// saveDeviceCache atomically writes the device snapshot.
+func saveDeviceCache(path string, snap DeviceSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// recordDeviceSnapshot keeps devices as the latest complete scan and saves
+// it when the device list changed, so repeated dashboard refreshes don't
+// rewrite the file. An empty scan is not recorded.
+func recordDeviceSnapshot(devices []cec.Device) {
+	if len(devices) == 0 {
+		return
+	}
+	deviceSnapshotMu.Lock()
+	defer deviceSnapshotMu.Unlock()
+	if deviceSnapshot != nil && reflect.DeepEqual(deviceSnapshot.Devices, devices) {
+		return
+	}
+	deviceSnapshot = &DeviceSnapshot{SavedAt: time.Now(), Devices: devices}
+	if deviceCacheFilePath == "" {
+		return
+	}
+	if err := saveDeviceCache(deviceCacheFilePath, *deviceSnapshot); err != nil {
+		log.Printf("Failed to save device cache: %v", err)
+	}
+}
+
+// serveDeviceSnapshot responds with the saved device list, each device
+// marked is_stale, and reports whether there was one to serve.
+func serveDeviceSnapshot(w http.ResponseWriter) bool {
+	deviceSnapshotMu.Lock()
+	snap := deviceSnapshot
+	deviceSnapshotMu.Unlock()
+	if snap == nil {
+		return false
+	}
+	result := make([]map[string]interface{}, 0, len(snap.Devices))
+	for i := range snap.Devices {
+		m := deviceToMap(&snap.Devices[i])
+		m["is_stale"] = true
+		m["saved_at"] = snap.SavedAt
+		result = append(result, m)
+	}
+	respondSuccess(w, "Devices retrieved from cache (CEC adapter not available yet)", result)
+	return true
+}
+
+// ── MQTT bridge ────────────────────────────────────────────────────────
 
 var (
 	mqttClient mqtt.Client
 	mqttMu     sync.Mutex
 	mqttCancel context.CancelFunc
+	// mqttTopicPrefix is the topic prefix of the running client.
+	mqttTopicPrefix string
+	// mqttEventQoS and mqttEventRetain are the validated qos and retain
+	// settings of the running client, for {prefix}/event publishes.
+	mqttEventQoS    byte
+	mqttEventRetain bool
+	// mqttDiscovered is set once Home Assistant discovery configs have been
+	// published, so stopMQTT knows to clear them.
+	mqttDiscovered bool
+)
+
+// stopMQTT disconnects the MQTT client and cancels the event-forwarding goroutine.
+func stopMQTT() {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttCancel != nil {
+		mqttCancel()
+		mqttCancel = nil
+	}
+	if mqttClient != nil && mqttClient.IsConnected() {
+		if mqttDiscovered {
+			clearHADiscovery(mqttClient, mqttTopicPrefix)
+			mqttDiscovered = false
+		}
+		// The broker only sends the will on an unclean disconnect
+		mqttClient.Publish(mqttTopicPrefix+"/status", 1, true, "offline").WaitTimeout(time.Second)
+		mqttClient.Disconnect(1000)
+		log.Println("[MQTT] Disconnected")
+	}
+	mqttClient = nil
+}
+
+// startMQTT connects to the broker, subscribes to command topics, and
+// forwards EventHub events to MQTT publish topics. Safe to call multiple
+// times; previous connections are torn down first.
+func startMQTT(cfg MQTTConfig) {
+	stopMQTT()
+
+	broker, prefix := cfg.Broker, cfg.Prefix
+	if cfg.QoS > 2 {
+		log.Printf("[MQTT] Invalid qos %d in config, using 0", cfg.QoS)
+		cfg.QoS = 0
+	}
+	host, _ := os.Hostname()
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("capi-%s-%d", host, os.Getpid())).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		SetWill(prefix+"/status", "offline", 1, true).
+		// paho calls this again after every automatic reconnect
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("[MQTT] Connected to %s", broker)
+			cmdTopic := prefix + "/command/#"
+			token := c.Subscribe(cmdTopic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+				handleMQTTCommand(prefix, msg.Topic(), msg.Payload())
+			})
+			if token.Wait() && token.Error() != nil {
+				log.Printf("[MQTT] Subscribe failed: %v", token.Error())
+			} else {
+				log.Printf("[MQTT] Subscribed to %s", cmdTopic)
+			}
+			go mqttOnConnect(c, prefix)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("[MQTT] Connection lost: %v", err)
+		})
+
+	if cfg.User != "" {
+		opts.SetUsername(cfg.User)
+	}
+	if cfg.Pass != "" {
+		opts.SetPassword(cfg.Pass)
+	}
+	if mqttUsesTLS(broker) {
+		tlsConfig, err := mqttTLSConfig(cfg)
+		if err != nil {
+			log.Printf("[MQTT] Not connecting: %v", err)
+			return
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mqttMu.Lock()
+	mqttCancel = cancel
+	mqttTopicPrefix = prefix
+	mqttEventQoS, mqttEventRetain = cfg.QoS, cfg.Retain
+	mqttClient = mqtt.NewClient(opts)
+	client := mqttClient
+	mqttMu.Unlock()
+
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Printf("[MQTT] Initial connection failed (will retry): %v", token.Error())
+	}
+
+	// Goroutine: forward EventHub events to MQTT
+	go func() {
+		ch := eventHub.Subscribe()
+		defer eventHub.Unsubscribe(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				// client is the same object across paho's automatic
+				// reconnects; startMQTT cancels this goroutine before
+				// replacing it.
+				if !client.IsConnected() {
+					continue
+				}
+				topic := prefix + "/event/" + ev.Type
+				payload, err := json.Marshal(ev.Data)
+				if err != nil {
+					continue
+				}
+				client.Publish(topic, cfg.QoS, cfg.Retain, payload)
+				publishMQTTState(client, prefix, ev)
+			}
+		}
+	}()
+}
+
+// mqttPublisher is the part of mqtt.Client used to publish bridge state.
+type mqttPublisher interface {
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+}
+
+// mqttOnConnect republishes the retained topics after every (re)connect.
+// The broker may have lost retained messages, or our will may have replaced
+// status while we were away.
+func mqttOnConnect(c mqttPublisher, prefix string) {
+	discovery := mqttDiscoveryEnabled()
+	publishMQTTOnline(c, prefix, discovery)
+	if discovery {
+		mqttMu.Lock()
+		mqttDiscovered = true
+		mqttMu.Unlock()
+	}
+	seedMQTTState(c, prefix)
+}
+
+// publishMQTTOnline publishes everything a subscriber needs after capi
+// (re)connects: status, cec_ready and, with discovery, the Home Assistant
+// configs. All of it is retained and safe to publish repeatedly.
+func publishMQTTOnline(c mqttPublisher, prefix string, discovery bool) {
+	c.Publish(prefix+"/status", 1, true, "online")
+	publishCECReady(c, prefix, cecIsReady())
+	if discovery {
+		publishHADiscovery(c, prefix)
+	}
+}
+
+// publishMQTTState updates the retained {prefix}/state topics from a
+// power_change or source_activated event, so late subscribers see the
+// latest known state.
+func publishMQTTState(c mqttPublisher, prefix string, ev CECEvent) {
+	data, ok := ev.Data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	addr, ok := data["address"].(int)
+	if !ok {
+		return
+	}
+	switch ev.Type {
+	case "power_change":
+		status, _ := data["status"].(string)
+		c.Publish(fmt.Sprintf("%s/state/power/%d", prefix, addr), 1, true, status)
+	case "source_activated":
+		if activated, _ := data["activated"].(bool); activated {
+			c.Publish(prefix+"/state/active_source", 1, true, strconv.Itoa(addr))
+		}
+	}
+}
+
+// seedMQTTState publishes the retained state topics from a fresh query of
+// the bus, so they are correct even if nothing changed since capi started.
+func seedMQTTState(c mqttPublisher, prefix string) {
+	if !cecIsReady() {
+		return
+	}
+	var active []cec.LogicalAddress
+	if err := withCEC(func() error {
+		active = cecConn.GetActiveDevices()
+		return nil
+	}); err != nil {
+		log.Printf("[MQTT] State seed failed: %v", err)
+		return
+	}
+	for _, addr := range active {
+		status, err := queryPowerStatus(addr, powerStatusQueryTimeout)
+		if err != nil {
+			continue
+		}
+		c.Publish(fmt.Sprintf("%s/state/power/%d", prefix, int(addr)), 1, true, powerStatusFromByte(uint8(status)))
+	}
+	var source cec.LogicalAddress
+	if err := withCEC(func() (err error) {
+		source, err = cecConn.GetActiveSource()
+		return err
+	}); err == nil && source != cec.LogicalAddressUnknown {
+		c.Publish(prefix+"/state/active_source", 1, true, strconv.Itoa(int(source)))
+	}
+}
+
+// mqttRescanInterval is the minimum time between rescans requested through
+// {prefix}/command/rescan.
+const mqttRescanInterval = 10 * time.Second
+
+var (
+	mqttRescanMu   sync.Mutex
+	mqttLastRescan time.Time
 )
 
-// stopMQTT disconnects the MQTT client and cancels the event-forwarding goroutine.
-func stopMQTT() {
+// allowMQTTRescan reports whether an MQTT rescan may run now, and if so
+// records it.
+func allowMQTTRescan() bool {
+	mqttRescanMu.Lock()
+	defer mqttRescanMu.Unlock()
+	if time.Since(mqttLastRescan) < mqttRescanInterval {
+		return false
+	}
+	mqttLastRescan = time.Now()
+	return true
+}
+
+// mqttRescan rescans the bus, publishes the device list to the retained
+// {prefix}/state/devices topic, and republishes the state topics and
+// Home Assistant discovery configs.
+func mqttRescan(prefix string) {
+	var addresses []cec.LogicalAddress
+	err := withCECTimeout(rescanSettle+commandTimeout(), func() error {
+		if err := cecConn.RescanDevicesWithSettle(rescanSettle); err != nil {
+			return err
+		}
+		addresses = cecConn.GetActiveDevices()
+		return nil
+	})
+	if err != nil {
+		log.Printf("[MQTT] rescan failed: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deviceScanDeadline)
+	defer cancel()
+	result := make([]map[string]interface{}, 0, len(addresses))
+	devices := make([]cec.Device, 0, len(addresses))
+	for i, addr := range addresses {
+		if ctx.Err() != nil {
+			break
+		}
+		var dev *cec.Device
+		err := withDeviceDeadline(ctx, len(addresses)-i, func(ctx context.Context) (err error) {
+			dev, err = cecConn.GetDeviceInfoCachedContext(ctx, addr, 0)
+			return err
+		})
+		if err == nil {
+			result = append(result, deviceToMap(dev))
+			devices = append(devices, *dev)
+		}
+	}
+	if ctx.Err() == nil {
+		recordDeviceSnapshot(devices)
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
 	mqttMu.Lock()
-	defer mqttMu.Unlock()
-	if mqttCancel != nil {
-		mqttCancel()
-		mqttCancel = nil
+	c := mqttClient
+	mqttMu.Unlock()
+	if c == nil || !c.IsConnected() {
+		return
 	}
-	if mqttClient != nil && mqttClient.IsConnected() {
-		mqttClient.Disconnect(1000)
-		log.Println("[MQTT] Disconnected")
+	c.Publish(prefix+"/state/devices", 1, true, payload)
+	log.Printf("[MQTT] Rescan found %d devices", len(result))
+
+	seedMQTTState(c, prefix)
+	if mqttDiscoveryEnabled() {
+		publishHADiscovery(c, prefix)
 	}
-	mqttClient = nil
 }
 
-// startMQTT connects to the broker, subscribes to command topics, and
-// forwards EventHub events to MQTT publish topics. Safe to call multiple
-// times; previous connections are torn down first.
-func startMQTT(broker, user, pass, prefix string) {
-	stopMQTT()
+// mqttRunScene runs a scene started from {prefix}/command/scene and
+// publishes its per-step results.
+func mqttRunScene(prefix, name string, steps []SceneStep) {
+	start := time.Now()
+	results, err := runScene(steps)
+	if err != nil {
+		log.Printf("[MQTT] scene %q failed: %v", name, err)
+	}
+	mqttMu.Lock()
+	c, qos, retain := mqttClient, mqttEventQoS, mqttEventRetain
+	mqttMu.Unlock()
+	if c == nil || !c.IsConnected() {
+		return
+	}
+	publishSceneResult(c, prefix, qos, retain, name, results, time.Since(start), err)
+}
 
-	host, _ := os.Hostname()
-	opts := mqtt.NewClientOptions().
-		AddBroker(broker).
-		SetClientID(fmt.Sprintf("capi-%s-%d", host, os.Getpid())).
-		SetAutoReconnect(true).
-		SetConnectRetry(true).
-		SetConnectRetryInterval(10 * time.Second).
-		SetOnConnectHandler(func(c mqtt.Client) {
-			log.Printf("[MQTT] Connected to %s", broker)
-			cmdTopic := prefix + "/command/#"
-			token := c.Subscribe(cmdTopic, 1, func(_ mqtt.Client, msg mqtt.Message) {
-				handleMQTTCommand(prefix, msg.Topic(), msg.Payload())
-			})
-			if token.Wait() && token.Error() != nil {
-				log.Printf("[MQTT] Subscribe failed: %v", token.Error())
-			} else {
-				log.Printf("[MQTT] Subscribed to %s", cmdTopic)
+// publishSceneResult publishes the outcome of a scene run to
+// {prefix}/event/scene_result: the fields POST /api/scenes/{name}/run
+// returns, plus the error of the step that failed, if any.
+func publishSceneResult(c mqttPublisher, prefix string, qos byte, retain bool, name string, results []SceneStepResult, d time.Duration, err error) {
+	result := map[string]interface{}{
+		"name":        name,
+		"steps":       results,
+		"duration_ms": d.Milliseconds(),
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	payload, jerr := json.Marshal(result)
+	if jerr != nil {
+		return
+	}
+	c.Publish(prefix+"/event/scene_result", qos, retain, payload)
+}
+
+// mqttUsesTLS reports whether a broker URL's scheme connects over TLS.
+func mqttUsesTLS(broker string) bool {
+	scheme, _, _ := strings.Cut(broker, "://")
+	switch scheme {
+	case "ssl", "tls", "mqtts", "wss":
+		return true
+	}
+	return false
+}
+
+// mqttTLSConfig builds the TLS configuration for a TLS broker from the CA
+// certificate and verification settings.
+func mqttTLSConfig(cfg MQTTConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// handleMQTTCommand dispatches an incoming MQTT message to the appropriate
+// CEC operation. Topic format: {prefix}/command/{action}[/{param}]
+func handleMQTTCommand(prefix, topic string, payload []byte) {
+	if !cecIsReady() {
+		log.Printf("[MQTT] Ignoring command %q: CEC adapter not available", topic)
+		return
+	}
+
+	cmdPath := strings.TrimPrefix(topic, prefix+"/command/")
+
+	powerSet := cmdPath == "power/set" || strings.HasPrefix(cmdPath, "power/set/")
+	if cmdPath == "power/on" || cmdPath == "source" || cmdPath == "hdmi" ||
+		(powerSet && strings.EqualFold(strings.TrimSpace(string(payload)), "ON")) {
+		if q, active := quietHoursActive(); active {
+			log.Printf("[MQTT] Ignoring %s: quiet hours in effect (%s-%s)", cmdPath, q.Start, q.End)
+			return
+		}
+	}
+
+	switch {
+	case cmdPath == "power/on":
+		addr := parseMQTTAddress(payload, 0)
+		if addr < 0 || addr > 15 {
+			log.Printf("[MQTT] power/on: invalid address %q", string(payload))
+			return
+		}
+		err := withCEC(func() error { return cecConn.PowerOn(cec.LogicalAddress(addr)) })
+		if err != nil {
+			log.Printf("[MQTT] power/on failed: %v", err)
+		}
+
+	case cmdPath == "power/off":
+		addr := parseMQTTAddress(payload, 0)
+		if addr < 0 || addr > 15 {
+			log.Printf("[MQTT] power/off: invalid address %q", string(payload))
+			return
+		}
+		err := withCEC(func() error { return cecConn.Standby(cec.LogicalAddress(addr)) })
+		if err != nil {
+			log.Printf("[MQTT] power/off failed: %v", err)
+		}
+
+	case powerSet:
+		// power/set[/{address}] with payload ON or OFF, for clients such as
+		// Home Assistant switches that drive one topic with both states.
+		addr := 0
+		if rest := strings.TrimPrefix(cmdPath, "power/set"); rest != "" {
+			addr = parseMQTTAddress([]byte(rest[1:]), -1)
+		}
+		if addr < 0 || addr > 15 {
+			log.Printf("[MQTT] %s: invalid address", cmdPath)
+			return
+		}
+		var err error
+		switch state := strings.ToUpper(strings.TrimSpace(string(payload))); state {
+		case "ON":
+			err = withCEC(func() error { return cecConn.PowerOn(cec.LogicalAddress(addr)) })
+		case "OFF":
+			err = withCEC(func() error { return cecConn.Standby(cec.LogicalAddress(addr)) })
+		default:
+			log.Printf("[MQTT] %s: invalid payload %q (want ON or OFF)", cmdPath, state)
+			return
+		}
+		if err != nil {
+			log.Printf("[MQTT] %s failed: %v", cmdPath, err)
+		}
+
+	case cmdPath == "volume/up":
+		err := withCEC(func() error { return cecConn.VolumeUp(true) })
+		if err != nil {
+			log.Printf("[MQTT] volume/up failed: %v", err)
+		}
+
+	case cmdPath == "volume/down":
+		err := withCEC(func() error { return cecConn.VolumeDown(true) })
+		if err != nil {
+			log.Printf("[MQTT] volume/down failed: %v", err)
+		}
+
+	case cmdPath == "volume/mute/on" || cmdPath == "volume/mute/off":
+		err := withCEC(func() error {
+			if cmdPath == "volume/mute/on" {
+				return cecConn.AudioMute()
 			}
-		}).
-		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
-			log.Printf("[MQTT] Connection lost: %v", err)
+			return cecConn.AudioUnmute()
 		})
+		if err != nil {
+			log.Printf("[MQTT] %s failed: %v", cmdPath, err)
+		}
+
+	case cmdPath == "volume/mute":
+		err := withCEC(func() error {
+			_, err := cecConn.ToggleMuteWithStatus()
+			return err
+		})
+		if err != nil {
+			log.Printf("[MQTT] volume/mute failed: %v", err)
+		}
+
+	case cmdPath == "source":
+		addr := parseMQTTAddress(payload, -1)
+		if addr < 0 || addr > 15 {
+			log.Printf("[MQTT] source: invalid address %q", string(payload))
+			return
+		}
+		opts := defaultSwitchOptions()
+		err := withSwitchTimeout(opts, func() error { return cecConn.SwitchToDeviceWithOptions(cec.LogicalAddress(addr), opts) })
+		if err != nil {
+			log.Printf("[MQTT] source failed: %v", err)
+		}
+
+	case cmdPath == "hdmi":
+		port := parseMQTTAddress(payload, -1)
+		if port < 1 || port > 15 {
+			log.Printf("[MQTT] hdmi: invalid port %q", string(payload))
+			return
+		}
+		opts := defaultSwitchOptions()
+		err := withSwitchTimeout(opts, func() error { return cecConn.SwitchToHDMIPortWithOptions(uint8(port), opts) })
+		if err != nil {
+			log.Printf("[MQTT] hdmi failed: %v", err)
+		}
+
+	case cmdPath == "key":
+		var req struct {
+			Address int    `json:"address"`
+			Key     string `json:"key"`
+			Keycode int    `json:"keycode"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			log.Printf("[MQTT] key: invalid payload: %v", err)
+			return
+		}
+		if req.Address < 0 || req.Address > 15 {
+			log.Printf("[MQTT] key: invalid address %d", req.Address)
+			return
+		}
+		var keycode cec.Keycode
+		if req.Key != "" {
+			k, ok := cec.KeycodeByName(req.Key)
+			if !ok {
+				log.Printf("[MQTT] key: unknown key name %q", req.Key)
+				return
+			}
+			keycode = k
+		} else {
+			keycode = cec.Keycode(req.Keycode)
+		}
+		if isWakeKey(keycode) {
+			if q, active := quietHoursActive(); active {
+				log.Printf("[MQTT] Ignoring key 0x%02X: quiet hours in effect (%s-%s)", uint8(keycode), q.Start, q.End)
+				return
+			}
+		}
+		if !keyLimiter.Allow() {
+			log.Printf("[MQTT] Ignoring key 0x%02X: rate limit exceeded", uint8(keycode))
+			return
+		}
+		err := withCEC(func() error { return cecConn.SendButton(cec.LogicalAddress(req.Address), keycode) })
+		if err != nil {
+			log.Printf("[MQTT] key failed: %v", err)
+		}
+
+	case cmdPath == "raw":
+		var req rawCommandRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			log.Printf("[MQTT] raw: invalid payload: %v", err)
+			return
+		}
+		cmd, err := buildRawCommand(req)
+		if err != nil {
+			log.Printf("[MQTT] raw: %v", err)
+			return
+		}
+		if isWakeOpcode(cmd.Opcode) {
+			if q, active := quietHoursActive(); active {
+				log.Printf("[MQTT] Ignoring raw opcode 0x%02X: quiet hours in effect (%s-%s)", uint8(cmd.Opcode), q.Start, q.End)
+				return
+			}
+		}
+		if !commandLimiter.Allow() {
+			log.Printf("[MQTT] Ignoring raw opcode 0x%02X: rate limit exceeded", uint8(cmd.Opcode))
+			return
+		}
+		err = withCEC(func() error { return cecConn.Transmit(cmd) })
+		if err != nil {
+			log.Printf("[MQTT] raw failed: %v", err)
+		}
+
+	case cmdPath == "scene":
+		name := strings.TrimSpace(string(payload))
+		steps, ok := lookupScene(name)
+		if !ok {
+			log.Printf("[MQTT] scene: unknown scene %q", name)
+			return
+		}
+		if sceneWakes(steps) {
+			if q, active := quietHoursActive(); active {
+				log.Printf("[MQTT] Ignoring scene %q: quiet hours in effect (%s-%s)", name, q.Start, q.End)
+				return
+			}
+		}
+		// Scenes can run for a while; don't hold up message handling
+		go mqttRunScene(prefix, name, steps)
+
+	case cmdPath == "rescan":
+		if !allowMQTTRescan() {
+			log.Printf("[MQTT] Ignoring rescan: last one was less than %v ago", mqttRescanInterval)
+			return
+		}
+		// Rescanning and querying every device takes seconds; don't hold up
+		// the client's message handling.
+		go mqttRescan(prefix)
+
+	default:
+		log.Printf("[MQTT] Unknown command topic: %s", topic)
+	}
+}
+
+// parseMQTTAddress parses a simple integer from the payload (trimmed).
+// Returns defaultVal if the payload is empty or not a valid integer.
+func parseMQTTAddress(payload []byte, defaultVal int) int {
+	s := strings.TrimSpace(string(payload))
+	if s == "" {
+		return defaultVal
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return defaultVal
+	}
+	return v
+}
+
+// ── Home Assistant discovery ───────────────────────────────────────────
+
+// haDiscoveryPrefix is Home Assistant's default MQTT discovery prefix.
+const haDiscoveryPrefix = "homeassistant"
+
+// haHDMIInputs is the number of HDMI inputs offered by the discovered
+// input select.
+const haHDMIInputs = 4
+
+// haEntity is one Home Assistant entity announced through MQTT discovery.
+type haEntity struct {
+	component string // Home Assistant platform: "switch", "button", "select"
+	objectID  string
+	config    map[string]interface{}
+}
+
+// mqttDiscoveryEnabled reports whether Home Assistant discovery is on.
+func mqttDiscoveryEnabled() bool {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return currentConfig.MQTT.Discovery
+}
+
+// haNodeID identifies this capi instance in discovery topics and unique IDs,
+// so several instances on one broker don't collide.
+func haNodeID() string {
+	host, _ := os.Hostname()
+	id := []byte("capi_" + strings.ToLower(host))
+	for i, b := range id {
+		if !(b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '_' || b == '-') {
+			id[i] = '_'
+		}
+	}
+	return string(id)
+}
+
+// haEntities describes the TV power switch, volume buttons and HDMI input
+// select, wired to the {prefix}/command and {prefix}/event topics.
+func haEntities(prefix string) []haEntity {
+	node := haNodeID()
+	host, _ := os.Hostname()
+	device := map[string]interface{}{
+		"identifiers":  []string{node},
+		"name":         "capi " + host,
+		"manufacturer": "capi",
+		"model":        "HDMI-CEC bridge",
+		"sw_version":   version,
+	}
+	entity := func(component, objectID, name string, config map[string]interface{}) haEntity {
+		config["name"] = name
+		config["unique_id"] = node + "_" + objectID
+		config["device"] = device
+		// Available only while both the bridge and its adapter are up
+		config["availability"] = []map[string]string{
+			{"topic": prefix + "/status"},
+			{"topic": prefix + "/cec_ready"},
+		}
+		config["availability_mode"] = "all"
+		return haEntity{component: component, objectID: objectID, config: config}
+	}
 
-	if user != "" {
-		opts.SetUsername(user)
+	inputs := make([]string, haHDMIInputs)
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf("HDMI %d", i+1)
 	}
-	if pass != "" {
-		opts.SetPassword(pass)
+
+	return []haEntity{
+		entity("switch", "tv_power", "TV Power", map[string]interface{}{
+			"command_topic":  prefix + "/command/power/set",
+			"payload_on":     "ON",
+			"payload_off":    "OFF",
+			"state_topic":    prefix + "/state/power/0",
+			"value_template": "{{ 'ON' if value in ['on', 'transitioning_to_on'] else 'OFF' }}",
+		}),
+		entity("button", "volume_up", "Volume Up", map[string]interface{}{
+			"command_topic": prefix + "/command/volume/up",
+			"payload_press": "",
+		}),
+		entity("button", "volume_down", "Volume Down", map[string]interface{}{
+			"command_topic": prefix + "/command/volume/down",
+			"payload_press": "",
+		}),
+		entity("button", "mute", "Mute", map[string]interface{}{
+			"command_topic": prefix + "/command/volume/mute",
+			"payload_press": "",
+		}),
+		entity("select", "hdmi_input", "HDMI Input", map[string]interface{}{
+			"command_topic":    prefix + "/command/hdmi",
+			"command_template": "{{ value | replace('HDMI ', '') }}",
+			"options":          inputs,
+			"optimistic":       true,
+		}),
 	}
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
+func haConfigTopic(e haEntity) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", haDiscoveryPrefix, e.component, haNodeID(), e.objectID)
+}
 
-	mqttMu.Lock()
-	mqttCancel = cancel
-	mqttClient = mqtt.NewClient(opts)
-	client := mqttClient
-	mqttMu.Unlock()
+// publishHADiscovery publishes a retained discovery config for each entity.
+func publishHADiscovery(c mqttPublisher, prefix string) {
+	for _, e := range haEntities(prefix) {
+		payload, err := json.Marshal(e.config)
+		if err != nil {
+			continue
+		}
+		c.Publish(haConfigTopic(e), 1, true, payload)
+	}
+	log.Printf("[MQTT] Published Home Assistant discovery for %s", haNodeID())
+}
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Printf("[MQTT] Initial connection failed (will retry): %v", token.Error())
+// clearHADiscovery removes the retained discovery configs, which makes
+// Home Assistant drop the entities.
+func clearHADiscovery(c mqttPublisher, prefix string) {
+	for _, e := range haEntities(prefix) {
+		c.Publish(haConfigTopic(e), 1, true, []byte{}).WaitTimeout(time.Second)
 	}
+}
 
-	// Goroutine: forward EventHub events to MQTT
-	go func() {
-		ch := eventHub.Subscribe()
-		defer eventHub.Unsubscribe(ch)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case ev, ok := <-ch:
-				if !ok {
-					return
-				}
-				mqttMu.Lock()
-				c := mqttClient
-				mqttMu.Unlock()
-				if c == nil || !c.IsConnected() {
-					continue
-				}
-				topic := prefix + "/event/" + ev.Type
-				payload, err := json.Marshal(ev.Data)
-				if err != nil {
-					continue
-				}
-				c.Publish(topic, 0, false, payload)
-			}
-		}
-	}()
+// publishCECReady publishes the retained {prefix}/cec_ready topic, which
+// tells consumers whether the bridge can reach the CEC bus.
+func publishCECReady(c mqttPublisher, prefix string, ready bool) {
+	payload := "offline"
+	if ready {
+		payload = "online"
+	}
+	c.Publish(prefix+"/cec_ready", 1, true, payload)
 }
 
-// handleMQTTCommand dispatches an incoming MQTT message to the appropriate
-// CEC operation. Topic format: {prefix}/command/{action}[/{param}]
-func handleMQTTCommand(prefix, topic string, payload []byte) {
-	cecMutex.Lock()
-	ready := cecReady
-	cecMutex.Unlock()
-	if !ready {
-		log.Printf("[MQTT] Ignoring command %q: CEC adapter not available", topic)
-		return
+// mqttNotifyCECReady republishes {prefix}/cec_ready after the adapter
+// connects or drops. It does nothing if MQTT isn't connected; the topic is
+// published again on every connect.
+func mqttNotifyCECReady(ready bool) {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttClient != nil && mqttClient.IsConnected() {
+		publishCECReady(mqttClient, mqttTopicPrefix, ready)
 	}
+}
 
-	cmdPath := strings.TrimPrefix(topic, prefix+"/command/")
+// ── MQTT settings API ──────────────────────────────────────────────────
 
-	switch {
-	case cmdPath == "power/on":
-		addr := parseMQTTAddress(payload, 0)
-		if addr < 0 || addr > 15 {
-			log.Printf("[MQTT] power/on: invalid address %q", string(payload))
+func getMQTTSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	configMu.RLock()
+	cfg := currentConfig.MQTT
+	configMu.RUnlock()
+
+	maskedPass := ""
+	if cfg.Pass != "" {
+		maskedPass = "***"
+	}
+
+	mqttMu.Lock()
+	connected := mqttClient != nil && mqttClient.IsConnected()
+	mqttMu.Unlock()
+
+	respondSuccess(w, "MQTT settings", map[string]interface{}{
+		"broker":               cfg.Broker,
+		"user":                 cfg.User,
+		"pass":                 maskedPass,
+		"prefix":               cfg.Prefix,
+		"discovery":            cfg.Discovery,
+		"ca_cert":              cfg.CACert,
+		"insecure_skip_verify": cfg.InsecureSkipVerify,
+		"qos":                  cfg.QoS,
+		"retain":               cfg.Retain,
+		"connected":            connected,
+	})
+}
+
+func postMQTTSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Broker string `json:"broker"`
+		User   string `json:"user"`
+		Pass   string `json:"pass"`
+		Prefix string `json:"prefix"`
+		// Discovery, the TLS settings, QoS and Retain are optional; omitted
+		// keeps the current setting.
+		Discovery          *bool   `json:"discovery"`
+		CACert             *string `json:"ca_cert"`
+		InsecureSkipVerify *bool   `json:"insecure_skip_verify"`
+		QoS                *int    `json:"qos"`
+		Retain             *bool   `json:"retain"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Broker != "" {
+		scheme, _, ok := strings.Cut(req.Broker, "://")
+		switch {
+		case !ok:
+			respondError(w, http.StatusBadRequest, "Field 'broker' must be a URL such as tcp://host:1883")
+			return
+		case scheme != "tcp" && scheme != "mqtt" && scheme != "ssl" && scheme != "tls" &&
+			scheme != "mqtts" && scheme != "ws" && scheme != "wss":
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'broker' has unsupported scheme %q", scheme))
 			return
 		}
-		cecMutex.Lock()
-		err := cecConn.PowerOn(cec.LogicalAddress(addr))
-		cecMutex.Unlock()
-		if err != nil {
-			log.Printf("[MQTT] power/on failed: %v", err)
-		}
+	}
+	if strings.ContainsAny(req.Prefix, "+#") {
+		respondError(w, http.StatusBadRequest, "Field 'prefix' must not contain MQTT wildcards (+ or #)")
+		return
+	}
+	if req.Prefix == "" {
+		req.Prefix = "capi"
+	}
+	if req.QoS != nil && (*req.QoS < 0 || *req.QoS > 2) {
+		respondError(w, http.StatusBadRequest, "Field 'qos' must be 0, 1 or 2")
+		return
+	}
 
-	case cmdPath == "power/off":
-		addr := parseMQTTAddress(payload, 0)
-		if addr < 0 || addr > 15 {
-			log.Printf("[MQTT] power/off: invalid address %q", string(payload))
+	configMu.RLock()
+	// Sentinel "***" means keep existing password
+	if req.Pass == "***" {
+		req.Pass = currentConfig.MQTT.Pass
+	}
+	mqttCfg := MQTTConfig{
+		Broker:             req.Broker,
+		User:               req.User,
+		Pass:               req.Pass,
+		Prefix:             req.Prefix,
+		Discovery:          currentConfig.MQTT.Discovery,
+		CACert:             currentConfig.MQTT.CACert,
+		InsecureSkipVerify: currentConfig.MQTT.InsecureSkipVerify,
+		QoS:                currentConfig.MQTT.QoS,
+		Retain:             currentConfig.MQTT.Retain,
+	}
+	configMu.RUnlock()
+	if req.Discovery != nil {
+		mqttCfg.Discovery = *req.Discovery
+	}
+	if req.CACert != nil {
+		mqttCfg.CACert = *req.CACert
+	}
+	if req.InsecureSkipVerify != nil {
+		mqttCfg.InsecureSkipVerify = *req.InsecureSkipVerify
+	}
+	if req.QoS != nil {
+		mqttCfg.QoS = byte(*req.QoS)
+	}
+	if req.Retain != nil {
+		mqttCfg.Retain = *req.Retain
+	}
+	if mqttUsesTLS(mqttCfg.Broker) {
+		if _, err := mqttTLSConfig(mqttCfg); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'ca_cert': %v", err))
 			return
 		}
-		cecMutex.Lock()
-		err := cecConn.Standby(cec.LogicalAddress(addr))
-		cecMutex.Unlock()
-		if err != nil {
-			log.Printf("[MQTT] power/off failed: %v", err)
-		}
+	}
 
-	case cmdPath == "volume/up":
-		cecMutex.Lock()
-		err := cecConn.VolumeUp(true)
-		cecMutex.Unlock()
-		if err != nil {
-			log.Printf("[MQTT] volume/up failed: %v", err)
-		}
+	configMu.Lock()
+	currentConfig.MQTT = mqttCfg
+	cfg := currentConfig
+	configMu.Unlock()
 
-	case cmdPath == "volume/down":
-		cecMutex.Lock()
-		err := cecConn.VolumeDown(true)
-		cecMutex.Unlock()
-		if err != nil {
-			log.Printf("[MQTT] volume/down failed: %v", err)
-		}
+	if err := saveConfig(configFilePath, cfg); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
+		return
+	}
 
-	case cmdPath == "volume/mute":
-		cecMutex.Lock()
-		err := cecConn.AudioToggleMute()
-		cecMutex.Unlock()
-		if err != nil {
-			log.Printf("[MQTT] volume/mute failed: %v", err)
-		}
+	if req.Broker != "" {
+		startMQTT(mqttCfg)
+	} else {
+		stopMQTT()
+	}
 
-	case cmdPath == "source":
-		addr := parseMQTTAddress(payload, -1)
-		if addr < 0 || addr > 15 {
-			log.Printf("[MQTT] source: invalid address %q", string(payload))
+	respondSuccess(w, "MQTT settings saved", nil)
+}
+
+// ── CEC settings API ───────────────────────────────────────────────────
+
+// maxHDMIPort is the highest HDMI port a physical address can encode.
+const maxHDMIPort = 15
+
+// deviceTypeNames lists the device types the adapter can register as, for
+// messages.
+const deviceTypeNames = "recording_device, playback_device, tuner, audio_system or tv"
+
+func getCECSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	configMu.RLock()
+	cfg := currentConfig.CEC
+	configMu.RUnlock()
+
+	respondSuccess(w, "CEC settings", map[string]interface{}{
+		"device_name":      cfg.DeviceName,
+		"hdmi_port":        cfg.HDMIPort,
+		"physical_address": cfg.PhysicalAddress,
+		"adapter_path":     cfg.AdapterPath,
+		"device_type":      cfg.DeviceType,
+	})
+}
+
+// postCECSettingsHandler changes the adapter's OSD name, HDMI port or
+// physical address. Omitted fields are left alone, except that setting only
+// hdmi_port clears a pinned physical address, which would otherwise win;
+// an empty physical address and port 0 return to auto-detection. Changes
+// are applied to the open adapter with SetConfiguration and saved to
+// config.json. The adapter path and device type are only saved: they take
+// effect on the next start.
+func postCECSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceName      *string `json:"device_name"`
+		HDMIPort        *int    `json:"hdmi_port"`
+		PhysicalAddress *string `json:"physical_address"`
+		AdapterPath     *string `json:"adapter_path"`
+		DeviceType      *string `json:"device_type"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.DeviceName != nil {
+		if err := cec.ValidateDeviceName(*req.DeviceName); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'device_name' is invalid: %v", err))
 			return
 		}
-		cecMutex.Lock()
-		err := cecConn.SwitchToDevice(cec.LogicalAddress(addr))
-		cecMutex.Unlock()
-		if err != nil {
-			log.Printf("[MQTT] source failed: %v", err)
+		if *req.DeviceName == "" || len(*req.DeviceName) > cec.MaxDeviceNameLength {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'device_name' must be 1-%d bytes", cec.MaxDeviceNameLength))
+			return
+		}
+	}
+	if req.HDMIPort != nil && (*req.HDMIPort < 0 || *req.HDMIPort > maxHDMIPort) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'hdmi_port' must be in range 0-%d (0 = auto-detect)", maxHDMIPort))
+		return
+	}
+	if req.PhysicalAddress != nil && *req.PhysicalAddress != "" {
+		if _, err := cec.ParsePhysicalAddress(*req.PhysicalAddress); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'physical_address' must be in dot notation such as 2.0.0.0: %v", err))
+			return
 		}
+	}
 
-	case cmdPath == "hdmi":
-		port := parseMQTTAddress(payload, -1)
-		if port < 1 || port > 15 {
-			log.Printf("[MQTT] hdmi: invalid port %q", string(payload))
+	if req.DeviceType != nil && *req.DeviceType != "" {
+		if _, ok := cec.ParseDeviceType(*req.DeviceType); !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'device_type' must be one of %s", deviceTypeNames))
 			return
 		}
-		cecMutex.Lock()
-		err := cecConn.SwitchToHDMIPort(uint8(port))
-		cecMutex.Unlock()
+	}
+
+	configMu.RLock()
+	settings := currentConfig.CEC
+	configMu.RUnlock()
+	restartRequired := (req.AdapterPath != nil && *req.AdapterPath != settings.AdapterPath) ||
+		(req.DeviceType != nil && *req.DeviceType != settings.DeviceType)
+	if req.AdapterPath != nil {
+		settings.AdapterPath = *req.AdapterPath
+	}
+	if req.DeviceType != nil {
+		settings.DeviceType = *req.DeviceType
+	}
+	if req.DeviceName != nil {
+		settings.DeviceName = *req.DeviceName
+	}
+	if req.HDMIPort != nil {
+		settings.HDMIPort = *req.HDMIPort
+	}
+	if req.PhysicalAddress != nil {
+		settings.PhysicalAddress = *req.PhysicalAddress
+	} else if req.HDMIPort != nil {
+		settings.PhysicalAddress = ""
+	}
+
+	live := req.DeviceName != nil || req.HDMIPort != nil || req.PhysicalAddress != nil
+	applied := false
+	if live && cecIsReady() {
+		err := withCEC(func() error {
+			config, err := cecConn.GetCurrentConfiguration()
+			if err != nil {
+				return err
+			}
+			if req.DeviceName != nil {
+				config.DeviceName = settings.DeviceName
+			}
+			if req.HDMIPort != nil || req.PhysicalAddress != nil {
+				if err := setAdapterAddress(config, settings); err != nil {
+					return err
+				}
+			}
+			return cecConn.SetConfiguration(config)
+		})
 		if err != nil {
-			log.Printf("[MQTT] hdmi failed: %v", err)
+			respondCECError(w, err)
+			return
 		}
+		applied = true
+	}
 
-	case cmdPath == "key":
-		var req struct {
-			Address int    `json:"address"`
-			Key     string `json:"key"`
-			Keycode int    `json:"keycode"`
+	configMu.Lock()
+	currentConfig.CEC.DeviceName = settings.DeviceName
+	currentConfig.CEC.HDMIPort = settings.HDMIPort
+	currentConfig.CEC.PhysicalAddress = settings.PhysicalAddress
+	currentConfig.CEC.AdapterPath = settings.AdapterPath
+	currentConfig.CEC.DeviceType = settings.DeviceType
+	cfg := currentConfig
+	configMu.Unlock()
+
+	if err := saveConfig(configFilePath, cfg); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
+		return
+	}
+
+	message := "CEC settings saved and applied"
+	switch {
+	case restartRequired:
+		message = "CEC settings saved; restart capi to use the new adapter path or device type"
+	case !live:
+		message = "CEC settings saved"
+	case !applied:
+		message = "CEC settings saved; they apply once the adapter is open"
+	}
+	respondSuccess(w, message, map[string]interface{}{
+		"device_name":      settings.DeviceName,
+		"hdmi_port":        settings.HDMIPort,
+		"physical_address": settings.PhysicalAddress,
+		"adapter_path":     settings.AdapterPath,
+		"device_type":      settings.DeviceType,
+		"applied":          applied,
+		"restart_required": restartRequired,
+	})
+}
+
+// ── Metrics ────────────────────────────────────────────────────────────
+
+// Prometheus metrics served on /metrics. They are registered with the
+// default registry, which also carries the Go runtime and process metrics.
+var (
+	cecCommandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "capi_cec_commands_total",
+		Help: "CEC commands transmitted, by opcode.",
+	}, []string{"opcode"})
+	cecTransmitErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "capi_cec_transmit_errors_total",
+		Help: "CEC commands that libcec failed to transmit.",
+	})
+	eventsPublishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "capi_events_published_total",
+		Help: "Events published to SSE, WebSocket and MQTT subscribers, by type.",
+	}, []string{"type"})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "capi_mqtt_connected",
+		Help: "1 if the MQTT bridge is connected to its broker.",
+	}, func() float64 {
+		mqttMu.Lock()
+		defer mqttMu.Unlock()
+		return boolGauge(mqttClient != nil && mqttClient.IsConnected())
+	})
+	// The adapter status is read instead of cecReady so the gauge also
+	// drops when the connection is lost.
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "capi_cec_ready",
+		Help: "1 if the CEC adapter is open and ready.",
+	}, func() float64 {
+		return boolGauge(getAdapterStatus().State == "ready")
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "capi_cec_queue_length",
+		Help: "CEC operations waiting for the CEC worker.",
+	}, func() float64 {
+		return float64(cecQueue.Len())
+	})
+)
+
+// recordTransmit counts a command sent with Connection.Transmit.
+func recordTransmit(command *cec.Command, err error) {
+	cecCommandsTotal.WithLabelValues(fmt.Sprintf("0x%02X", uint8(command.Opcode))).Inc()
+	if err != nil {
+		cecTransmitErrorsTotal.Inc()
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ── API authentication ─────────────────────────────────────────────────
+
+// publicPaths are served without a token so health checks and probes keep
+// working when auth is enabled.
+var publicPaths = map[string]bool{
+	"/api/health": true,
+	"/api/livez":  true,
+	"/api/readyz": true,
+}
+
+// authToken returns the configured API token, or "" when auth is disabled.
+func authToken() string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return currentConfig.Auth.Token
+}
+
+// requestToken extracts the bearer token from the Authorization header,
+// falling back to the access_token query parameter for clients such as
+// EventSource that can't set headers.
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := strings.Cut(h, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
 		}
-		if err := json.Unmarshal(payload, &req); err != nil {
-			log.Printf("[MQTT] key: invalid payload: %v", err)
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// authMiddleware rejects /api requests that don't carry the configured
+// token with 401. The web UI page itself and publicPaths stay open.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := authToken()
+		if token == "" || !strings.HasPrefix(r.URL.Path, "/api/") || publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
 			return
 		}
-		if req.Address < 0 || req.Address > 15 {
-			log.Printf("[MQTT] key: invalid address %d", req.Address)
+		if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="capi"`)
+			respondError(w, http.StatusUnauthorized, "missing or invalid API token")
 			return
 		}
-		keyMap := map[string]cec.Keycode{
-			"up": cec.KeycodeUp, "down": cec.KeycodeDown,
-			"left": cec.KeycodeLeft, "right": cec.KeycodeRight,
-			"select": cec.KeycodeSelect, "enter": cec.KeycodeEnter,
-			"back": cec.KeycodeExit, "home": cec.KeycodeRootMenu,
-			"menu": cec.KeycodeSetupMenu, "play": cec.KeycodePlay,
-			"pause": cec.KeycodePause, "stop": cec.KeycodeStop,
-		}
-		var keycode cec.Keycode
-		if req.Key != "" {
-			k, ok := keyMap[req.Key]
-			if !ok {
-				log.Printf("[MQTT] key: unknown key name %q", req.Key)
-				return
-			}
-			keycode = k
-		} else {
-			keycode = cec.Keycode(req.Keycode)
-		}
-		cecMutex.Lock()
-		err := cecConn.SendButton(cec.LogicalAddress(req.Address), keycode)
-		cecMutex.Unlock()
-		if err != nil {
-			log.Printf("[MQTT] key failed: %v", err)
-		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	default:
-		log.Printf("[MQTT] Unknown command topic: %s", topic)
-	}
+// ── HTTP listener ──────────────────────────────────────────────────────
+
+// HTTPServer serves the API on an address that can be changed at runtime.
+type HTTPServer struct {
+	mu      sync.Mutex
+	handler http.Handler
+	server  *http.Server
+	addr    string
 }
 
-// parseMQTTAddress parses a simple integer from the payload (trimmed).
-// Returns defaultVal if the payload is empty or not a valid integer.
-func parseMQTTAddress(payload []byte, defaultVal int) int {
-	s := strings.TrimSpace(string(payload))
-	if s == "" {
-		return defaultVal
+var httpServer *HTTPServer
+
+// NewHTTPServer creates a server for handler; call Start to begin serving.
+func NewHTTPServer(handler http.Handler) *HTTPServer {
+	return &HTTPServer{handler: handler}
+}
+
+// Addr returns the address currently being served.
+func (s *HTTPServer) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr
+}
+
+// Start listens on addr and serves in the background.
+func (s *HTTPServer) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
 	}
-	v, err := strconv.Atoi(s)
+	s.mu.Lock()
+	s.server, s.addr = s.serve(ln, addr), addr
+	s.mu.Unlock()
+	return nil
+}
+
+// Rebind starts serving on addr and returns the server for the previous
+// address, which the caller should shut down once it no longer needs it.
+// If addr can't be bound, the current listener keeps serving.
+func (s *HTTPServer) Rebind(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		return defaultVal
+		return nil, err
 	}
-	return v
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.server
+	s.server, s.addr = s.serve(ln, addr), addr
+	return old, nil
 }
 
-// ── MQTT settings API ──────────────────────────────────────────────────
+// Shutdown gracefully stops the current listener.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
 
-func getMQTTSettingsHandler(w http.ResponseWriter, r *http.Request) {
-	configMu.RLock()
-	cfg := currentConfig.MQTT
-	configMu.RUnlock()
+// serverDoneKey is the request context key for the channel that is closed
+// when the server handling the request shuts down.
+type serverDoneKey struct{}
+
+// serverDone returns a channel that is closed when the server handling r
+// starts shutting down. Streaming handlers select on it so they end when
+// the listener moves or capi exits, instead of Shutdown waiting for them
+// until its deadline. It is nil (never ready) outside HTTPServer.
+func serverDone(r *http.Request) <-chan struct{} {
+	done, _ := r.Context().Value(serverDoneKey{}).(chan struct{})
+	return done
+}
 
-	maskedPass := ""
-	if cfg.Pass != "" {
-		maskedPass = "***"
+func (s *HTTPServer) serve(ln net.Listener, addr string) *http.Server {
+	done := make(chan struct{})
+	server := &http.Server{
+		Handler: s.handler,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), serverDoneKey{}, done)
+		},
 	}
+	server.RegisterOnShutdown(func() { close(done) })
+	go func() {
+		log.Printf("Starting HTTP server on %s", addr)
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+	return server
+}
 
-	mqttMu.Lock()
-	connected := mqttClient != nil && mqttClient.IsConnected()
-	mqttMu.Unlock()
+// validateBindAddr checks that addr is a host:port with a valid port.
+func validateBindAddr(addr string) error {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("must be host:port such as :8080 or localhost:8080: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("port must be 1-65535, got %q", portStr)
+	}
+	return nil
+}
 
-	respondSuccess(w, "MQTT settings", map[string]interface{}{
-		"broker":    cfg.Broker,
-		"user":      cfg.User,
-		"pass":      maskedPass,
-		"prefix":    cfg.Prefix,
-		"connected": connected,
+func getBindSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	respondSuccess(w, "Bind settings", map[string]interface{}{
+		"addr": httpServer.Addr(),
 	})
 }
 
-func postMQTTSettingsHandler(w http.ResponseWriter, r *http.Request) {
+// postBindSettingsHandler moves the HTTP server to a new address. The new
+// listener is opened before anything else happens, so a bad address leaves
+// the server where it is. The response is sent from the old address, which
+// then shuts down gracefully.
+func postBindSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Broker string `json:"broker"`
-		User   string `json:"user"`
-		Pass   string `json:"pass"`
-		Prefix string `json:"prefix"`
+		Addr string `json:"addr"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSONBody(w, r, &req) || !requireField(w, req.Addr != "", "addr") {
 		return
 	}
-	if req.Prefix == "" {
-		req.Prefix = "capi"
+	if err := validateBindAddr(req.Addr); err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'addr' %v", err))
+		return
 	}
 
-	configMu.Lock()
-	// Sentinel "***" means keep existing password
-	if req.Pass == "***" {
-		req.Pass = currentConfig.MQTT.Pass
+	oldAddr := httpServer.Addr()
+	if req.Addr == oldAddr {
+		respondSuccess(w, fmt.Sprintf("Already listening on %s", oldAddr), map[string]interface{}{
+			"old_addr": oldAddr,
+			"addr":     oldAddr,
+		})
+		return
 	}
-	currentConfig.MQTT = MQTTConfig{
-		Broker: req.Broker,
-		User:   req.User,
-		Pass:   req.Pass,
-		Prefix: req.Prefix,
+
+	old, err := httpServer.Rebind(req.Addr)
+	if err != nil {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Cannot listen on %s: %v", req.Addr, err))
+		return
 	}
+
+	configMu.Lock()
+	currentConfig.Bind = req.Addr
 	cfg := currentConfig
 	configMu.Unlock()
-
 	if err := saveConfig(configFilePath, cfg); err != nil {
 		log.Printf("Failed to save config: %v", err)
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
-		return
 	}
 
-	if req.Broker != "" {
-		startMQTT(req.Broker, req.User, req.Pass, req.Prefix)
-	} else {
-		stopMQTT()
-	}
+	log.Printf("HTTP server moved from %s to %s", oldAddr, req.Addr)
+	respondSuccess(w, fmt.Sprintf("Now listening on %s; %s closes shortly", req.Addr, oldAddr), map[string]interface{}{
+		"old_addr": oldAddr,
+		"addr":     req.Addr,
+	})
 
-	respondSuccess(w, "MQTT settings saved", nil)
+	// Close the old listener after a short delay so this response is sent
+	go func() {
+		time.Sleep(1 * time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := old.Shutdown(ctx); err != nil {
+			log.Printf("Old HTTP listener shutdown: %v", err)
+		}
+	}()
 }
 
 // ── Self-update logic ──────────────────────────────────────────────────
 
-const updateRepo = "LukasParke/capi"
+// Where self-update looks for releases by default. -update-repo and
+// -update-api-url (or CAPI_UPDATE_REPO and CAPI_UPDATE_API_URL) override
+// them, e.g. for a fork or a mock server.
+const (
+	defaultUpdateRepo   = "LukasParke/capi"
+	defaultUpdateAPIURL = "https://api.github.com"
+)
+
+var (
+	updateRepo   = defaultUpdateRepo
+	updateAPIURL = defaultUpdateAPIURL
+)
+
+// envOr returns the environment variable key, or def if it is unset or
+// empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// validateUpdateRepo checks that repo has the owner/name form.
+func validateUpdateRepo(repo string) error {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.ContainsAny(name, "/ ") || strings.Contains(owner, " ") {
+		return fmt.Errorf("must be owner/name")
+	}
+	return nil
+}
+
+// normalizeAPIURL checks that raw is an http(s) URL and strips any
+// trailing slash.
+func normalizeAPIURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("must be an http or https URL")
+	}
+	return strings.TrimRight(raw, "/"), nil
+}
 
 var updateHTTPClient = &http.Client{Timeout: 30 * time.Second}
 
+// updateToken, when set, is sent as a bearer token on update requests to
+// GitHub so a fleet behind one IP shares the authenticated rate limit. It
+// comes from $GITHUB_TOKEN or github_token in config.json.
+var updateToken string
+
+// setUpdateToken picks the update token: $GITHUB_TOKEN, else configured.
+func setUpdateToken(configured string) {
+	updateToken = envOr("GITHUB_TOKEN", configured)
+	if updateToken != "" {
+		log.Printf("Update requests use GitHub token %s", maskToken(updateToken))
+	}
+}
+
+// maskToken shows only the last four characters of a secret, for logs.
+func maskToken(token string) string {
+	if len(token) <= 8 {
+		return "***"
+	}
+	return "***" + token[len(token)-4:]
+}
+
+// newUpdateRequest builds a GET request for the update API or a release
+// asset. The token is only attached for the API host and github.com, and
+// http.Client drops it when a download redirects to another host.
+func newUpdateRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if updateToken != "" {
+		api, _ := url.Parse(updateAPIURL)
+		if host := req.URL.Hostname(); host == "github.com" || (api != nil && host == api.Hostname()) {
+			req.Header.Set("Authorization", "Bearer "+updateToken)
+		}
+	}
+	return req, nil
+}
+
 // releaseInfo holds metadata about a GitHub release.
 type releaseInfo struct {
 	TagName string        `json:"tag_name"`
@@ -1256,17 +5589,109 @@ type releaseAsset struct {
 	BrowserDownloadURL string `json:"browser_download_url"`
 }
 
+// Errors checkForUpdate wraps so callers can tell a transient failure from
+// one that retrying soon won't fix.
+var (
+	errUpdateTemporary   = errors.New("temporary error contacting GitHub")
+	errUpdateRateLimited = errors.New("GitHub API rate limit exceeded")
+	errReleaseNotFound   = errors.New("release not found")
+)
+
+const (
+	// updateCheckAttempts is how many times checkForUpdate tries the GitHub
+	// API before giving up on a temporary error.
+	updateCheckAttempts = 3
+	// updateCheckTimeout bounds a whole update check, including retries.
+	updateCheckTimeout = 30 * time.Second
+)
+
+// updateCheckRetryDelay is the wait before the first retry; it doubles
+// after each attempt.
+var updateCheckRetryDelay = 2 * time.Second
+
 // checkForUpdate queries the GitHub releases API and returns info about the
 // latest release. Returns nil if the current version is already up to date.
+// Network errors and 5xx responses are retried with backoff; the returned
+// error wraps errUpdateTemporary or errUpdateRateLimited where applicable.
 func checkForUpdate() (*releaseInfo, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", updateRepo)
-	resp, err := updateHTTPClient.Get(url)
+	info, err := fetchReleaseWithRetry(fmt.Sprintf("%s/repos/%s/releases/latest", updateAPIURL, updateRepo))
+	if err != nil {
+		return nil, err
+	}
+
+	if info.TagName == version {
+		return nil, nil // already up to date
+	}
+
+	return info, nil
+}
+
+// checkForRelease is like checkForUpdate but looks up the release with the
+// given tag, so a specific (possibly older) version can be installed. The
+// error wraps errReleaseNotFound if there is no such release.
+func checkForRelease(tag string) (*releaseInfo, error) {
+	info, err := fetchReleaseWithRetry(fmt.Sprintf("%s/repos/%s/releases/tags/%s", updateAPIURL, updateRepo, url.PathEscape(tag)))
+	if err != nil {
+		return nil, err
+	}
+
+	if info.TagName == version {
+		return nil, nil // already running it
+	}
+
+	return info, nil
+}
+
+// fetchReleaseWithRetry calls fetchRelease, retrying temporary errors with
+// backoff within updateCheckTimeout.
+func fetchReleaseWithRetry(url string) (*releaseInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	defer cancel()
+
+	delay := updateCheckRetryDelay
+	var info *releaseInfo
+	var err error
+	for attempt := 1; ; attempt++ {
+		info, err = fetchRelease(ctx, url)
+		if err == nil || !errors.Is(err, errUpdateTemporary) || attempt == updateCheckAttempts {
+			break
+		}
+		log.Printf("Update check attempt %d failed: %v — retrying in %v", attempt, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: gave up after %d attempt(s): %v", errUpdateTemporary, attempt, err)
+		}
+		delay *= 2
+	}
+	return info, err
+}
+
+// fetchRelease makes one request for release metadata.
+func fetchRelease(ctx context.Context, url string) (*releaseInfo, error) {
+	req, err := newUpdateRequest(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query GitHub: %w", err)
+		return nil, err
+	}
+	resp, err := updateHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to query GitHub: %v", errUpdateTemporary, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0":
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return nil, fmt.Errorf("%w (resets at %s)", errUpdateRateLimited, time.Unix(reset, 0).Format(time.RFC3339))
+		}
+		return nil, errUpdateRateLimited
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("%w: GitHub API returned %d", errUpdateTemporary, resp.StatusCode)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errReleaseNotFound
+	default:
 		return nil, fmt.Errorf("GitHub API returned %d", resp.StatusCode)
 	}
 
@@ -1274,11 +5699,6 @@ func checkForUpdate() (*releaseInfo, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
 		return nil, fmt.Errorf("failed to parse release JSON: %w", err)
 	}
-
-	if info.TagName == version {
-		return nil, nil // already up to date
-	}
-
 	return &info, nil
 }
 
@@ -1292,75 +5712,357 @@ func assetURL(info *releaseInfo, name string) string {
 	return ""
 }
 
-// binaryAssetName returns the release asset name for the current architecture.
-func binaryAssetName() string {
-	switch runtime.GOARCH {
+// binaryAssetNames returns the release assets that run on this machine,
+// best first.
+func binaryAssetNames() ([]string, error) {
+	goarm := ""
+	if runtime.GOARCH == "arm" {
+		goarm = detectARMVersion()
+	}
+	return binaryAssetNamesFor(runtime.GOARCH, goarm)
+}
+
+// binaryAssetNamesFor returns the release assets for a GOARCH and, for
+// arm, the CPU's GOARM level ("6", "7", ...), best first. armv6 binaries
+// also run on armv7 and later, so they are the fallback for releases
+// without an armv7 build.
+func binaryAssetNamesFor(goarch, goarm string) ([]string, error) {
+	switch goarch {
 	case "arm64":
-		return "capi-linux-arm64"
+		return []string{"capi-linux-arm64"}, nil
 	case "arm":
-		return "capi-linux-armv6"
-	default:
-		return "capi-linux-arm64"
+		level, err := strconv.Atoi(goarm)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("cannot tell the ARM version (GOARM %q)", goarm)
+		case level >= 7:
+			return []string{"capi-linux-armv7", "capi-linux-armv6"}, nil
+		case level == 6:
+			return []string{"capi-linux-armv6"}, nil
+		}
+		return nil, fmt.Errorf("no release builds for ARMv%d", level)
+	}
+	return nil, fmt.Errorf("no release builds for %s", goarch)
+}
+
+// detectARMVersion returns the ARM architecture version of the CPU from
+// /proc/cpuinfo, falling back to the GOARM this binary was built with.
+// The "(v6l)" suffix of the model name is preferred over "CPU
+// architecture", which reads 7 on the ARMv6 Raspberry Pi 1 and Zero. A
+// 64-bit CPU running a 32-bit OS reports 8.
+func detectARMVersion() string {
+	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
+		arch := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "model name", "Processor":
+				// e.g. "ARMv6-compatible processor rev 7 (v6l)"
+				if i := strings.LastIndex(value, "(v"); i >= 0 && i+3 < len(value) {
+					return value[i+2 : i+3]
+				}
+			case "CPU architecture":
+				if arch == "" {
+					arch = strings.TrimSpace(value)
+				}
+			}
+		}
+		if arch != "" {
+			return arch
+		}
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "GOARM" {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
+
+// updateProgressInterval limits how often update_progress events are
+// published while a file downloads.
+const updateProgressInterval = 250 * time.Millisecond
+
+// updateReporter publishes update_progress, update_complete and
+// update_failed events for one self-update. A nil hub makes every method a
+// no-op, which is what the CLI path uses.
+type updateReporter struct {
+	hub     *EventHub
+	version string
+}
+
+func (u updateReporter) publish(eventType string, data map[string]interface{}) {
+	if u.hub == nil {
+		return
+	}
+	data["version"] = u.version
+	u.hub.Publish(CECEvent{Type: eventType, Data: data})
+}
+
+// progress reports bytes downloaded of file. total is -1 when the server
+// sent no Content-Length, in which case total and percent are null and
+// indeterminate is true.
+func (u updateReporter) progress(file string, bytes, total int64) {
+	data := map[string]interface{}{
+		"file":          file,
+		"bytes":         bytes,
+		"total":         nil,
+		"percent":       nil,
+		"indeterminate": total < 0,
+	}
+	if total >= 0 {
+		data["total"] = total
+		if total > 0 {
+			data["percent"] = float64(bytes) * 100 / float64(total)
+		}
+	}
+	u.publish("update_progress", data)
+}
+
+func (u updateReporter) complete(oldVersion string) {
+	u.publish("update_complete", map[string]interface{}{"old_version": oldVersion})
+}
+
+func (u updateReporter) failed(err error) {
+	u.publish("update_failed", map[string]interface{}{"error": err.Error()})
+}
+
+// progressWriter counts bytes written through it and calls report at most
+// once per updateProgressInterval.
+type progressWriter struct {
+	written    int64
+	total      int64
+	lastReport time.Time
+	report     func(bytes, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if now := time.Now(); now.Sub(p.lastReport) >= updateProgressInterval {
+		p.lastReport = now
+		p.report(p.written, p.total)
 	}
+	return len(b), nil
 }
 
-// downloadFile downloads a URL to a local file path.
-func downloadFile(url, dest string) error {
-	resp, err := updateHTTPClient.Get(url)
+// downloadFile downloads a URL to a local file path. If progress is non-nil
+// it is called periodically with the bytes received so far and the total
+// size (-1 if the server didn't send Content-Length), and once more when
+// the download finishes.
+func downloadFile(url, dest string, progress func(bytes, total int64)) error {
+	req, err := newUpdateRequest(context.Background(), url)
+	if err != nil {
+		return err
+	}
+	resp, err := updateHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %d", resp.StatusCode)
+	}
+
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader = resp.Body
+	var counter *progressWriter
+	if progress != nil {
+		counter = &progressWriter{total: resp.ContentLength, report: progress}
+		progress(0, resp.ContentLength)
+		counter.lastReport = time.Now()
+		body = io.TeeReader(resp.Body, counter)
+	}
+
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	f.Close()
+	if counter != nil {
+		progress(counter.written, counter.total)
+	}
+
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// updateInstallDir returns the directory holding the running binary.
+func updateInstallDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "/opt/capi/capi"
+	}
+	return filepath.Dir(exe)
+}
+
+// checksumsAsset is the release asset listing the SHA-256 of the other
+// assets, in sha256sum format.
+const checksumsAsset = "checksums.txt"
+
+// maxChecksumFileSize bounds a downloaded checksums file.
+const maxChecksumFileSize = 64 << 10
+
+// releaseChecksum returns the published SHA-256 of the named asset, from
+// a name.sha256 sidecar asset or from checksums.txt. ok is false if the
+// release publishes neither.
+func releaseChecksum(info *releaseInfo, name string) (sum string, ok bool, err error) {
+	for _, asset := range []string{name + ".sha256", checksumsAsset} {
+		u := assetURL(info, asset)
+		if u == "" {
+			continue
+		}
+		req, err := newUpdateRequest(context.Background(), u)
+		if err != nil {
+			return "", true, err
+		}
+		resp, err := updateHTTPClient.Do(req)
+		if err != nil {
+			return "", true, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", true, fmt.Errorf("%s download returned %d", asset, resp.StatusCode)
+		}
+		sc := bufio.NewScanner(io.LimitReader(resp.Body, maxChecksumFileSize))
+		for sc.Scan() {
+			// "<hex>  <name>", "<hex> *<name>", or a bare "<hex>" in a sidecar
+			fields := strings.Fields(sc.Text())
+			switch {
+			case len(fields) == 1 && asset != checksumsAsset:
+				return strings.ToLower(fields[0]), true, nil
+			case len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name:
+				return strings.ToLower(fields[0]), true, nil
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return "", true, fmt.Errorf("reading %s: %w", asset, err)
+		}
+		return "", true, fmt.Errorf("%s has no entry for %s", asset, name)
+	}
+	return "", false, nil
+}
+
+// fileSHA256 returns the hex SHA-256 of a file.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// errNoChecksum is returned by verifyDownload for a release that publishes
+// no checksum for the asset, unless unverified installs were allowed.
+var errNoChecksum = errors.New("release publishes no checksum")
+
+// verifyDownload checks path against the checksum the release publishes
+// for asset name. Releases without checksums are refused unless
+// allowUnverified is set, so older tags can still be pinned on purpose.
+func verifyDownload(info *releaseInfo, name, path string, allowUnverified bool) error {
+	want, ok, err := releaseChecksum(info, name)
+	if err != nil {
+		return fmt.Errorf("cannot get checksum: %w", err)
+	}
+	if !ok {
+		if !allowUnverified {
+			return fmt.Errorf("%w for %s in %s", errNoChecksum, name, info.TagName)
+		}
+		log.Printf("Warning: release %s publishes no checksum for %s; installing unverified", info.TagName, name)
+		return nil
+	}
+	got, err := fileSHA256(path)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download returned %d", resp.StatusCode)
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", name, want, got)
 	}
+	log.Printf("Verified %s (sha256 %s)", name, got)
+	return nil
+}
 
-	tmp := dest + ".tmp"
-	f, err := os.Create(tmp)
-	if err != nil {
-		return err
+// replaceBinary moves newPath over binPath, keeping the old binary as
+// binPath.bak. The old file is hard-linked to the backup first so binPath
+// always exists, and the final rename is atomic.
+func replaceBinary(newPath, binPath string) error {
+	backup := binPath + ".bak"
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove old backup: %w", err)
 	}
-
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		f.Close()
-		os.Remove(tmp)
-		return err
+	if err := os.Link(binPath, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot back up current binary: %w", err)
 	}
-	f.Close()
+	return os.Rename(newPath, binPath)
+}
 
-	if err := os.Chmod(tmp, 0755); err != nil {
-		os.Remove(tmp)
+// performUpdate downloads the new binary and index.html from the given
+// release, reporting download progress through reporter. The binary it
+// replaces is kept as capi.bak for rollbackUpdate. allowUnverified lets a
+// release without checksums be installed.
+func performUpdate(info *releaseInfo, reporter updateReporter, allowUnverified bool) error {
+	names, err := binaryAssetNames()
+	if err != nil {
 		return err
 	}
-
-	return os.Rename(tmp, dest)
-}
-
-// performUpdate downloads the new binary and index.html from the given release.
-func performUpdate(info *releaseInfo) error {
-	binName := binaryAssetName()
-	binURL := assetURL(info, binName)
+	var binName, binURL string
+	for _, name := range names {
+		if binURL = assetURL(info, name); binURL != "" {
+			binName = name
+			break
+		}
+	}
 	if binURL == "" {
-		return fmt.Errorf("release %s has no asset %s", info.TagName, binName)
+		return fmt.Errorf("release %s has no asset %s", info.TagName, strings.Join(names, " or "))
 	}
 
-	exe, err := os.Executable()
-	if err != nil {
-		exe = "/opt/capi/capi"
-	}
-	installDir := filepath.Dir(exe)
+	installDir := updateInstallDir()
+	binPath := filepath.Join(installDir, "capi")
 
 	log.Printf("Downloading %s from %s ...", binName, info.TagName)
-	if err := downloadFile(binURL, filepath.Join(installDir, "capi")); err != nil {
+	err = downloadFile(binURL, binPath+".new", func(bytes, total int64) {
+		reporter.progress(binName, bytes, total)
+	})
+	if err != nil {
 		return fmt.Errorf("binary download failed: %w", err)
 	}
+	if err := verifyDownload(info, binName, binPath+".new", allowUnverified); err != nil {
+		os.Remove(binPath + ".new")
+		return fmt.Errorf("binary verification failed: %w", err)
+	}
+	if err := replaceBinary(binPath+".new", binPath); err != nil {
+		os.Remove(binPath + ".new")
+		return fmt.Errorf("binary install failed: %w", err)
+	}
 
 	// Also update index.html if present in release assets
 	htmlURL := assetURL(info, "index.html")
 	if htmlURL != "" {
 		log.Println("Downloading updated index.html ...")
-		if err := downloadFile(htmlURL, filepath.Join(installDir, "index.html")); err != nil {
+		err := downloadFile(htmlURL, filepath.Join(installDir, "index.html"), func(bytes, total int64) {
+			reporter.progress("index.html", bytes, total)
+		})
+		if err != nil {
 			log.Printf("Warning: index.html download failed: %v", err)
 		}
 	}
@@ -1368,6 +6070,29 @@ func performUpdate(info *releaseInfo) error {
 	return nil
 }
 
+// errNoBackup is returned by rollbackUpdate when there is no capi.bak.
+var errNoBackup = errors.New("no previous binary to roll back to")
+
+// rollbackUpdate swaps capi and capi.bak, so a second rollback undoes the
+// first.
+func rollbackUpdate() error {
+	binPath := filepath.Join(updateInstallDir(), "capi")
+	backup := binPath + ".bak"
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		return errNoBackup
+	}
+	swap := binPath + ".rollback"
+	os.Remove(swap)
+	if err := os.Link(binPath, swap); err != nil {
+		return fmt.Errorf("cannot keep current binary: %w", err)
+	}
+	if err := os.Rename(backup, binPath); err != nil {
+		os.Remove(swap)
+		return err
+	}
+	return os.Rename(swap, backup)
+}
+
 // restartService asks systemd to restart the capi service.
 func restartService() error {
 	cmd := exec.Command("systemctl", "restart", "capi.service")
@@ -1382,7 +6107,12 @@ func doSelfUpdate() {
 	log.Println("Checking for updates...")
 
 	info, err := checkForUpdate()
-	if err != nil {
+	switch {
+	case errors.Is(err, errUpdateRateLimited):
+		log.Fatalf("Update check failed: %v — try again later", err)
+	case errors.Is(err, errUpdateTemporary):
+		log.Fatalf("Update check failed: %v — check the network and try again", err)
+	case err != nil:
 		log.Fatalf("Update check failed: %v", err)
 	}
 	if info == nil {
@@ -1392,7 +6122,7 @@ func doSelfUpdate() {
 
 	log.Printf("Update available: %s -> %s", version, info.TagName)
 
-	if err := performUpdate(info); err != nil {
+	if err := performUpdate(info, updateReporter{}, false); err != nil {
 		log.Fatalf("Update failed: %v", err)
 	}
 
@@ -1405,23 +6135,59 @@ func doSelfUpdate() {
 
 // POST /api/update handler
 
+// updateHandler installs the latest release, or the one named by an
+// optional {"tag": "v1.4.2"} body. "allow_unverified": true installs a
+// release that publishes no checksum.
 func updateHandler(w http.ResponseWriter, r *http.Request) {
-	info, err := checkForUpdate()
+	var req struct {
+		Tag             string `json:"tag"`
+		AllowUnverified bool   `json:"allow_unverified"`
+	}
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	var info *releaseInfo
+	var err error
+	if req.Tag != "" {
+		info, err = checkForRelease(req.Tag)
+	} else {
+		info, err = checkForUpdate()
+	}
+	if errors.Is(err, errUpdateRateLimited) {
+		respondError(w, http.StatusTooManyRequests, fmt.Sprintf("Update check failed: %v", err))
+		return
+	}
+	if errors.Is(err, errReleaseNotFound) {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Release %q not found", req.Tag))
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusBadGateway, fmt.Sprintf("Update check failed: %v", err))
 		return
 	}
 	if info == nil {
-		respondSuccess(w, "Already up to date", map[string]interface{}{
+		message := "Already up to date"
+		if req.Tag != "" {
+			message = fmt.Sprintf("Already running %s", version)
+		}
+		respondSuccess(w, message, map[string]interface{}{
 			"version": version,
 		})
 		return
 	}
 
-	if err := performUpdate(info); err != nil {
+	reporter := updateReporter{hub: eventHub, version: info.TagName}
+	if err := performUpdate(info, reporter, req.AllowUnverified); err != nil {
+		reporter.failed(err)
+		if errors.Is(err, errNoChecksum) {
+			respondError(w, http.StatusConflict, fmt.Sprintf("Update failed: %v; set \"allow_unverified\": true to install it anyway", err))
+			return
+		}
 		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Update failed: %v", err))
 		return
 	}
+	reporter.complete(version)
 
 	respondSuccess(w, fmt.Sprintf("Updated to %s, restarting...", info.TagName), map[string]interface{}{
 		"old_version": version,
@@ -1435,42 +6201,240 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 }
 
+// GET /api/update/check reports whether a newer release exists without
+// installing it.
+func updateCheckHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := checkForUpdate()
+	if errors.Is(err, errUpdateRateLimited) {
+		respondError(w, http.StatusTooManyRequests, fmt.Sprintf("Update check failed: %v", err))
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadGateway, fmt.Sprintf("Update check failed: %v", err))
+		return
+	}
+	latest := version
+	message := "Already up to date"
+	if info != nil {
+		latest = info.TagName
+		message = fmt.Sprintf("Update available: %s", latest)
+	}
+	respondSuccess(w, message, map[string]interface{}{
+		"current":          version,
+		"latest":           latest,
+		"update_available": info != nil,
+	})
+}
+
+// watchForUpdates checks for a new release at startup and then every
+// interval, publishing an update_available event the first time each new
+// release is seen. It installs nothing and never returns.
+func watchForUpdates(interval time.Duration) {
+	notified := ""
+	check := func() {
+		info, err := checkForUpdate()
+		if err != nil {
+			log.Printf("Periodic update check failed: %v", err)
+			return
+		}
+		if info == nil || info.TagName == notified {
+			return
+		}
+		notified = info.TagName
+		log.Printf("Update available: %s -> %s", version, info.TagName)
+		eventHub.Publish(CECEvent{Type: "update_available", Data: map[string]interface{}{
+			"current": version,
+			"latest":  info.TagName,
+		}})
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		check()
+	}
+}
+
+// POST /api/update/rollback reinstalls the binary the last update replaced
+// and restarts.
+func updateRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if err := rollbackUpdate(); err != nil {
+		if errors.Is(err, errNoBackup) {
+			respondError(w, http.StatusNotFound, "No previous binary to roll back to")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Rollback failed: %v", err))
+		return
+	}
+	log.Printf("Rolled back from %s to the previous binary", version)
+	respondSuccess(w, "Rolled back to the previous binary, restarting...", map[string]interface{}{
+		"old_version": version,
+	})
+
+	go func() {
+		time.Sleep(1 * time.Second)
+		restartService()
+	}()
+}
+
+// WebSocket tuning for /api/ws.
+const (
+	wsPingInterval = 15 * time.Second
+	wsPongWait     = 2 * wsPingInterval
+	wsWriteWait    = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// parseEventTypes reads the optional comma-separated ?types= filter for
+// SubscribeFiltered. An empty result forwards every type.
+func parseEventTypes(r *http.Request) []string {
+	var types []string
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// GET /api/ws upgrades to a WebSocket and pushes the same CECEvent JSON
+// objects as /api/events, one per text message. It accepts the same ?ack=
+// and ?eom= filters plus ?types=power_change,source_activated.
+func eventsWSHandler(w http.ResponseWriter, r *http.Request) {
+	if eventHub == nil {
+		respondError(w, http.StatusInternalServerError, "event hub not initialized")
+		return
+	}
+	filter, err := parseCommandFlagFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	types := parseEventTypes(r)
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response.
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ch := eventHub.SubscribeFiltered(types...)
+	defer eventHub.Unsubscribe(ch)
+
+	// The client never sends anything we act on, but reading is what
+	// notices a close frame or a dead connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !filter.matches(ev) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-serverDone(r):
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
 // Health check
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	cecMutex.Lock()
+	cecStateMu.Lock()
 	ready := cecReady
-	libInfo := ""
-	if cecConn != nil {
-		// Protect against any unexpected panics inside libcec.
-		func() {
-			defer func() {
-				if recover() != nil {
-					libInfo = "unavailable"
-				}
-			}()
-			libInfo = cecConn.GetLibInfo()
-		}()
-	}
-	cecMutex.Unlock()
+	libInfo := cecLibInfo
+	cecStateMu.Unlock()
 
+	firmware := getFirmwareStatus()
 	respondSuccess(w, "Service is healthy", map[string]interface{}{
-		"version":   version,
-		"libcec":    libInfo,
-		"cec_ready": ready,
+		"version":                      version,
+		"libcec":                       libInfo,
+		"cec_ready":                    ready,
+		"adapter":                      getAdapterStatus(),
+		"firmware":                     firmware,
+		"firmware_upgrade_recommended": firmware.UpgradeRecommended,
 	})
 }
 
+// livezHandler reports that the process is alive and serving HTTP. It never
+// touches the CEC adapter so it stays fast even when libcec is wedged.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	respondSuccess(w, "alive", nil)
+}
+
+// readyzHandler reports whether the service can handle CEC requests: 200 once
+// the adapter is open, 503 otherwise.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	respondSuccess(w, "ready", nil)
+}
+
 func main() {
 	bindAddr := flag.String("bind", ":8080", "Bind address (e.g., :8080 for all interfaces, localhost:8080 for local only)")
 	deviceName := flag.String("name", "CEC HTTP Bridge", "Device name")
 	adapterPath := flag.String("adapter", "", "CEC adapter path (auto-detect if empty)")
+	deviceTypeFlag := flag.String("device-type", "recording_device", "CEC device type: "+deviceTypeNames)
 	showVersion := flag.Bool("version", false, "Print version and exit")
 	doUpdate := flag.Bool("update", false, "Check for updates and install the latest release")
+	updateRepoFlag := flag.String("update-repo", envOr("CAPI_UPDATE_REPO", defaultUpdateRepo), "GitHub repository (owner/name) to update from (env CAPI_UPDATE_REPO)")
+	updateAPIURLFlag := flag.String("update-api-url", envOr("CAPI_UPDATE_API_URL", defaultUpdateAPIURL), "GitHub API base URL for updates (env CAPI_UPDATE_API_URL)")
+	updateCheckInterval := flag.Duration("update-check-interval", 0, "How often to check for a new release and publish update_available, e.g. 24h (0 disables; nothing is installed)")
 	mqttBroker := flag.String("mqtt-broker", "", "MQTT broker URL (e.g. tcp://localhost:1883). Empty disables MQTT.")
 	mqttUser := flag.String("mqtt-user", "", "MQTT username (optional)")
 	mqttPass := flag.String("mqtt-pass", "", "MQTT password (optional)")
 	mqttPrefix := flag.String("mqtt-prefix", "capi", "MQTT topic prefix")
+	mqttDiscovery := flag.Bool("mqtt-discovery", false, "Publish Home Assistant MQTT discovery configs")
+	authTokenFlag := flag.String("auth-token", "", "Require this bearer token on /api endpoints (empty disables auth)")
+	presenceInterval := flag.Duration("presence-interval", 10*time.Second, "How often to poll for devices joining or leaving the bus (0 disables)")
+	absentPolls := flag.Int("absent-polls", 3, "Consecutive missed polls before a device is reported as removed")
+	rescanSettleFlag := flag.Duration("rescan-settle", cec.DefaultRescanSettle, "How long a bus rescan waits for devices to answer")
+	initBackoffFlag := flag.Duration("cec-init-backoff", defaultInitBackoff, "Initial delay between CEC initialization attempts")
+	initMaxBackoffFlag := flag.Duration("cec-init-max-backoff", defaultInitMaxBackoff, "Maximum delay between CEC initialization attempts")
+	cecRate := flag.Float64("cec-rate", 0, "Maximum /api/command and /api/key requests per second, each (0 disables the limit)")
+	simulate := flag.Bool("simulate", false, "Serve a simulated CEC bus instead of opening an adapter (for development without hardware)")
+	logLevelFlag := flag.String("log-level", "notice", "Least severe libcec log level printed to the console: "+logLevelNames)
+	logJSON := flag.Bool("log-json", false, "Print logs as JSON lines")
+	recordPath := flag.String("record", "", "Append every CEC frame seen on the bus to this CSV file")
+	recordDirFlag := flag.String("record-dir", "", "Directory for recordings named by /api/record/start and /api/replay (default: the -record file's directory, or /opt/capi)")
+	logBuffer := flag.Int("log-buffer", defaultLogBufferSize, fmt.Sprintf("How many libcec log messages /api/logs keeps (1-%d)", maxLogBufferSize))
 	flag.Parse()
 
 	if *showVersion {
@@ -1478,17 +6442,48 @@ func main() {
 		os.Exit(0)
 	}
 
-	if *doUpdate {
-		doSelfUpdate()
-		return
+	consoleLevel, ok := cec.ParseLogLevel(*logLevelFlag)
+	if !ok {
+		log.Fatalf("Invalid -log-level %q (use %s)", *logLevelFlag, logLevelNames)
+	}
+	if *logBuffer < 1 || *logBuffer > maxLogBufferSize {
+		log.Fatalf("Invalid -log-buffer %d (must be 1-%d)", *logBuffer, maxLogBufferSize)
+	}
+	var jsonLog *slog.Logger
+	if *logJSON {
+		// Route the standard logger through slog too, so every line is JSON.
+		jsonLog = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		slog.SetDefault(jsonLog)
+	}
+
+	if err := validateUpdateRepo(*updateRepoFlag); err != nil {
+		log.Fatalf("Invalid -update-repo %q: %v", *updateRepoFlag, err)
+	}
+	apiURL, err := normalizeAPIURL(*updateAPIURLFlag)
+	if err != nil {
+		log.Fatalf("Invalid -update-api-url %q: %v", *updateAPIURLFlag, err)
+	}
+	updateRepo, updateAPIURL = *updateRepoFlag, apiURL
+	if updateRepo != defaultUpdateRepo || updateAPIURL != defaultUpdateAPIURL {
+		log.Printf("Updates come from %s at %s", updateRepo, updateAPIURL)
 	}
 
 	// Determine config file path (next to the binary)
 	exe, _ := os.Executable()
 	configFilePath = filepath.Join(filepath.Dir(exe), "config.json")
 
+	if *doUpdate {
+		setUpdateToken(loadConfig(configFilePath).GitHubToken)
+		doSelfUpdate()
+		return
+	}
+
+	deviceCacheFilePath = filepath.Join(filepath.Dir(exe), "devices.json")
+	deviceSnapshot = loadDeviceCache(deviceCacheFilePath)
+
 	// Load persisted config; CLI flags override config file values
 	currentConfig = loadConfig(configFilePath)
+	setUpdateToken(currentConfig.GitHubToken)
 	if *mqttBroker != "" {
 		currentConfig.MQTT.Broker = *mqttBroker
 	}
@@ -1498,9 +6493,47 @@ func main() {
 	if *mqttPass != "" {
 		currentConfig.MQTT.Pass = *mqttPass
 	}
+	if *mqttDiscovery {
+		currentConfig.MQTT.Discovery = true
+	}
+	if *authTokenFlag != "" {
+		currentConfig.Auth.Token = *authTokenFlag
+	}
+	initBackoff := Backoff{Initial: defaultInitBackoff, Max: defaultInitMaxBackoff}
+	if currentConfig.CEC.InitBackoff != 0 {
+		initBackoff.Initial = time.Duration(currentConfig.CEC.InitBackoff)
+	}
+	if currentConfig.CEC.InitMaxBackoff != 0 {
+		initBackoff.Max = time.Duration(currentConfig.CEC.InitMaxBackoff)
+	}
+	listenAddr := *bindAddr
+	if currentConfig.Bind != "" {
+		if err := validateBindAddr(currentConfig.Bind); err != nil {
+			log.Printf("Ignoring invalid bind %q in config: %v", currentConfig.Bind, err)
+		} else {
+			listenAddr = currentConfig.Bind
+		}
+	}
+	nameFlagSet := false
 	flag.Visit(func(f *flag.Flag) {
-		if f.Name == "mqtt-prefix" {
+		switch f.Name {
+		case "bind":
+			listenAddr = *bindAddr
+		case "name":
+			nameFlagSet = true
+		case "adapter":
+			currentConfig.CEC.AdapterPath = *adapterPath
+		case "device-type":
+			if _, ok := cec.ParseDeviceType(*deviceTypeFlag); !ok {
+				log.Fatalf("Invalid -device-type %q (use %s)", *deviceTypeFlag, deviceTypeNames)
+			}
+			currentConfig.CEC.DeviceType = *deviceTypeFlag
+		case "mqtt-prefix":
 			currentConfig.MQTT.Prefix = *mqttPrefix
+		case "cec-init-backoff":
+			initBackoff.Initial = *initBackoffFlag
+		case "cec-init-max-backoff":
+			initBackoff.Max = *initMaxBackoffFlag
 		}
 	})
 	if currentConfig.MQTT.Prefix == "" {
@@ -1509,94 +6542,141 @@ func main() {
 
 	// Set up event hub and logging (independent of CEC)
 	eventHub = NewEventHub(64)
-	logHandler = NewLogHandler()
+	logHandler = NewLogHandler(*logBuffer, consoleLevel, jsonLog)
 
-	// Initialize CEC in background so the HTTP server starts regardless
-	go func() {
-		const maxBackoff = 60 * time.Second
-		backoff := 3 * time.Second
+	if lang := currentConfig.CEC.MenuLanguage; lang != "" && !cec.IsValidMenuLanguage(lang) {
+		log.Printf("Ignoring invalid cec.menu_language %q (must be a 3-letter lowercase ISO 639-2 code)", lang)
+		currentConfig.CEC.MenuLanguage = ""
+	}
 
-		for {
-			log.Println("Initializing CEC connection...")
-			conn, err := cec.Open(*deviceName, cec.DeviceTypeRecordingDevice)
-			if err != nil {
-				log.Printf("Failed to initialize CEC: %v — retrying in %v", err, backoff)
-				time.Sleep(backoff)
-				if backoff < maxBackoff {
-					backoff *= 2
-					if backoff > maxBackoff {
-						backoff = maxBackoff
-					}
-				}
-				continue
-			}
+	deviceType := cec.DeviceTypeRecordingDevice
+	if name := currentConfig.CEC.DeviceType; name != "" {
+		if t, ok := cec.ParseDeviceType(name); ok {
+			deviceType = t
+		} else {
+			log.Printf("Ignoring invalid cec.device_type %q (use %s)", name, deviceTypeNames)
+			currentConfig.CEC.DeviceType = ""
+		}
+	}
 
-			conn.SetCallbackHandler(logHandler)
-
-			// Find adapter
-			adapter := *adapterPath
-			if adapter == "" {
-				log.Println("Searching for CEC adapters...")
-				adapters, err := conn.FindAdapters()
-				if err != nil || len(adapters) == 0 {
-					log.Printf("No CEC adapters found — retrying in %v", backoff)
-					conn.Close()
-					time.Sleep(backoff)
-					if backoff < maxBackoff {
-						backoff *= 2
-						if backoff > maxBackoff {
-							backoff = maxBackoff
-						}
-					}
-					continue
-				}
-				if adapters[0].Comm != "" && strings.HasPrefix(adapters[0].Comm, "/dev/") {
-					adapter = adapters[0].Comm
-				} else {
-					adapter = adapters[0].Path
-				}
-				log.Printf("Found adapter: %s", adapter)
-			}
+	if p := currentConfig.CEC.HDMIPort; p < 0 || p > maxHDMIPort {
+		log.Printf("Ignoring invalid cec.hdmi_port %d (must be 0-%d)", p, maxHDMIPort)
+		currentConfig.CEC.HDMIPort = 0
+	}
 
-			// Open adapter
-			log.Printf("Opening CEC adapter: %s", adapter)
-			if err := conn.OpenAdapter(adapter); err != nil {
-				log.Printf("Failed to open CEC adapter: %v — retrying in %v", err, backoff)
-				conn.Close()
-				time.Sleep(backoff)
-				if backoff < maxBackoff {
-					backoff *= 2
-					if backoff > maxBackoff {
-						backoff = maxBackoff
-					}
-				}
-				continue
-			}
+	if n := currentConfig.CEC.SwitchRetries; n < 0 || n > cec.MaxSwitchRetries {
+		log.Printf("Ignoring invalid cec.switch_retries %d (must be 0-%d)", n, cec.MaxSwitchRetries)
+		currentConfig.CEC.SwitchRetries = 0
+	}
 
-			log.Println("CEC connection established")
-			log.Println(conn.GetLibInfo())
+	if m := currentConfig.CEC.WakeMode; m != "" {
+		if _, ok := cec.ParseWakeMode(m); !ok {
+			log.Printf("Ignoring invalid cec.wake_mode %q (use image_view_on or text_view_on)", m)
+			currentConfig.CEC.WakeMode = ""
+		}
+	}
 
-			// Wait for CEC bus to settle
-			time.Sleep(2 * time.Second)
+	if err := currentConfig.QuietHours.Validate(); err != nil {
+		log.Printf("Quiet hours disabled: %v", err)
+		currentConfig.QuietHours = QuietHoursConfig{}
+	} else if currentConfig.QuietHours.Start != "" {
+		log.Printf("Quiet hours: %s-%s", currentConfig.QuietHours.Start, currentConfig.QuietHours.End)
+	}
 
-			// Publish the connection
-			cecMutex.Lock()
-			cecConn = conn
-			cecReady = true
-			cecMutex.Unlock()
+	if err := validateKeyForwards(currentConfig.KeyForwards); err != nil {
+		log.Printf("Key forwarding disabled: %v", err)
+	} else if len(currentConfig.KeyForwards) > 0 {
+		keyForwarder.SetRules(currentConfig.KeyForwards)
+		log.Printf("Loaded %d key forwarding rule(s)", len(currentConfig.KeyForwards))
+	}
 
-			log.Println("CEC adapter is ready")
+	if len(currentConfig.VendorNames) > 0 {
+		configuredVendorNames = parseVendorNames(currentConfig.VendorNames)
+		cec.SetVendorNames(configuredVendorNames)
+		log.Printf("Loaded %d vendor name(s)", len(configuredVendorNames))
+	}
 
-			// Start MQTT bridge if configured
-			if currentConfig.MQTT.Broker != "" {
-				startMQTT(currentConfig.MQTT.Broker, currentConfig.MQTT.User, currentConfig.MQTT.Pass, currentConfig.MQTT.Prefix)
-			}
-			return
+	validateScenes(currentConfig.Scenes)
+	currentConfig.Schedules = validateSchedules(currentConfig.Schedules)
+	scheduler.Set(currentConfig.Schedules)
+	scheduler.Start()
+
+	recordDir = *recordDirFlag
+	if recordDir == "" {
+		recordDir = "/opt/capi"
+		if *recordPath != "" {
+			recordDir = filepath.Dir(*recordPath)
 		}
-	}()
+	}
+	if *recordPath != "" {
+		defaultRecordPath = *recordPath
+		if err := recorder.Start(*recordPath); err != nil {
+			log.Fatalf("Cannot record to %s: %v", *recordPath, err)
+		}
+	}
+	if len(currentConfig.Schedules) > 0 {
+		log.Printf("Loaded %d schedule(s)", len(currentConfig.Schedules))
+	}
+
+	if currentConfig.EventLogFile != "" {
+		eventLog, err := NewEventFileLogger(currentConfig.EventLogFile, currentConfig.EventLogMaxSizeMB, currentConfig.EventLogMaxFiles)
+		if err != nil {
+			log.Printf("Event log disabled: %v", err)
+		} else {
+			log.Printf("Writing CEC events to %s", currentConfig.EventLogFile)
+			go runEventFileLogger(eventLog)
+		}
+	}
+
+	if *cecRate < 0 {
+		log.Fatalf("-cec-rate must not be negative")
+	}
+	setCECRate(*cecRate)
+
+	if *absentPolls < 1 {
+		log.Fatalf("-absent-polls must be at least 1")
+	}
+	if *rescanSettleFlag < 0 || *rescanSettleFlag > maxScanSettle {
+		log.Fatalf("-rescan-settle must be between 0 and %v", maxScanSettle)
+	}
+	rescanSettle = *rescanSettleFlag
+	if err := initBackoff.Validate(); err != nil {
+		log.Fatalf("Invalid CEC init backoff: %v", err)
+	}
+	if name := currentConfig.CEC.DeviceName; name != "" && !nameFlagSet {
+		if err := cec.ValidateDeviceName(name); err != nil {
+			log.Printf("Ignoring invalid cec.device_name %q: %v", name, err)
+		} else {
+			*deviceName = name
+		}
+	}
+	if err := cec.ValidateDeviceName(*deviceName); err != nil {
+		log.Fatalf("Invalid -name: %v", err)
+	}
+	if short := cec.TruncateDeviceName(*deviceName); short != *deviceName {
+		log.Printf("Device name %q exceeds %d bytes; advertising %q", *deviceName, cec.MaxDeviceNameLength, short)
+	}
+	if *presenceInterval > 0 {
+		presenceMonitor = NewPresenceMonitor(activeDevicesIfReady, *absentPolls)
+		go presenceMonitor.Run(*presenceInterval)
+	}
+	if *updateCheckInterval > 0 && version != "dev" {
+		go watchForUpdates(*updateCheckInterval)
+	}
+
+	if *simulate {
+		initSimulator(*deviceName)
+	} else {
+		// Initialize CEC in background so the HTTP server starts regardless
+		go initCEC(*deviceName, currentConfig.CEC.AdapterPath, deviceType, initBackoff)
+	}
 
 	// Set up HTTP router
 	r := mux.NewRouter()
+	r.Use(authMiddleware)
+	if currentConfig.Auth.Token != "" {
+		log.Println("API token authentication enabled")
+	}
 
 	// Web UI — load index.html from same directory as the binary
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -1613,13 +6693,22 @@ func main() {
 
 	// Device endpoints
 	r.HandleFunc("/api/devices", getDevicesHandler).Methods("GET")
+	r.HandleFunc("/api/scan", scanHandler).Methods("POST")
+	r.HandleFunc("/api/devices/batch", getDevicesBatchHandler).Methods("POST")
 	r.HandleFunc("/api/devices/{address}", getDeviceHandler).Methods("GET")
+	r.HandleFunc("/api/devices/{address}/ping", pingDeviceHandler).Methods("GET")
+	r.HandleFunc("/api/devices/{address}/osd-override", getOSDOverrideHandler).Methods("GET")
+	r.HandleFunc("/api/devices/{address}/osd-override", putOSDOverrideHandler).Methods("PUT")
+	r.HandleFunc("/api/presence", getPresenceHandler).Methods("GET")
 
 	// Power control
 	r.HandleFunc("/api/power/on", powerOnHandler).Methods("POST")
 	r.HandleFunc("/api/power/on/{address}", powerOnHandler).Methods("POST")
 	r.HandleFunc("/api/power/off", powerOffHandler).Methods("POST")
+	r.HandleFunc("/api/power/off/all", powerOffAllHandler).Methods("POST")
 	r.HandleFunc("/api/power/off/{address}", powerOffHandler).Methods("POST")
+	r.HandleFunc("/api/power/toggle/{address}", powerToggleHandler).Methods("POST")
+	r.HandleFunc("/api/power/wait", powerWaitHandler).Methods("POST")
 	r.HandleFunc("/api/power/status", getPowerStatusHandler).Methods("GET")
 	r.HandleFunc("/api/power/status/{address}", getPowerStatusHandler).Methods("GET")
 
@@ -1628,67 +6717,120 @@ func main() {
 	r.HandleFunc("/api/volume/up/{address}", volumeUpHandler).Methods("POST")
 	r.HandleFunc("/api/volume/down", volumeDownHandler).Methods("POST")
 	r.HandleFunc("/api/volume/down/{address}", volumeDownHandler).Methods("POST")
+	r.HandleFunc("/api/volume/set", setVolumeHandler).Methods("POST")
 	r.HandleFunc("/api/volume/mute", muteHandler).Methods("POST")
+	r.HandleFunc("/api/volume/mute/on", setMuteHandler(true)).Methods("POST")
+	r.HandleFunc("/api/volume/mute/off", setMuteHandler(false)).Methods("POST")
 	r.HandleFunc("/api/volume/mute/{address}", muteHandler).Methods("POST")
 
 	// Source control
 	r.HandleFunc("/api/source/active", getActiveSourceHandler).Methods("GET")
+	r.HandleFunc("/api/source/list", getSourceListHandler).Methods("GET")
+	r.HandleFunc("/api/source/inactive", setInactiveSourceHandler).Methods("POST")
 	r.HandleFunc("/api/source/{address}", setActiveSourceHandler).Methods("POST")
 	r.HandleFunc("/api/hdmi/{port}", setHDMIPortHandler).Methods("POST")
+	r.HandleFunc("/api/inputs", getInputsHandler).Methods("GET")
+	r.HandleFunc("/api/inputs/{port}/select", setHDMIPortHandler).Methods("POST")
+	r.HandleFunc("/api/stream-path", setStreamPathHandler).Methods("POST")
 
 	// Topology
 	r.HandleFunc("/api/topology", getTopologyHandler).Methods("GET")
+	r.HandleFunc("/api/topology/refresh", refreshTopologyHandler).Methods("POST")
+
+	// Diagnostics
+	r.HandleFunc("/api/diagnostics", getDiagnosticsHandler).Methods("GET")
+	r.HandleFunc("/api/adapter", getAdapterHandler).Methods("GET")
+	r.HandleFunc("/api/adapter/config", getAdapterConfigHandler).Methods("GET")
+	r.HandleFunc("/api/vendors", getVendorsHandler).Methods("GET")
 
 	// Audio status
 	r.HandleFunc("/api/audio/status", getAudioStatusHandler).Methods("GET")
+	r.HandleFunc("/api/audio/rate", setAudioRateHandler).Methods("POST")
 
 	// Navigation
 	r.HandleFunc("/api/key", sendKeyHandler).Methods("POST")
+	r.HandleFunc("/api/key/sequence", sendKeySequenceHandler).Methods("POST")
+
+	// OSD
+	r.HandleFunc("/api/osd", osdHandler).Methods("POST")
+	r.HandleFunc("/api/osd/clear", osdClearHandler).Methods("POST")
+
+	// Scenes
+	r.HandleFunc("/api/scenes", getScenesHandler).Methods("GET")
+	r.HandleFunc("/api/scenes", postScenesHandler).Methods("POST")
+	r.HandleFunc("/api/scenes/{name}", deleteSceneHandler).Methods("DELETE")
+	r.HandleFunc("/api/scenes/{name}/run", runSceneHandler).Methods("POST")
+
+	// Schedules
+	r.HandleFunc("/api/schedules", getSchedulesHandler).Methods("GET")
+	r.HandleFunc("/api/schedules", postSchedulesHandler).Methods("POST")
+	r.HandleFunc("/api/schedules/{name}", deleteScheduleHandler).Methods("DELETE")
 
 	// Raw command
 	r.HandleFunc("/api/command", rawCommandHandler).Methods("POST")
+	r.HandleFunc("/api/command/vendor", vendorCommandHandler).Methods("POST")
 
 	// Logs
 	r.HandleFunc("/api/logs", getLogsHandler).Methods("GET")
+	r.HandleFunc("/api/record/start", postRecordStartHandler).Methods("POST")
+	r.HandleFunc("/api/record/stop", postRecordStopHandler).Methods("POST")
+	r.HandleFunc("/api/replay", replayHandler).Methods("POST")
 
 	// Server-Sent Events (real-time CEC bus events)
 	r.HandleFunc("/api/events", eventsSSEHandler).Methods("GET")
+	r.HandleFunc("/api/ws", eventsWSHandler).Methods("GET")
 
 	// Health
 	r.HandleFunc("/api/health", healthHandler).Methods("GET")
+	r.HandleFunc("/api/livez", livezHandler).Methods("GET")
+	r.HandleFunc("/api/readyz", readyzHandler).Methods("GET")
+
+	// Prometheus metrics
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	// Self-update
 	r.HandleFunc("/api/update", updateHandler).Methods("POST")
+	r.HandleFunc("/api/update/rollback", updateRollbackHandler).Methods("POST")
+	r.HandleFunc("/api/update/check", updateCheckHandler).Methods("GET")
 
 	// MQTT settings
 	r.HandleFunc("/api/settings/mqtt", getMQTTSettingsHandler).Methods("GET")
 	r.HandleFunc("/api/settings/mqtt", postMQTTSettingsHandler).Methods("POST")
 
+	r.HandleFunc("/api/settings/cec", getCECSettingsHandler).Methods("GET")
+	r.HandleFunc("/api/settings/cec", postCECSettingsHandler).Methods("POST")
+
+	r.HandleFunc("/api/settings/bind", getBindSettingsHandler).Methods("GET")
+	r.HandleFunc("/api/settings/bind", postBindSettingsHandler).Methods("POST")
+
+	r.HandleFunc("/api/settings/loglevel", getLogLevelSettingsHandler).Methods("GET")
+	r.HandleFunc("/api/settings/loglevel", postLogLevelSettingsHandler).Methods("POST")
+
 	// Start server with graceful shutdown (signal.Notify works on Go 1.15+)
-	server := &http.Server{Addr: *bindAddr, Handler: r}
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	go func() {
-		log.Printf("Starting HTTP server on %s", *bindAddr)
-		log.Printf("API documentation: http://%s/api/health", *bindAddr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
-		}
-	}()
+	httpServer = NewHTTPServer(r)
+	if err := httpServer.Start(listenAddr); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	log.Printf("API documentation: http://%s/api/health", listenAddr)
 
 	<-sigChan
 	log.Println("Shutting down...")
+	scheduler.Stop()
+	recorder.Stop()
 	stopMQTT()
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if err := server.Shutdown(shutdownCtx); err != nil {
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		log.Printf("HTTP server shutdown: %v", err)
 	}
 	// Close CEC connection if it was established
-	cecMutex.Lock()
-	if cecConn != nil {
-		cecConn.Close()
-	}
-	cecMutex.Unlock()
+	withCECTimeout(5*time.Second, func() error {
+		if cecConn == nil {
+			return nil
+		}
+		return cecConn.Close()
+	})
 }
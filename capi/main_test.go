@@ -0,0 +1,771 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"capi/cec"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/mux"
+)
+
+// fakeCEC is a simulated bus that records the calls the tests check
+// instead of acting on them.
+type fakeCEC struct {
+	*Simulator
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeCEC) record(format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+// Calls returns the recorded calls in order.
+func (f *fakeCEC) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeCEC) PowerOn(address cec.LogicalAddress) error {
+	f.record("PowerOn(%d)", address)
+	return nil
+}
+
+func (f *fakeCEC) SendButtonTimed(address cec.LogicalAddress, key cec.Keycode, hold time.Duration) error {
+	f.record("SendButtonTimed(%d, %d)", address, key)
+	return nil
+}
+
+func (f *fakeCEC) Transmit(command *cec.Command) error {
+	f.record("Transmit(%d->%d, 0x%02X, %d params)", command.Initiator, command.Destination, uint8(command.Opcode), len(command.Parameters))
+	return nil
+}
+
+// useFakeCEC makes a fakeCEC the ready CEC connection for the rest of the
+// test.
+func useFakeCEC(t *testing.T) *fakeCEC {
+	t.Helper()
+	f := &fakeCEC{Simulator: NewSimulator("capi-test", &cec.DefaultCallbackHandler{})}
+	publishCECConnection(f, "test")
+	t.Cleanup(func() {
+		cecStateMu.Lock()
+		cecReady = false
+		cecStateMu.Unlock()
+	})
+	return f
+}
+
+// serve runs handler on a request with the given JSON body and path
+// variables and returns the response.
+func serve(handler http.HandlerFunc, method, target, body string, vars map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// testRouter registers the routes the handler tests go through the same
+// way main does, so path variables and methods are matched by mux.
+func testRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/power/on", powerOnHandler).Methods("POST")
+	r.HandleFunc("/api/power/on/{address}", powerOnHandler).Methods("POST")
+	r.HandleFunc("/api/key", sendKeyHandler).Methods("POST")
+	r.HandleFunc("/api/command", rawCommandHandler).Methods("POST")
+	return r
+}
+
+// serveRoute sends a request with the given JSON body through testRouter
+// and returns the response.
+func serveRoute(method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	testRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestPowerOnHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+		calls  string
+	}{
+		{"address 3", "POST", "/api/power/on/3", http.StatusOK, "[PowerOn(3)]"},
+		{"TV by default", "POST", "/api/power/on", http.StatusOK, "[PowerOn(0)]"},
+		{"highest address", "POST", "/api/power/on/15", http.StatusOK, "[PowerOn(15)]"},
+		{"address 16", "POST", "/api/power/on/16", http.StatusBadRequest, "[]"},
+		{"negative address", "POST", "/api/power/on/-1", http.StatusBadRequest, "[]"},
+		{"not a number", "POST", "/api/power/on/tv", http.StatusBadRequest, "[]"},
+		{"wrong method", "GET", "/api/power/on/3", http.StatusMethodNotAllowed, "[]"},
+		{"extra path segment", "POST", "/api/power/on/3/4", http.StatusNotFound, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeCEC(t)
+			w := serveRoute(tt.method, tt.target, "")
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if got := fmt.Sprint(f.Calls()); got != tt.calls {
+				t.Errorf("calls = %s, want %s", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestHandlersBeforeCECReady(t *testing.T) {
+	f := useFakeCEC(t)
+	cecStateMu.Lock()
+	cecReady = false
+	cecStateMu.Unlock()
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{"power on", "/api/power/on/3", ""},
+		{"raw command", "/api/command", `{"initiator": 1, "destination": 0, "opcode": 4}`},
+		{"key", "/api/key", `{"address": 4, "key": "select"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRoute("POST", tt.target, tt.body)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want %d: %s", w.Code, http.StatusServiceUnavailable, w.Body.String())
+			}
+		})
+	}
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
+
+func TestRawCommandParameterLimit(t *testing.T) {
+	params := func(n int) string {
+		p := make([]string, n)
+		for i := range p {
+			p[i] = "1"
+		}
+		return "[" + strings.Join(p, ",") + "]"
+	}
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		calls  string
+	}{
+		{"14 parameters", `{"initiator": 1, "destination": 0, "opcode": 137, "parameters": ` + params(14) + `}`,
+			http.StatusOK, "[Transmit(1->0, 0x89, 14 params)]"},
+		{"15 parameters", `{"initiator": 1, "destination": 0, "opcode": 137, "parameters": ` + params(15) + `}`,
+			http.StatusBadRequest, "[]"},
+		{"no parameters", `{"initiator": 1, "destination": 0, "opcode": 4}`,
+			http.StatusOK, "[Transmit(1->0, 0x04, 0 params)]"},
+		{"destination 16", `{"initiator": 1, "destination": 16, "opcode": 4}`,
+			http.StatusBadRequest, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeCEC(t)
+			w := serveRoute("POST", "/api/command", tt.body)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if got := fmt.Sprint(f.Calls()); got != tt.calls {
+				t.Errorf("calls = %s, want %s", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestSendKeyKeycode(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		call   string
+	}{
+		{"explicit keycode 0 is Select", `{"address": 4, "keycode": 0}`, http.StatusOK, "SendButtonTimed(4, 0)"},
+		{"keycode omitted", `{"address": 4}`, http.StatusBadRequest, ""},
+		{"keycode null", `{"address": 4, "keycode": null}`, http.StatusBadRequest, ""},
+		{"key name select", `{"address": 4, "key": "select"}`, http.StatusOK, "SendButtonTimed(4, 0)"},
+		{"key wins over keycode", `{"address": 4, "key": "up", "keycode": 0}`, http.StatusOK, "SendButtonTimed(4, 1)"},
+		{"other keycode", `{"address": 4, "keycode": 44}`, http.StatusOK, "SendButtonTimed(4, 44)"},
+		{"keycode out of range", `{"address": 4, "keycode": 256}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeCEC(t)
+			w := serve(sendKeyHandler, "POST", "/api/key", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			calls := f.Calls()
+			if tt.call == "" {
+				if len(calls) != 0 {
+					t.Errorf("calls = %v, want none", calls)
+				}
+				return
+			}
+			if len(calls) != 1 || calls[0] != tt.call {
+				t.Errorf("calls = %v, want [%s]", calls, tt.call)
+			}
+		})
+	}
+}
+
+func TestFetchReleaseWithRetry(t *testing.T) {
+	defer func(d time.Duration) { updateCheckRetryDelay = d }(updateCheckRetryDelay)
+	updateCheckRetryDelay = time.Millisecond
+
+	tests := []struct {
+		name     string
+		statuses []int // one per request; the last one repeats
+		wantTag  string
+		wantErr  error
+		requests int
+	}{
+		{"500 then 200", []int{500, 200}, "v1.2.3", nil, 2},
+		{"502 twice then 200", []int{502, 502, 200}, "v1.2.3", nil, 3},
+		{"always 503", []int{503}, "", errUpdateTemporary, updateCheckAttempts},
+		{"404 is not retried", []int{404}, "", errReleaseNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests int
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[len(tt.statuses)-1]
+				if requests < len(tt.statuses) {
+					status = tt.statuses[requests]
+				}
+				requests++
+				mu.Unlock()
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					fmt.Fprint(w, `{"tag_name": "v1.2.3", "assets": []}`)
+				}
+			}))
+			defer srv.Close()
+
+			info, err := fetchReleaseWithRetry(srv.URL + "/repos/o/r/releases/latest")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("fetchReleaseWithRetry: %v", err)
+			} else if info.TagName != tt.wantTag {
+				t.Errorf("tag = %q, want %q", info.TagName, tt.wantTag)
+			}
+			if requests != tt.requests {
+				t.Errorf("%d requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestPresenceMonitorPoll(t *testing.T) {
+	type poll struct {
+		devices []cec.LogicalAddress
+		ok      bool
+		added   []cec.LogicalAddress
+		removed []cec.LogicalAddress
+	}
+	tests := []struct {
+		name        string
+		absentPolls int
+		polls       []poll
+	}{
+		{"first poll is the baseline", 1, []poll{
+			{devices: []cec.LogicalAddress{0, 4}, ok: true},
+		}},
+		{"added and removed", 1, []poll{
+			{devices: []cec.LogicalAddress{0, 4}, ok: true},
+			{devices: []cec.LogicalAddress{0, 5, 8}, ok: true, added: []cec.LogicalAddress{5, 8}, removed: []cec.LogicalAddress{4}},
+			{devices: []cec.LogicalAddress{0, 5, 8}, ok: true},
+		}},
+		{"removal waits for absentPolls misses", 3, []poll{
+			{devices: []cec.LogicalAddress{0, 4}, ok: true},
+			{devices: []cec.LogicalAddress{0}, ok: true},
+			{devices: []cec.LogicalAddress{0}, ok: true},
+			{devices: []cec.LogicalAddress{0}, ok: true, removed: []cec.LogicalAddress{4}},
+		}},
+		{"reappearing resets the misses", 2, []poll{
+			{devices: []cec.LogicalAddress{0, 4}, ok: true},
+			{devices: []cec.LogicalAddress{0}, ok: true},
+			{devices: []cec.LogicalAddress{0, 4}, ok: true},
+			{devices: []cec.LogicalAddress{0}, ok: true},
+			{devices: []cec.LogicalAddress{0}, ok: true, removed: []cec.LogicalAddress{4}},
+		}},
+		{"unavailable list is skipped", 1, []poll{
+			{ok: false},
+			{devices: []cec.LogicalAddress{0}, ok: true},
+			{ok: false},
+			{devices: []cec.LogicalAddress{0, 4}, ok: true, added: []cec.LogicalAddress{4}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var next poll
+			m := NewPresenceMonitor(func() ([]cec.LogicalAddress, bool) { return next.devices, next.ok }, tt.absentPolls)
+			for i, p := range tt.polls {
+				next = p
+				added, removed := m.Poll()
+				if fmt.Sprint(added) != fmt.Sprint(p.added) || fmt.Sprint(removed) != fmt.Sprint(p.removed) {
+					t.Errorf("poll %d: added %v, removed %v; want added %v, removed %v", i, added, removed, p.added, p.removed)
+				}
+			}
+		})
+	}
+}
+
+func TestEventHubSubscribeFiltered(t *testing.T) {
+	hub := NewEventHub(8)
+	all := hub.Subscribe()
+	defer hub.Unsubscribe(all)
+	power := hub.SubscribeFiltered("power_change", "source_activated")
+	defer hub.Unsubscribe(power)
+
+	for _, typ := range []string{"command", "power_change", "key_press", "source_activated"} {
+		hub.Publish(CECEvent{Type: typ})
+	}
+
+	received := func(ch chan CECEvent) []string {
+		var types []string
+		for {
+			select {
+			case ev := <-ch:
+				types = append(types, ev.Type)
+			default:
+				return types
+			}
+		}
+	}
+	if got, want := fmt.Sprint(received(all)), "[command power_change key_press source_activated]"; got != want {
+		t.Errorf("unfiltered subscriber got %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(received(power)), "[power_change source_activated]"; got != want {
+		t.Errorf("filtered subscriber got %s, want %s", got, want)
+	}
+}
+
+// fakeToken is an mqtt.Token that has already completed.
+type fakeToken struct{}
+
+func (fakeToken) Wait() bool                     { return true }
+func (fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (fakeToken) Done() <-chan struct{}          { return closedChan }
+func (fakeToken) Error() error                   { return nil }
+
+var closedChan = func() chan struct{} { c := make(chan struct{}); close(c); return c }()
+
+// fakePublisher records the retained value of every topic published to,
+// and every message that isn't retained.
+type fakePublisher struct {
+	mu       sync.Mutex
+	retained map[string]string
+	messages []string // "topic payload"
+}
+
+func (p *fakePublisher) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.retained == nil {
+		p.retained = make(map[string]string)
+	}
+	var text string
+	switch v := payload.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	}
+	if retained {
+		p.retained[topic] = text
+	} else {
+		p.messages = append(p.messages, topic+" "+text)
+	}
+	return fakeToken{}
+}
+
+func TestMQTTReconnectReseedsState(t *testing.T) {
+	f := useFakeCEC(t)
+	p := &fakePublisher{}
+	configMu.Lock()
+	saved := currentConfig
+	currentConfig.MQTT.Discovery = true
+	configMu.Unlock()
+	t.Cleanup(func() {
+		configMu.Lock()
+		currentConfig = saved
+		configMu.Unlock()
+		mqttMu.Lock()
+		mqttDiscovered = false
+		mqttMu.Unlock()
+	})
+
+	// What the on-connect handler publishes, for a first connect and a
+	// reconnect after the bus changed.
+	connect := func() map[string]string {
+		p.mu.Lock()
+		p.retained = nil
+		p.mu.Unlock()
+		mqttOnConnect(p, "capi")
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.retained
+	}
+
+	first := connect()
+	want := map[string]string{
+		"capi/status":              "online",
+		"capi/cec_ready":           "online",
+		"capi/state/power/0":       "on",
+		"capi/state/power/8":       "standby",
+		"capi/state/active_source": "4",
+	}
+	for topic, payload := range want {
+		if got := first[topic]; got != payload {
+			t.Errorf("first connect: %s = %q, want %q", topic, got, payload)
+		}
+	}
+	discovery := 0
+	for topic := range first {
+		if strings.HasPrefix(topic, haDiscoveryPrefix+"/") {
+			discovery++
+		}
+	}
+	if discovery != len(haEntities("capi")) {
+		t.Errorf("first connect published %d discovery configs, want %d", discovery, len(haEntities("capi")))
+	}
+
+	// The PlayStation turns on while the broker is unreachable.
+	if err := f.Simulator.PowerOn(cec.LogicalAddressPlaybackDevice2); err != nil {
+		t.Fatal(err)
+	}
+	second := connect()
+	if got := second["capi/state/power/8"]; got != "on" {
+		t.Errorf("reconnect: capi/state/power/8 = %q, want %q", got, "on")
+	}
+	for topic := range first {
+		if _, ok := second[topic]; !ok {
+			t.Errorf("reconnect didn't republish %s", topic)
+		}
+	}
+}
+
+func TestCECRateLimit(t *testing.T) {
+	defer setCECRate(0)
+
+	allowed := func(n int) int {
+		ok := 0
+		for i := 0; i < n; i++ {
+			w := httptest.NewRecorder()
+			if !rejectRateLimited(w, commandLimiter) {
+				ok++
+				continue
+			}
+			if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+				t.Errorf("rejected with %d, Retry-After %q; want 429, 1", w.Code, w.Header().Get("Retry-After"))
+			}
+		}
+		return ok
+	}
+
+	// A burst of one second's worth, then nothing until tokens refill.
+	setCECRate(10)
+	if got := allowed(15); got != 10 {
+		t.Errorf("burst allowed %d of 15 requests, want 10", got)
+	}
+	time.Sleep(150 * time.Millisecond) // at 10/s, at least one token
+	if got := allowed(1); got != 1 {
+		t.Error("request after refill was rejected")
+	}
+
+	// Keys are counted separately from commands.
+	if keyLimiter.Tokens() < 9 {
+		t.Errorf("key bucket has %.1f tokens, want a full burst of 10", keyLimiter.Tokens())
+	}
+
+	// Fractional rates round the burst up.
+	setCECRate(2.5)
+	if got := allowed(5); got != 3 {
+		t.Errorf("burst at 2.5/s allowed %d of 5 requests, want 3", got)
+	}
+
+	// Zero removes the limit.
+	setCECRate(0)
+	if got := allowed(1000); got != 1000 {
+		t.Errorf("unlimited allowed %d of 1000 requests", got)
+	}
+}
+
+func TestRecordStartPath(t *testing.T) {
+	defer func(dir string) { recordDir = dir }(recordDir)
+	recordDir = t.TempDir()
+
+	for _, name := range []string{"../bus.csv", "/etc/bus.csv", "sub/bus.csv", ".."} {
+		w := serve(postRecordStartHandler, "POST", "/api/record/start", fmt.Sprintf(`{"path": %q}`, name), nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("path %q: status %d, want 400", name, w.Code)
+		}
+	}
+
+	w := serve(postRecordStartHandler, "POST", "/api/record/start", `{"path": "bus.csv"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+	}
+	if _, _, err := recorder.Stop(); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(recordDir, "bus.csv")); err != nil {
+		t.Errorf("recording not created in the recordings directory: %v", err)
+	}
+}
+
+func TestReplayPath(t *testing.T) {
+	useFakeCEC(t)
+	defer func(dir string) { recordDir = dir }(recordDir)
+	recordDir = t.TempDir()
+
+	w := serve(replayHandler, "POST", "/api/replay", `{"path": "../../etc/shadow"}`, nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Invalid path") {
+		t.Errorf("path outside the recordings directory: %d %s", w.Code, w.Body)
+	}
+
+	// A file that isn't a recording must not have its contents echoed back.
+	content := "timestamp,initiator\nroot:hunter2:19000,0,99999,,,\n"
+	if err := os.WriteFile(filepath.Join(recordDir, "bad.csv"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(replayHandler, "POST", "/api/replay", `{"path": "bad.csv"}`, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("error echoes the file: %s", w.Body)
+	}
+}
+
+func TestVerifyDownloadWithoutChecksum(t *testing.T) {
+	// A release without a .sha256 or checksums.txt asset; nothing is fetched.
+	info := &releaseInfo{TagName: "v1.0.0", Assets: []releaseAsset{{Name: "capi-linux-arm64"}}}
+	path := filepath.Join(t.TempDir(), "capi.new")
+	if err := os.WriteFile(path, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDownload(info, "capi-linux-arm64", path, false); !errors.Is(err, errNoChecksum) {
+		t.Errorf("err = %v, want errNoChecksum", err)
+	}
+	if err := verifyDownload(info, "capi-linux-arm64", path, true); err != nil {
+		t.Errorf("with allowUnverified: %v", err)
+	}
+}
+
+func TestBinaryAssetNamesFor(t *testing.T) {
+	tests := []struct {
+		goarch, goarm string
+		want          []string
+		wantErr       string
+	}{
+		{"arm64", "", []string{"capi-linux-arm64"}, ""},
+		{"arm", "8", []string{"capi-linux-armv7", "capi-linux-armv6"}, ""},
+		{"arm", "7", []string{"capi-linux-armv7", "capi-linux-armv6"}, ""},
+		{"arm", "6", []string{"capi-linux-armv6"}, ""},
+		{"arm", "5", nil, "no release builds for ARMv5"},
+		{"arm", "", nil, "cannot tell the ARM version"},
+		{"arm", "v7", nil, "cannot tell the ARM version"},
+		{"amd64", "", nil, "no release builds for amd64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goarch+"/"+tt.goarm, func(t *testing.T) {
+			got, err := binaryAssetNamesFor(tt.goarch, tt.goarm)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("binaryAssetNamesFor(%q, %q): %v", tt.goarch, tt.goarm, err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("binaryAssetNamesFor(%q, %q) = %v, want %v", tt.goarch, tt.goarm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckForUpdate(t *testing.T) {
+	defer func(v, repo, api string) { version, updateRepo, updateAPIURL = v, repo, api }(version, updateRepo, updateAPIURL)
+	version, updateRepo = "v1.0.0", "owner/capi"
+
+	latest := "v1.0.0"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/capi/releases/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"tag_name": %q, "assets": [{"name": "capi-linux-arm64"}]}`, latest)
+	}))
+	defer srv.Close()
+	updateAPIURL = srv.URL
+
+	info, err := checkForUpdate()
+	if err != nil {
+		t.Fatalf("checkForUpdate: %v", err)
+	}
+	if info != nil {
+		t.Errorf("up to date: got release %q, want nil", info.TagName)
+	}
+
+	latest = "v1.1.0"
+	info, err = checkForUpdate()
+	if err != nil {
+		t.Fatalf("checkForUpdate: %v", err)
+	}
+	if info == nil || info.TagName != "v1.1.0" || len(info.Assets) != 1 {
+		t.Errorf("update available: got %+v, want v1.1.0 with one asset", info)
+	}
+}
+
+func TestPublishSceneResult(t *testing.T) {
+	useFakeCEC(t)
+	tv, absent := 0, 11
+	steps := []SceneStep{
+		{Action: "power_on", Address: &tv},
+		{Action: "source", Address: &absent},
+		{Action: "standby", Address: &tv},
+	}
+	results, err := runScene(steps)
+	if err == nil {
+		t.Fatal("runScene: switching to an absent device succeeded")
+	}
+
+	p := &fakePublisher{}
+	publishSceneResult(p, "capi", 0, false, "Movie Night", results, 1500*time.Millisecond, err)
+	want := `capi/event/scene_result {"duration_ms":1500,"error":"` + err.Error() + `","name":"Movie Night","steps":[` +
+		`{"index":0,"action":"power_on","status":"ok"},` +
+		`{"index":1,"action":"source","status":"error","error":"` + err.Error() + `"},` +
+		`{"index":2,"action":"standby","status":"skipped"}]}`
+	if len(p.messages) != 1 || p.messages[0] != want {
+		t.Errorf("published %q, want %q", p.messages, want)
+	}
+
+	p = &fakePublisher{}
+	publishSceneResult(p, "capi", 0, false, "TV on", results[:1], 20*time.Millisecond, nil)
+	if len(p.messages) != 1 || strings.Contains(p.messages[0], `"error"`) {
+		t.Errorf("successful run published %q, want one message without an error", p.messages)
+	}
+}
+
+func TestPostCECSettingsAddress(t *testing.T) {
+	f := useFakeCEC(t)
+	defer func(cfg Config, path string) {
+		configMu.Lock()
+		currentConfig, configFilePath = cfg, path
+		configMu.Unlock()
+	}(currentConfig, configFilePath)
+	configFilePath = filepath.Join(t.TempDir(), "config.json")
+
+	post := func(body string) {
+		t.Helper()
+		w := serve(postCECSettingsHandler, "POST", "/api/settings/cec", body, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"applied":true`) {
+			t.Fatalf("POST %s: %d %s", body, w.Code, w.Body)
+		}
+	}
+	check := func(step string, physical uint16, port uint8, saved CECConfig) {
+		t.Helper()
+		config, _ := f.GetCurrentConfiguration()
+		if config.PhysicalAddress != physical || config.HDMIPort != port {
+			t.Errorf("%s: adapter has physical address 0x%04X, port %d; want 0x%04X, %d",
+				step, config.PhysicalAddress, config.HDMIPort, physical, port)
+		}
+		configMu.RLock()
+		got := currentConfig.CEC
+		configMu.RUnlock()
+		if got.PhysicalAddress != saved.PhysicalAddress || got.HDMIPort != saved.HDMIPort {
+			t.Errorf("%s: saved physical_address %q, hdmi_port %d; want %q, %d",
+				step, got.PhysicalAddress, got.HDMIPort, saved.PhysicalAddress, saved.HDMIPort)
+		}
+	}
+
+	initial, _ := f.GetCurrentConfiguration()
+	post(`{"physical_address": "2.0.0.0"}`)
+	check("pinned address", 0x2000, initial.HDMIPort, CECConfig{PhysicalAddress: "2.0.0.0"})
+	post(`{"hdmi_port": 3}`)
+	check("port after address", 0, 3, CECConfig{HDMIPort: 3})
+
+	post(`{"physical_address": "1.0.0.0"}`)
+	post(`{"physical_address": "", "hdmi_port": 0}`)
+	check("back to auto-detection", 0xFFFF, 0, CECConfig{})
+}
+
+func TestPowerWaitLeavesQueueFree(t *testing.T) {
+	f := useFakeCEC(t)
+	if err := f.Simulator.Standby(cec.LogicalAddressTV); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(powerWaitHandler, "POST", "/api/power/wait", `{"address": 0, "state": "on", "timeout_ms": 5000, "poll_ms": 100}`, nil)
+	}()
+
+	// Other jobs must get through while the handler is waiting
+	time.Sleep(150 * time.Millisecond)
+	start := time.Now()
+	if err := withCEC(func() error { return f.Simulator.PowerOn(cec.LogicalAddressTV) }); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("job waited %v behind the power wait", d)
+	}
+
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200: %s", w.Code, w.Body.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait did not notice the TV turning on")
+	}
+
+	if err := f.Simulator.Standby(cec.LogicalAddressTV); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(powerWaitHandler, "POST", "/api/power/wait", `{"address": 0, "state": "on", "timeout_ms": 200, "poll_ms": 100}`, nil)
+	if w.Code != http.StatusRequestTimeout {
+		t.Errorf("status = %d, want 408: %s", w.Code, w.Body.String())
+	}
+}
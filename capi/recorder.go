@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"capi/cec"
+)
+
+// recordHeader is the first line of a recording file. Each following line
+// is one frame: an RFC 3339 timestamp with nanoseconds, the initiator and
+// destination logical addresses, the opcode as 0xNN (empty for a poll),
+// the parameters as hex bytes, and the ack and eom flags.
+var recordHeader = []string{"timestamp", "initiator", "destination", "opcode", "parameters", "ack", "eom"}
+
+// Errors returned by Recorder when it is in the wrong state.
+var (
+	errAlreadyRecording = errors.New("already recording")
+	errNotRecording     = errors.New("not recording")
+)
+
+// Recorder appends every frame OnCommand sees to a CSV file. It is safe
+// for concurrent use; Record is called from libcec's callback thread.
+type Recorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *csv.Writer
+	path  string
+	lines int
+
+	writeError bool // true while writes are failing, to avoid log spam
+}
+
+var recorder = &Recorder{}
+
+// Start opens path for appending and begins recording to it. A header is
+// written if the file is empty.
+func (rc *Recorder) Start(path string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.f != nil {
+		return fmt.Errorf("%w to %s", errAlreadyRecording, rc.path)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(recordHeader)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	rc.f, rc.w, rc.path, rc.lines, rc.writeError = f, w, path, 0, false
+	log.Printf("Recording CEC frames to %s", path)
+	return nil
+}
+
+// Stop closes the recording file and reports its path and how many frames
+// were written since Start.
+func (rc *Recorder) Stop() (path string, lines int, err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.f == nil {
+		return "", 0, errNotRecording
+	}
+	path, lines = rc.path, rc.lines
+	err = rc.closeLocked()
+	log.Printf("Stopped recording to %s (%d frames)", path, lines)
+	return path, lines, err
+}
+
+// Record appends cmd if a recording is running. Errors (e.g. disk full) are
+// logged once and the frame is dropped; recording resumes when writes
+// succeed again.
+func (rc *Recorder) Record(cmd *cec.Command) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.f == nil {
+		return
+	}
+	opcode := ""
+	if cmd.OpcodeSet {
+		opcode = fmt.Sprintf("0x%02X", uint8(cmd.Opcode))
+	}
+	rc.w.Write([]string{
+		time.Now().UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(int(cmd.Initiator)),
+		strconv.Itoa(int(cmd.Destination)),
+		opcode,
+		fmt.Sprintf("%X", cmd.Parameters),
+		strconv.FormatBool(cmd.Ack),
+		strconv.FormatBool(cmd.Eom),
+	})
+	rc.w.Flush()
+	if err := rc.w.Error(); err != nil {
+		if !rc.writeError {
+			log.Printf("Recording %s: write failed, dropping frames: %v", rc.path, err)
+			rc.writeError = true
+		}
+		// csv.Writer keeps its first error; start over on the same file.
+		rc.w = csv.NewWriter(rc.f)
+		return
+	}
+	if rc.writeError {
+		log.Printf("Recording %s: writes recovered", rc.path)
+		rc.writeError = false
+	}
+	rc.lines++
+}
+
+func (rc *Recorder) closeLocked() error {
+	rc.w.Flush()
+	err := rc.w.Error()
+	if cerr := rc.f.Close(); err == nil {
+		err = cerr
+	}
+	rc.f, rc.w = nil, nil
+	return err
+}
+
+// recordedFrame is one line of a recording file.
+type recordedFrame struct {
+	At  time.Time
+	Cmd *cec.Command
+}
+
+// readRecording parses a recording file, checking each frame with
+// Command.Validate. The header line is optional and the ack and eom
+// columns are ignored, since they describe how the bus answered rather
+// than the frame itself. At most max frames are read.
+func readRecording(r io.Reader, max int) ([]recordedFrame, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var frames []recordedFrame
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(frames) == 0 && len(rec) > 0 && rec[0] == recordHeader[0] {
+			continue
+		}
+		if len(frames) == max {
+			return nil, fmt.Errorf("more than %d frames", max)
+		}
+		f, err := parseRecordedFrame(rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+func parseRecordedFrame(rec []string) (recordedFrame, error) {
+	if len(rec) < 5 {
+		return recordedFrame{}, fmt.Errorf("want at least %d fields, got %d", 5, len(rec))
+	}
+	at, err := time.Parse(time.RFC3339Nano, rec[0])
+	if err != nil {
+		return recordedFrame{}, errors.New("invalid timestamp")
+	}
+	initiator, err := strconv.ParseUint(rec[1], 10, 8)
+	if err != nil {
+		return recordedFrame{}, errors.New("invalid initiator")
+	}
+	destination, err := strconv.ParseUint(rec[2], 10, 8)
+	if err != nil {
+		return recordedFrame{}, errors.New("invalid destination")
+	}
+	cmd := &cec.Command{
+		Initiator:   cec.LogicalAddress(initiator),
+		Destination: cec.LogicalAddress(destination),
+	}
+	if rec[3] != "" {
+		op, err := strconv.ParseUint(rec[3], 0, 8)
+		if err != nil {
+			return recordedFrame{}, errors.New("invalid opcode")
+		}
+		cmd.Opcode, cmd.OpcodeSet = cec.Opcode(op), true
+	}
+	if cmd.Parameters, err = hex.DecodeString(rec[4]); err != nil {
+		return recordedFrame{}, errors.New("invalid parameters")
+	}
+	if err := cmd.Validate(); err != nil {
+		return recordedFrame{}, err
+	}
+	return recordedFrame{At: at, Cmd: cmd}, nil
+}
+
+// ── Recording API ──────────────────────────────────────────────────────
+
+// defaultRecordPath is the -record path, used when POST /api/record/start
+// doesn't name a file.
+var defaultRecordPath string
+
+// recordDir is the -record-dir directory. Files named through the API are
+// always in it.
+var recordDir string
+
+// recordingPath returns the path of the named file in recordDir. Names
+// with a directory part are rejected, so API clients can't write or read
+// files anywhere else.
+func recordingPath(name string) (string, error) {
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("%q is not a file name in the recordings directory", name)
+	}
+	return filepath.Join(recordDir, name), nil
+}
+
+func postRecordStartHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &req) {
+		return
+	}
+	path := defaultRecordPath
+	if req.Path != "" {
+		var err error
+		if path, err = recordingPath(req.Path); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid path: %v", err))
+			return
+		}
+	}
+	if !requireField(w, path != "", "path") {
+		return
+	}
+	if err := recorder.Start(path); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errAlreadyRecording) {
+			status = http.StatusConflict
+		}
+		respondError(w, status, fmt.Sprintf("Cannot start recording: %v", err))
+		return
+	}
+	respondSuccess(w, fmt.Sprintf("Recording to %s", path), map[string]interface{}{
+		"path":  path,
+		"lines": 0,
+	})
+}
+
+func postRecordStopHandler(w http.ResponseWriter, r *http.Request) {
+	path, lines, err := recorder.Stop()
+	if errors.Is(err, errNotRecording) {
+		respondError(w, http.StatusConflict, "Not recording")
+		return
+	}
+	data := map[string]interface{}{
+		"path":  path,
+		"lines": lines,
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Recording stopped, but closing %s failed: %v", path, err),
+			Data:    data,
+		})
+		return
+	}
+	respondSuccess(w, fmt.Sprintf("Recorded %d frames to %s", lines, path), data)
+}
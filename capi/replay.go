@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on POST /api/replay.
+const (
+	maxReplayFrames   = 10000
+	maxReplayBodySize = 4 << 20
+	maxReplaySpeed    = 100
+)
+
+// replaySchedule returns how long to wait before each frame: the gap to
+// the previous frame's timestamp divided by speed. Out-of-order
+// timestamps give no wait.
+func replaySchedule(frames []recordedFrame, speed float64) []time.Duration {
+	waits := make([]time.Duration, len(frames))
+	for i := 1; i < len(frames); i++ {
+		if gap := frames[i].At.Sub(frames[i-1].At); gap > 0 {
+			waits[i] = time.Duration(float64(gap) / speed)
+		}
+	}
+	return waits
+}
+
+// replayHandler retransmits the frames of a recording with their original
+// spacing, scaled by speed. The recording is either named by a JSON body
+// ({"path": ..., "speed": 2}) or sent as a text/csv body with ?speed=.
+// Every frame is validated before the first is sent, and polls are sent
+// with PingDevice. The replay stops at the first transmit failure or when
+// the client disconnects.
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	var (
+		body  io.Reader
+		speed = 1.0
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		body = http.MaxBytesReader(w, r.Body, maxReplayBodySize)
+		if raw := r.URL.Query().Get("speed"); raw != "" {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid speed %q", raw))
+				return
+			}
+			speed = v
+		}
+	} else {
+		var req struct {
+			Path  string   `json:"path"`
+			Speed *float64 `json:"speed"`
+		}
+		if !decodeJSONBody(w, r, &req) || !requireField(w, req.Path != "", "path") {
+			return
+		}
+		if req.Speed != nil {
+			speed = *req.Speed
+		}
+		path, err := recordingPath(req.Path)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid path: %v", err))
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Cannot open recording: %v", err))
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	if speed <= 0 || speed > maxReplaySpeed {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Field 'speed' must be above 0 and at most %d", maxReplaySpeed))
+		return
+	}
+
+	frames, err := readRecording(body, maxReplayFrames)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid recording: %v", err))
+		return
+	}
+	if len(frames) == 0 {
+		respondError(w, http.StatusBadRequest, "Invalid recording: no frames")
+		return
+	}
+	for _, f := range frames {
+		if f.Cmd.OpcodeSet && isWakeOpcode(f.Cmd.Opcode) {
+			if rejectDuringQuietHours(w, r) {
+				return
+			}
+			break
+		}
+	}
+
+	start := time.Now()
+	waits := replaySchedule(frames, speed)
+	sent := 0
+	data := func() map[string]interface{} {
+		return map[string]interface{}{
+			"frames":      len(frames),
+			"sent":        sent,
+			"speed":       speed,
+			"duration_ms": time.Since(start).Milliseconds(),
+		}
+	}
+	for i, f := range frames {
+		if waits[i] > 0 {
+			select {
+			case <-time.After(waits[i]):
+			case <-r.Context().Done():
+				log.Printf("Replay cancelled by client after %d of %d frames", sent, len(frames))
+				return
+			}
+		}
+		cmd := f.Cmd
+		send := func() error { return cecConn.Transmit(cmd) }
+		if !cmd.OpcodeSet {
+			// A poll; whether it is acknowledged doesn't stop the replay.
+			send = func() error {
+				_, err := cecConn.PingDevice(cmd.Destination)
+				return err
+			}
+		}
+		if err := withCEC(send); err != nil {
+			respondJSON(w, cecErrorStatus(err), Response{
+				Status:  "error",
+				Message: fmt.Sprintf("Replay stopped at frame %d: %v", i, err),
+				Data:    data(),
+			})
+			return
+		}
+		sent++
+	}
+	respondSuccess(w, fmt.Sprintf("Replayed %d frames", sent), data())
+}
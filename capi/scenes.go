@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"capi/cec"
+
+	"github.com/gorilla/mux"
+)
+
+// Limits on saved scenes.
+const (
+	maxSceneNameLength = 64
+	maxSceneSteps      = 32
+	maxSceneDelayMS    = 30000
+)
+
+// SceneStep is one action of a scene. Which fields apply depends on Action:
+//
+//	power_on, standby  address (default 0, the TV)
+//	source             address
+//	hdmi               port
+//	key                key, address (default 0)
+//	volume             level (0-100)
+//	osd                message, address (default 0)
+//	raw                command (same fields as POST /api/command)
+//	delay              delay_ms
+//
+// For actions other than delay, delay_ms pauses after the step.
+type SceneStep struct {
+	Action  string             `json:"action"`
+	Address *int               `json:"address,omitempty"`
+	Port    int                `json:"port,omitempty"`
+	Key     string             `json:"key,omitempty"`
+	Level   *int               `json:"level,omitempty"`
+	Message string             `json:"message,omitempty"`
+	Command *rawCommandRequest `json:"command,omitempty"`
+	DelayMS int                `json:"delay_ms,omitempty"`
+}
+
+// SceneStepResult reports how one step of a scene run went.
+type SceneStepResult struct {
+	Index  int    `json:"index"`
+	Action string `json:"action"`
+	Status string `json:"status"` // "ok", "error" or "skipped"
+	Error  string `json:"error,omitempty"`
+}
+
+// address returns the step's logical address, or def if it has none.
+func (s SceneStep) address(def int) cec.LogicalAddress {
+	if s.Address == nil {
+		return cec.LogicalAddress(def)
+	}
+	return cec.LogicalAddress(*s.Address)
+}
+
+// validate checks that the step has the fields its action needs.
+func (s SceneStep) validate() error {
+	if s.Address != nil && (*s.Address < 0 || *s.Address > 15) {
+		return fmt.Errorf("address must be a logical address (0-15)")
+	}
+	if s.DelayMS < 0 || s.DelayMS > maxSceneDelayMS {
+		return fmt.Errorf("delay_ms must be in range 0-%d", maxSceneDelayMS)
+	}
+	switch s.Action {
+	case "power_on", "standby":
+	case "source":
+		if s.Address == nil {
+			return fmt.Errorf("source needs an address")
+		}
+	case "hdmi":
+		if s.Port < 1 || s.Port > 15 {
+			return fmt.Errorf("hdmi needs a port (1-15)")
+		}
+	case "key":
+		if _, ok := cec.KeycodeByName(s.Key); !ok {
+			return fmt.Errorf("unknown key name %q", s.Key)
+		}
+	case "volume":
+		if s.Level == nil || *s.Level < 0 || *s.Level > 100 {
+			return fmt.Errorf("volume needs a level (0-100)")
+		}
+	case "osd":
+		if s.Message == "" {
+			return fmt.Errorf("osd needs a message")
+		}
+		if err := cec.ValidateOSDString(s.Message); err != nil {
+			return err
+		}
+	case "raw":
+		if s.Command == nil {
+			return fmt.Errorf("raw needs a command")
+		}
+		if _, err := buildRawCommand(*s.Command); err != nil {
+			return err
+		}
+	case "delay":
+		if s.DelayMS == 0 {
+			return fmt.Errorf("delay needs delay_ms")
+		}
+	default:
+		return fmt.Errorf("unknown action %q (use power_on, standby, source, hdmi, key, volume, osd, raw or delay)", s.Action)
+	}
+	return nil
+}
+
+// wakes reports whether the step can turn on the TV or take over its
+// input, and so is blocked during quiet hours.
+func (s SceneStep) wakes() bool {
+	switch s.Action {
+	case "power_on", "source", "hdmi":
+		return true
+	case "key":
+		k, _ := cec.KeycodeByName(s.Key)
+		return isWakeKey(k)
+	case "raw":
+		return s.Command.Opcode != nil && isWakeOpcode(cec.Opcode(*s.Command.Opcode))
+	}
+	return false
+}
+
+// validateScene checks a scene's name and steps.
+func validateScene(name string, steps []SceneStep) error {
+	if name == "" || len(name) > maxSceneNameLength {
+		return fmt.Errorf("scene name must be 1-%d bytes", maxSceneNameLength)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7F || r == '/' {
+			return fmt.Errorf("scene name must not contain control characters or '/'")
+		}
+	}
+	if len(steps) == 0 || len(steps) > maxSceneSteps {
+		return fmt.Errorf("scene must have 1-%d steps", maxSceneSteps)
+	}
+	for i, step := range steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validateScenes drops invalid scenes loaded from config.json.
+func validateScenes(scenes map[string][]SceneStep) {
+	for name, steps := range scenes {
+		if err := validateScene(name, steps); err != nil {
+			log.Printf("Ignoring scene %q: %v", name, err)
+			delete(scenes, name)
+		}
+	}
+}
+
+// sceneWakes reports whether any step of a scene is blocked by quiet hours.
+func sceneWakes(steps []SceneStep) bool {
+	for _, step := range steps {
+		if step.wakes() {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupScene returns a copy of a saved scene.
+func lookupScene(name string) ([]SceneStep, bool) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	steps, ok := currentConfig.Scenes[name]
+	return append([]SceneStep(nil), steps...), ok
+}
+
+// runSceneStep performs one step. Each CEC step is its own job on the CEC
+// worker, so other requests can interleave between steps.
+func runSceneStep(step SceneStep) error {
+	switch step.Action {
+	case "power_on":
+		return withCEC(func() error { return cecConn.PowerOn(step.address(0)) })
+	case "standby":
+		return withCEC(func() error { return cecConn.Standby(step.address(0)) })
+	case "source":
+		addr := step.address(0)
+		opts := defaultSwitchOptions()
+		return withSwitchTimeout(opts, func() error {
+			if !cecConn.IsActiveDevice(addr) {
+				return &cec.CECError{Code: cec.ErrDeviceUnreachable, Message: fmt.Sprintf("device %d is not on the CEC bus", addr)}
+			}
+			return cecConn.SwitchToDeviceWithOptions(addr, opts)
+		})
+	case "hdmi":
+		opts := defaultSwitchOptions()
+		return withSwitchTimeout(opts, func() error { return cecConn.SwitchToHDMIPortWithOptions(uint8(step.Port), opts) })
+	case "key":
+		k, _ := cec.KeycodeByName(step.Key)
+		return withCEC(func() error { return cecConn.SendButton(step.address(0), k) })
+	case "volume":
+		return withCEC(func() error { return cecConn.SetAudioVolume(uint8(*step.Level)) })
+	case "osd":
+		return withCEC(func() error {
+			return cecConn.SetOSDString(step.address(0), osdDurations["default"], step.Message)
+		})
+	case "raw":
+		cmd, err := buildRawCommand(*step.Command)
+		if err != nil {
+			return err
+		}
+		return withCEC(func() error { return cecConn.Transmit(cmd) })
+	case "delay":
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", step.Action)
+}
+
+// runScene performs the steps of a scene in order, stopping at the first
+// failure. It returns a result for every step and the error of the failed
+// step, if any.
+func runScene(steps []SceneStep) ([]SceneStepResult, error) {
+	results := make([]SceneStepResult, len(steps))
+	var failed error
+	for i, step := range steps {
+		results[i] = SceneStepResult{Index: i, Action: step.Action, Status: "skipped"}
+		if failed != nil {
+			continue
+		}
+		if err := runSceneStep(step); err != nil {
+			failed = err
+			results[i].Status = "error"
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Status = "ok"
+		if step.DelayMS > 0 {
+			time.Sleep(time.Duration(step.DelayMS) * time.Millisecond)
+		}
+	}
+	return results, failed
+}
+
+// ── Scene API ──────────────────────────────────────────────────────────
+
+func getScenesHandler(w http.ResponseWriter, r *http.Request) {
+	configMu.RLock()
+	scenes := make(map[string][]SceneStep, len(currentConfig.Scenes))
+	for name, steps := range currentConfig.Scenes {
+		scenes[name] = steps
+	}
+	configMu.RUnlock()
+
+	names := make([]string, 0, len(scenes))
+	for name := range scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	respondSuccess(w, fmt.Sprintf("%d scenes", len(scenes)), map[string]interface{}{
+		"names":  names,
+		"scenes": scenes,
+	})
+}
+
+// postScenesHandler creates or replaces a scene.
+func postScenesHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string      `json:"name"`
+		Steps []SceneStep `json:"steps"`
+	}
+	if !decodeJSONBody(w, r, &req) || !requireField(w, req.Name != "", "name") {
+		return
+	}
+	if err := validateScene(req.Name, req.Steps); err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid scene: %v", err))
+		return
+	}
+
+	configMu.Lock()
+	_, existed := currentConfig.Scenes[req.Name]
+	if currentConfig.Scenes == nil {
+		currentConfig.Scenes = make(map[string][]SceneStep)
+	}
+	currentConfig.Scenes[req.Name] = req.Steps
+	cfg := currentConfig
+	configMu.Unlock()
+
+	if err := saveConfig(configFilePath, cfg); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
+		return
+	}
+
+	message := fmt.Sprintf("Scene %q saved", req.Name)
+	if existed {
+		message = fmt.Sprintf("Scene %q replaced", req.Name)
+	}
+	respondSuccess(w, message, map[string]interface{}{
+		"name":  req.Name,
+		"steps": req.Steps,
+	})
+}
+
+func deleteSceneHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	configMu.Lock()
+	_, ok := currentConfig.Scenes[name]
+	delete(currentConfig.Scenes, name)
+	cfg := currentConfig
+	configMu.Unlock()
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Scene %q not found", name))
+		return
+	}
+
+	if err := saveConfig(configFilePath, cfg); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
+		return
+	}
+	respondSuccess(w, fmt.Sprintf("Scene %q deleted", name), nil)
+}
+
+// runSceneHandler runs a saved scene and reports per-step results. If a
+// step fails the remaining steps are skipped and the response carries the
+// status that step's error maps to.
+func runSceneHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireCEC(w) { return }
+	name := mux.Vars(r)["name"]
+	steps, ok := lookupScene(name)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Scene %q not found", name))
+		return
+	}
+	if sceneWakes(steps) && rejectDuringQuietHours(w, r) {
+		return
+	}
+
+	start := time.Now()
+	results, err := runScene(steps)
+	data := map[string]interface{}{
+		"name":        name,
+		"steps":       results,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		respondJSON(w, cecErrorStatus(err), Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Scene %q failed: %v", name, err),
+			Data:    data,
+		})
+		return
+	}
+	respondSuccess(w, fmt.Sprintf("Scene %q ran", name), data)
+}
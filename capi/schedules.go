@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/robfig/cron/v3"
+)
+
+// maxSchedules bounds the number of saved schedules.
+const maxSchedules = 64
+
+// Schedule runs a scene or a single scene step at times given by a cron
+// expression, e.g. "0 0 * * *" for every midnight. Times are in the
+// server's local time zone.
+type Schedule struct {
+	Name   string     `json:"name"`
+	Cron   string     `json:"cron"`
+	Scene  string     `json:"scene,omitempty"`
+	Action *SceneStep `json:"action,omitempty"`
+}
+
+// validate checks the cron expression and that exactly one of Scene and
+// Action is set. Whether the scene exists is checked when saving through
+// the API and again when the schedule fires.
+func (s Schedule) validate() error {
+	if s.Name == "" || len(s.Name) > maxSceneNameLength {
+		return fmt.Errorf("name must be 1-%d bytes", maxSceneNameLength)
+	}
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression %q: %v", s.Cron, err)
+	}
+	switch {
+	case s.Scene == "" && s.Action == nil:
+		return fmt.Errorf("needs a scene or an action")
+	case s.Scene != "" && s.Action != nil:
+		return fmt.Errorf("takes a scene or an action, not both")
+	case s.Action != nil:
+		if err := s.Action.validate(); err != nil {
+			return fmt.Errorf("action: %v", err)
+		}
+	}
+	return nil
+}
+
+// steps returns what the schedule runs.
+func (s Schedule) steps() ([]SceneStep, error) {
+	if s.Action != nil {
+		return []SceneStep{*s.Action}, nil
+	}
+	steps, ok := lookupScene(s.Scene)
+	if !ok {
+		return nil, fmt.Errorf("scene %q not found", s.Scene)
+	}
+	return steps, nil
+}
+
+// Scheduler fires schedules on a cron clock.
+type Scheduler struct {
+	mu      sync.Mutex
+	cron    *cron.Cron
+	entries map[string]cron.EntryID
+}
+
+// NewScheduler creates a stopped scheduler with no schedules.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		cron:    cron.New(),
+		entries: make(map[string]cron.EntryID),
+	}
+}
+
+var scheduler = NewScheduler()
+
+// Start begins firing schedules.
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops firing schedules. Runs in progress are not interrupted.
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// Set replaces all schedules. Invalid schedules are skipped with a log
+// message.
+func (s *Scheduler) Set(schedules []Schedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, id := range s.entries {
+		s.cron.Remove(id)
+		delete(s.entries, name)
+	}
+	for _, sc := range schedules {
+		sc := sc
+		id, err := s.cron.AddFunc(sc.Cron, func() { fireSchedule(sc) })
+		if err != nil {
+			log.Printf("Ignoring schedule %q: %v", sc.Name, err)
+			continue
+		}
+		s.entries[sc.Name] = id
+	}
+}
+
+// Next returns when the named schedule fires next, or the zero time if it
+// isn't scheduled.
+func (s *Scheduler) Next(name string) time.Time {
+	s.mu.Lock()
+	id, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok {
+		return time.Time{}
+	}
+	return s.cron.Entry(id).Next
+}
+
+// fireSchedule runs a schedule if the adapter is ready and quiet hours
+// don't block it.
+func fireSchedule(sc Schedule) {
+	if !cecIsReady() {
+		log.Printf("Schedule %q skipped: CEC adapter not available", sc.Name)
+		return
+	}
+	steps, err := sc.steps()
+	if err != nil {
+		log.Printf("Schedule %q skipped: %v", sc.Name, err)
+		return
+	}
+	if sceneWakes(steps) {
+		if q, active := quietHoursActive(); active {
+			log.Printf("Schedule %q skipped: quiet hours in effect (%s-%s)", sc.Name, q.Start, q.End)
+			return
+		}
+	}
+	log.Printf("Schedule %q fired", sc.Name)
+	if _, err := runScene(steps); err != nil {
+		log.Printf("Schedule %q failed: %v", sc.Name, err)
+	}
+}
+
+// validateSchedules drops invalid and duplicate schedules loaded from
+// config.json.
+func validateSchedules(schedules []Schedule) []Schedule {
+	seen := make(map[string]bool, len(schedules))
+	valid := schedules[:0]
+	for _, sc := range schedules {
+		if err := sc.validate(); err != nil {
+			log.Printf("Ignoring schedule %q: %v", sc.Name, err)
+			continue
+		}
+		if seen[sc.Name] {
+			log.Printf("Ignoring duplicate schedule %q", sc.Name)
+			continue
+		}
+		seen[sc.Name] = true
+		valid = append(valid, sc)
+	}
+	return valid
+}
+
+// ── Schedule API ───────────────────────────────────────────────────────
+
+// scheduleToMap reports a schedule with its next firing time.
+func scheduleToMap(sc Schedule) map[string]interface{} {
+	m := map[string]interface{}{
+		"name": sc.Name,
+		"cron": sc.Cron,
+	}
+	if sc.Scene != "" {
+		m["scene"] = sc.Scene
+	}
+	if sc.Action != nil {
+		m["action"] = sc.Action
+	}
+	if next := scheduler.Next(sc.Name); !next.IsZero() {
+		m["next"] = next.Format(time.RFC3339)
+	}
+	return m
+}
+
+func getSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	configMu.RLock()
+	schedules := append([]Schedule(nil), currentConfig.Schedules...)
+	configMu.RUnlock()
+
+	result := make([]map[string]interface{}, 0, len(schedules))
+	for _, sc := range schedules {
+		result = append(result, scheduleToMap(sc))
+	}
+	respondSuccess(w, fmt.Sprintf("%d schedules", len(result)), result)
+}
+
+// postSchedulesHandler creates a schedule, or replaces the one with the
+// same name.
+func postSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	var sc Schedule
+	if !decodeJSONBody(w, r, &sc) ||
+		!requireField(w, sc.Name != "", "name") ||
+		!requireField(w, sc.Cron != "", "cron") {
+		return
+	}
+	if err := sc.validate(); err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid schedule: %v", err))
+		return
+	}
+	if sc.Scene != "" {
+		if _, ok := lookupScene(sc.Scene); !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid schedule: scene %q not found", sc.Scene))
+			return
+		}
+	}
+
+	configMu.Lock()
+	replaced := false
+	schedules := append([]Schedule(nil), currentConfig.Schedules...)
+	for i := range schedules {
+		if schedules[i].Name == sc.Name {
+			schedules[i] = sc
+			replaced = true
+		}
+	}
+	if !replaced {
+		if len(schedules) >= maxSchedules {
+			configMu.Unlock()
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d schedules can be saved", maxSchedules))
+			return
+		}
+		schedules = append(schedules, sc)
+	}
+	currentConfig.Schedules = schedules
+	cfg := currentConfig
+	configMu.Unlock()
+
+	scheduler.Set(schedules)
+	if err := saveConfig(configFilePath, cfg); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
+		return
+	}
+
+	message := fmt.Sprintf("Schedule %q saved", sc.Name)
+	if replaced {
+		message = fmt.Sprintf("Schedule %q replaced", sc.Name)
+	}
+	respondSuccess(w, message, scheduleToMap(sc))
+}
+
+func deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	configMu.Lock()
+	schedules := make([]Schedule, 0, len(currentConfig.Schedules))
+	for _, sc := range currentConfig.Schedules {
+		if sc.Name != name {
+			schedules = append(schedules, sc)
+		}
+	}
+	found := len(schedules) != len(currentConfig.Schedules)
+	currentConfig.Schedules = schedules
+	cfg := currentConfig
+	configMu.Unlock()
+	if !found {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Schedule %q not found", name))
+		return
+	}
+
+	scheduler.Set(schedules)
+	if err := saveConfig(configFilePath, cfg); err != nil {
+		log.Printf("Failed to save config: %v", err)
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save config: %v", err))
+		return
+	}
+	respondSuccess(w, fmt.Sprintf("Schedule %q deleted", name), nil)
+}
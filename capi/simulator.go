@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"capi/cec"
+)
+
+// simulatorEventInterval is how often the simulator emits a synthetic bus
+// event (a power report or a remote key press).
+const simulatorEventInterval = 15 * time.Second
+
+// simulatorOwnAddress is the logical address the simulated adapter claims.
+const simulatorOwnAddress = cec.LogicalAddressRecordingDevice1
+
+// Simulator is a CECController backed by an in-memory bus with a TV, an
+// audio system and two playback devices, for running capi without an
+// adapter (-simulate). Commands change the simulated state and succeed;
+// state changes and periodic synthetic events are reported through the
+// same callback handler libcec would use, so SSE, WebSocket and MQTT
+// clients see them as they would on real hardware.
+type Simulator struct {
+	mu           sync.Mutex
+	config       cec.Configuration
+	devices      map[cec.LogicalAddress]*cec.Device
+	activeSource cec.LogicalAddress
+	volume       uint8
+	muted        bool
+
+	callbacks cec.CallbackHandler
+	events    chan func()
+}
+
+// NewSimulator creates a simulated bus that reports events to callbacks.
+func NewSimulator(deviceName string, callbacks cec.CallbackHandler) *Simulator {
+	s := &Simulator{
+		config: cec.Configuration{
+			DeviceName:      cec.TruncateDeviceName(deviceName),
+			DeviceType:      cec.DeviceTypeRecordingDevice,
+			PhysicalAddress: 0x4000,
+			BaseDevice:      cec.LogicalAddressTV,
+			HDMIPort:        4,
+		},
+		devices:      make(map[cec.LogicalAddress]*cec.Device),
+		activeSource: cec.LogicalAddressPlaybackDevice1,
+		volume:       25,
+		callbacks:    callbacks,
+		events:       make(chan func(), 64),
+	}
+	add := func(addr cec.LogicalAddress, phys uint16, vendor uint64, name string, power cec.PowerStatus) {
+		s.devices[addr] = &cec.Device{
+			LogicalAddress:  addr,
+			PhysicalAddress: phys,
+			VendorID:        vendor,
+			CECVersion:      cec.CECVersion1_4,
+			PowerStatus:     power,
+			OSDName:         name,
+			MenuLanguage:    "eng",
+			IsActive:        true,
+		}
+	}
+	add(cec.LogicalAddressTV, 0x0000, 0x0000F0, "TV", cec.PowerStatusOn)
+	add(cec.LogicalAddressAudioSystem, 0x1000, 0x0005CD, "AV Receiver", cec.PowerStatusOn)
+	add(cec.LogicalAddressPlaybackDevice1, 0x2000, 0x001950, "Chromecast", cec.PowerStatusOn)
+	add(cec.LogicalAddressPlaybackDevice2, 0x3000, 0x08001F, "PlayStation 5", cec.PowerStatusStandby)
+	add(simulatorOwnAddress, s.config.PhysicalAddress, 0, s.config.DeviceName, cec.PowerStatusOn)
+	s.devices[s.activeSource].IsActiveSource = true
+
+	go s.dispatch()
+	return s
+}
+
+// initSimulator installs a Simulator as the CEC connection, then starts
+// its synthetic events and the MQTT bridge if configured.
+func initSimulator(deviceName string) {
+	sim := NewSimulator(deviceName, logHandler)
+	if lang := currentConfig.CEC.MenuLanguage; lang != "" {
+		applyMenuLanguage(sim, lang)
+	}
+	applyAdapterAddress(sim, currentConfig.CEC)
+
+	publishCECConnection(sim, "simulator")
+	setAdapterState("ready", "simulator")
+	log.Println("Simulated CEC bus is ready (no adapter in use)")
+
+	go sim.Run(simulatorEventInterval)
+
+	if currentConfig.MQTT.Broker != "" {
+		startMQTT(currentConfig.MQTT)
+	}
+}
+
+// dispatch runs queued callbacks in order. Callbacks run outside the
+// caller's goroutine, as libcec's do, so a handler that calls back into
+// the API from the CEC worker doesn't deadlock.
+func (s *Simulator) dispatch() {
+	for fn := range s.events {
+		fn()
+	}
+}
+
+func (s *Simulator) emit(fn func()) {
+	select {
+	case s.events <- fn:
+	default:
+		// nobody is keeping up; drop like a busy bus would
+	}
+}
+
+// emitCommand reports a frame as if it had been received from the bus.
+func (s *Simulator) emitCommand(from, to cec.LogicalAddress, opcode cec.Opcode, params ...uint8) {
+	cmd := &cec.Command{
+		Initiator:   from,
+		Destination: to,
+		Opcode:      opcode,
+		OpcodeSet:   true,
+		Parameters:  params,
+		Ack:         true,
+		Eom:         true,
+	}
+	s.emit(func() { s.callbacks.OnCommand(cmd) })
+}
+
+// Run emits a synthetic event every interval: a power report from a random
+// device or a remote key press.
+func (s *Simulator) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if rand.Intn(2) == 0 {
+			key := []cec.Keycode{cec.KeycodeUp, cec.KeycodeDown, cec.KeycodeSelect, cec.KeycodeExit}[rand.Intn(4)]
+			s.emit(func() { s.callbacks.OnKeyPress(key, 0) })
+			continue
+		}
+		s.mu.Lock()
+		addrs := s.sortedAddresses()
+		addr := addrs[rand.Intn(len(addrs))]
+		power := s.devices[addr].PowerStatus
+		s.mu.Unlock()
+		if addr != simulatorOwnAddress {
+			s.emitCommand(addr, cec.LogicalAddressBroadcast, cec.OpcodeReportPowerStatus, uint8(power))
+		}
+	}
+}
+
+// sortedAddresses returns the simulated addresses in order. s.mu must be held.
+func (s *Simulator) sortedAddresses() []cec.LogicalAddress {
+	addrs := make([]cec.LogicalAddress, 0, len(s.devices))
+	for addr := range s.devices {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// device returns the simulated device at addr, or an ErrDeviceUnreachable
+// error. s.mu must be held.
+func (s *Simulator) device(addr cec.LogicalAddress) (*cec.Device, error) {
+	d, ok := s.devices[addr]
+	if !ok {
+		return nil, &cec.CECError{Code: cec.ErrDeviceUnreachable, Message: "device " + addr.String() + " is not on the simulated bus"}
+	}
+	return d, nil
+}
+
+func (s *Simulator) Close() error { return nil }
+
+func (s *Simulator) GetLibInfo() string { return "capi simulator (no libcec adapter)" }
+
+func (s *Simulator) GetAdapterInfo() (*cec.AdapterInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &cec.AdapterInfo{
+		LogicalAddresses:          []cec.LogicalAddress{simulatorOwnAddress},
+		BaseDevice:                s.config.BaseDevice,
+		HDMIPort:                  s.config.HDMIPort,
+		ConfiguredPhysicalAddress: s.config.PhysicalAddress,
+		ExpectedPhysicalAddress:   s.config.PhysicalAddress,
+		DetectedPhysicalAddress:   s.config.PhysicalAddress,
+		MenuLanguage:              s.config.MenuLanguage,
+		Mismatches:                make([]string, 0),
+	}, nil
+}
+
+func (s *Simulator) GetCurrentConfiguration() (*cec.Configuration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config := s.config
+	return &config, nil
+}
+
+func (s *Simulator) SetConfiguration(config *cec.Configuration) error {
+	if err := cec.ValidateDeviceName(config.DeviceName); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.config = *config
+	s.config.DeviceName = cec.TruncateDeviceName(config.DeviceName)
+	s.devices[simulatorOwnAddress].OSDName = s.config.DeviceName
+	updated := s.config
+	s.mu.Unlock()
+	s.emit(func() { s.callbacks.OnConfigurationChanged(&updated) })
+	return nil
+}
+
+func (s *Simulator) GetLogicalAddresses() []cec.LogicalAddress {
+	return []cec.LogicalAddress{simulatorOwnAddress}
+}
+
+func (s *Simulator) GetActiveDevices() []cec.LogicalAddress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedAddresses()
+}
+
+func (s *Simulator) IsActiveDevice(address cec.LogicalAddress) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.devices[address]
+	return ok
+}
+
+func (s *Simulator) RescanDevicesWithSettle(settle time.Duration) error {
+	time.Sleep(settle)
+	return nil
+}
+
+func (s *Simulator) PingDevice(address cec.LogicalAddress) (bool, error) {
+	return s.IsActiveDevice(address), nil
+}
+
+func (s *Simulator) GetDeviceInfoContext(ctx context.Context, address cec.LogicalAddress) (*cec.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &cec.CECError{Code: cec.ErrTimeout, Message: "device info for " + address.String() + " not retrieved", Err: err}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[address]
+	if !ok {
+		// libcec reports absent devices with default fields rather than
+		// failing, and so does the simulator.
+		return &cec.Device{LogicalAddress: address, PhysicalAddress: 0xFFFF, PowerStatus: cec.PowerStatusUnknown}, nil
+	}
+	device := *d
+	return &device, nil
+}
+
+func (s *Simulator) GetDeviceInfoCachedContext(ctx context.Context, address cec.LogicalAddress, ttl time.Duration) (*cec.Device, error) {
+	return s.GetDeviceInfoContext(ctx, address)
+}
+
+func (s *Simulator) GetDeviceOSDName(address cec.LogicalAddress) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.device(address)
+	if err != nil {
+		return "", err
+	}
+	return d.OSDName, nil
+}
+
+func (s *Simulator) GetDevicePhysicalAddress(address cec.LogicalAddress) (uint16, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.device(address)
+	if err != nil {
+		return 0xFFFF, err
+	}
+	return d.PhysicalAddress, nil
+}
+
+func (s *Simulator) GetDeviceVendorId(address cec.LogicalAddress) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.device(address)
+	if err != nil {
+		return 0, err
+	}
+	return d.VendorID, nil
+}
+
+func (s *Simulator) GetDevicePowerStatus(address cec.LogicalAddress) (cec.PowerStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, err := s.device(address)
+	if err != nil {
+		return cec.PowerStatusUnknown, err
+	}
+	return d.PowerStatus, nil
+}
+
+func (s *Simulator) GetBusTopology() *cec.BusTopology {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topo := &cec.BusTopology{OwnAddress: simulatorOwnAddress, OwnPort: uint8(s.config.PhysicalAddress >> 12)}
+	for _, addr := range s.sortedAddresses() {
+		port := uint8(s.devices[addr].PhysicalAddress >> 12)
+		if addr == cec.LogicalAddressTV || port == 0 {
+			continue
+		}
+		topo.ActivePorts = append(topo.ActivePorts, cec.PortInfo{Port: port, Devices: []cec.LogicalAddress{addr}})
+		if port > topo.KnownPortCount {
+			topo.KnownPortCount = port
+		}
+	}
+	sort.Slice(topo.ActivePorts, func(i, j int) bool { return topo.ActivePorts[i].Port < topo.ActivePorts[j].Port })
+	return topo
+}
+
+func (s *Simulator) RefreshBusTopology(timeout time.Duration) (*cec.BusTopology, *cec.TopologyRefresh) {
+	refresh := &cec.TopologyRefresh{}
+	for _, addr := range s.GetActiveDevices() {
+		if addr != simulatorOwnAddress {
+			refresh.Queried = append(refresh.Queried, addr)
+		}
+	}
+	return s.GetBusTopology(), refresh
+}
+
+// WaitForDeviceReadyContext polls the simulated device until it reaches
+// targetState. Simulated power changes are instant, so this only waits if
+// something else changes the state.
+func (s *Simulator) WaitForDeviceReadyContext(ctx context.Context, address cec.LogicalAddress, targetState cec.PowerStatus, poll time.Duration) error {
+	if poll <= 0 {
+		poll = cec.DefaultPowerPollInterval
+	}
+	for {
+		status, err := s.GetDevicePowerStatus(address)
+		if err == nil && status == targetState {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &cec.CECError{Code: cec.ErrTimeout, Message: "timeout waiting for device " + address.String() + " to reach state " + targetState.String(), Err: ctx.Err()}
+		case <-time.After(poll):
+		}
+	}
+}
+
+// setPower changes a device's power status and reports it on the bus.
+func (s *Simulator) setPower(address cec.LogicalAddress, status cec.PowerStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if address == cec.LogicalAddressBroadcast {
+		for _, addr := range s.sortedAddresses() {
+			if addr != simulatorOwnAddress {
+				s.devices[addr].PowerStatus = status
+				s.emitCommand(addr, cec.LogicalAddressBroadcast, cec.OpcodeReportPowerStatus, uint8(status))
+			}
+		}
+		return nil
+	}
+	d, err := s.device(address)
+	if err != nil {
+		return err
+	}
+	d.PowerStatus = status
+	s.emitCommand(address, cec.LogicalAddressBroadcast, cec.OpcodeReportPowerStatus, uint8(status))
+	return nil
+}
+
+func (s *Simulator) PowerOn(address cec.LogicalAddress) error {
+	return s.setPower(address, cec.PowerStatusOn)
+}
+
+func (s *Simulator) Standby(address cec.LogicalAddress) error {
+	return s.setPower(address, cec.PowerStatusStandby)
+}
+
+func (s *Simulator) GetActiveSource() (cec.LogicalAddress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeSource, nil
+}
+
+// activate makes address the active source and reports the change. s.mu
+// must be held.
+func (s *Simulator) activate(address cec.LogicalAddress) {
+	if previous, ok := s.devices[s.activeSource]; ok {
+		previous.IsActiveSource = false
+		prev := s.activeSource
+		s.emit(func() { s.callbacks.OnSourceActivated(prev, false) })
+	}
+	d := s.devices[address]
+	d.IsActiveSource = true
+	d.PowerStatus = cec.PowerStatusOn
+	s.activeSource = address
+	s.emitCommand(address, cec.LogicalAddressBroadcast, cec.OpcodeActiveSource, uint8(d.PhysicalAddress>>8), uint8(d.PhysicalAddress))
+	s.emit(func() { s.callbacks.OnSourceActivated(address, true) })
+}
+
+// SetInactiveView gives up the active source if the simulated adapter
+// holds it, telling the TV with Inactive Source.
+func (s *Simulator) SetInactiveView() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	own := s.devices[simulatorOwnAddress]
+	if s.activeSource == simulatorOwnAddress {
+		own.IsActiveSource = false
+		s.activeSource = cec.LogicalAddressUnknown
+		s.emit(func() { s.callbacks.OnSourceActivated(simulatorOwnAddress, false) })
+	}
+	s.emitCommand(simulatorOwnAddress, cec.LogicalAddressTV, cec.OpcodeInactiveSource, uint8(own.PhysicalAddress>>8), uint8(own.PhysicalAddress))
+	return nil
+}
+
+func (s *Simulator) SwitchToDeviceWithOptions(address cec.LogicalAddress, opts cec.SwitchOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.device(address); err != nil {
+		return err
+	}
+	if opts.Wake {
+		s.devices[cec.LogicalAddressTV].PowerStatus = cec.PowerStatusOn
+	}
+	s.activate(address)
+	return nil
+}
+
+func (s *Simulator) SwitchToHDMIPortWithOptions(port uint8, opts cec.SwitchOptions) error {
+	if err := cec.ValidateHDMIPort(port); err != nil {
+		return err
+	}
+	return s.SetStreamPath(uint16(port) << 12)
+}
+
+func (s *Simulator) SetStreamPath(physicalAddress uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emitCommand(simulatorOwnAddress, cec.LogicalAddressBroadcast, cec.OpcodeSetStreamPath, uint8(physicalAddress>>8), uint8(physicalAddress))
+	for _, addr := range s.sortedAddresses() {
+		if addr != cec.LogicalAddressTV && s.devices[addr].PhysicalAddress == physicalAddress {
+			s.activate(addr)
+			return nil
+		}
+	}
+	return nil
+}
+
+// reportAudioStatus reports the simulated volume and mute state as the
+// audio system would. s.mu must be held.
+func (s *Simulator) reportAudioStatus() {
+	status := s.volume
+	if s.muted {
+		status |= 0x80
+	}
+	s.emitCommand(cec.LogicalAddressAudioSystem, cec.LogicalAddressTV, cec.OpcodeReportAudioStatus, status)
+}
+
+func (s *Simulator) stepVolume(delta int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := int(s.volume) + delta
+	if v < 0 {
+		v = 0
+	}
+	if v > 100 {
+		v = 100
+	}
+	s.volume = uint8(v)
+	s.reportAudioStatus()
+	return nil
+}
+
+func (s *Simulator) VolumeUp(sendRelease bool) error { return s.stepVolume(1) }
+
+func (s *Simulator) VolumeDown(sendRelease bool) error { return s.stepVolume(-1) }
+
+func (s *Simulator) setMuted(muted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.muted = muted
+	s.reportAudioStatus()
+	return nil
+}
+
+func (s *Simulator) AudioMute() error { return s.setMuted(true) }
+
+func (s *Simulator) AudioUnmute() error { return s.setMuted(false) }
+
+func (s *Simulator) ToggleMuteWithStatus() (*bool, error) {
+	s.mu.Lock()
+	muted := !s.muted
+	s.mu.Unlock()
+	if err := s.setMuted(muted); err != nil {
+		return nil, err
+	}
+	return &muted, nil
+}
+
+func (s *Simulator) SendVolumeKey(address cec.LogicalAddress, key cec.Keycode) error {
+	switch key {
+	case cec.KeycodeVolumeUp:
+		return s.stepVolume(1)
+	case cec.KeycodeVolumeDown:
+		return s.stepVolume(-1)
+	case cec.KeycodeMute:
+		_, err := s.ToggleMuteWithStatus()
+		return err
+	}
+	return nil
+}
+
+func (s *Simulator) SetAudioVolume(level uint8) error {
+	if level > 100 {
+		return &cec.CECError{Code: cec.ErrInvalidArgument, Message: "volume level must be 0-100"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volume = level
+	s.reportAudioStatus()
+	return nil
+}
+
+func (s *Simulator) GetAudioStatus() (uint8, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.volume, s.muted, nil
+}
+
+func (s *Simulator) SetAudioRate(rate cec.AudioRate) error { return nil }
+
+func (s *Simulator) SendButton(address cec.LogicalAddress, key cec.Keycode) error {
+	return s.SendButtonTimed(address, key, cec.DefaultButtonHold)
+}
+
+func (s *Simulator) SendButtonTimed(address cec.LogicalAddress, key cec.Keycode, hold time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.device(address)
+	return err
+}
+
+func (s *Simulator) SetOSDString(address cec.LogicalAddress, duration cec.DisplayControl, message string) error {
+	return cec.ValidateOSDString(message)
+}
+
+func (s *Simulator) ClearOSDString(address cec.LogicalAddress) error { return nil }
+
+func (s *Simulator) Transmit(command *cec.Command) error { return nil }
+
+func (s *Simulator) SendVendorCommandWithID(destination cec.LogicalAddress, vendorID uint32, frames [][]uint8) error {
+	_, err := cec.VendorCommandParameters(vendorID, frames)
+	return err
+}
@@ -71,14 +71,15 @@ func goCommandCallbackBridge(handle unsafe.Pointer, commandPtr unsafe.Pointer) {
 		}
 
 		cmd := &Command{
-			Initiator:    LogicalAddress(cCmd.initiator),
-			Destination:  LogicalAddress(cCmd.destination),
-			Ack:          cCmd.ack != 0,
-			Eom:          cCmd.eom != 0,
-			Opcode:       Opcode(cCmd.opcode),
-			OpcodeSet:    cCmd.opcode_set != 0,
-			Parameters:   params,
-			TransmitTime: int64(cCmd.transmit_timeout),
+			Initiator:       LogicalAddress(cCmd.initiator),
+			Destination:     LogicalAddress(cCmd.destination),
+			Ack:             cCmd.ack != 0,
+			Eom:             cCmd.eom != 0,
+			Opcode:          Opcode(cCmd.opcode),
+			OpcodeSet:       cCmd.opcode_set != 0,
+			Parameters:      params,
+			TransmitTimeout: int64(cCmd.transmit_timeout),
+			TransmitTime:    int64(cCmd.transmit_timeout),
 		}
 
 		callbacks.OnCommand(cmd)
@@ -95,6 +96,8 @@ func goConfigurationChangedCallbackBridge(handle unsafe.Pointer, configPtr unsaf
 		return
 	}
 
+	conn.devices.InvalidateAll()
+
 	conn.mu.Lock()
 	callbacks := conn.callbacks
 	conn.mu.Unlock()
@@ -171,6 +174,8 @@ func goSourceActivatedCallbackBridge(handle unsafe.Pointer, address C.int, activ
 		return
 	}
 
+	conn.devices.invalidateSource(LogicalAddress(address))
+
 	conn.mu.Lock()
 	callbacks := conn.callbacks
 	conn.mu.Unlock()
@@ -0,0 +1,90 @@
+package cec
+
+import "fmt"
+
+// DecodeCommand decodes the parameters of common commands into named
+// fields, using the same names and formats as the REST API's device
+// objects. It returns nil for opcodes it doesn't decode and for frames too
+// short to decode.
+func DecodeCommand(cmd *Command) map[string]interface{} {
+	if !cmd.OpcodeSet {
+		return nil
+	}
+	p := cmd.Parameters
+	switch cmd.Opcode {
+	case OpcodeActiveSource, OpcodeInactiveSource, OpcodeSetStreamPath, OpcodeRoutingInformation:
+		if len(p) < 2 {
+			return nil
+		}
+		return map[string]interface{}{
+			"physical_address": PhysicalAddressToString(uint16(p[0])<<8 | uint16(p[1])),
+		}
+	case OpcodeReportPhysicalAddress:
+		if len(p) < 3 {
+			return nil
+		}
+		return map[string]interface{}{
+			"physical_address": PhysicalAddressToString(uint16(p[0])<<8 | uint16(p[1])),
+			"device_type":      DeviceType(p[2]).String(),
+		}
+	case OpcodeRoutingChange:
+		rc, ok := ParseRoutingChange(cmd)
+		if !ok {
+			return nil
+		}
+		return map[string]interface{}{
+			"from": PhysicalAddressToString(rc.From),
+			"to":   PhysicalAddressToString(rc.To),
+		}
+	case OpcodeSetOSDName:
+		if len(p) < 1 {
+			return nil
+		}
+		return map[string]interface{}{"osd_name": string(p)}
+	case OpcodeDeviceVendorID:
+		if len(p) < 3 {
+			return nil
+		}
+		id := uint64(p[0])<<16 | uint64(p[1])<<8 | uint64(p[2])
+		return map[string]interface{}{
+			"vendor_id":   fmt.Sprintf("0x%06X", id),
+			"vendor_name": GetVendorName(id),
+		}
+	case OpcodeCECVersion:
+		if len(p) < 1 {
+			return nil
+		}
+		return map[string]interface{}{"cec_version": CECVersion(p[0]).String()}
+	case OpcodeReportPowerStatus:
+		if len(p) < 1 {
+			return nil
+		}
+		return map[string]interface{}{"power_status": PowerStatus(p[0]).String()}
+	case OpcodeSetMenuLanguage:
+		if len(p) < 3 {
+			return nil
+		}
+		return map[string]interface{}{"menu_language": string(p[:3])}
+	case OpcodeUserControlPressed:
+		if len(p) < 1 {
+			return nil
+		}
+		key := Keycode(p[0])
+		decoded := map[string]interface{}{"keycode": int(key)}
+		if name := key.Name(); name != "" {
+			decoded["key"] = name
+		}
+		return decoded
+	case OpcodeFeatureAbort:
+		abort, ok := ParseFeatureAbort(cmd)
+		if !ok {
+			return nil
+		}
+		return map[string]interface{}{
+			"opcode":      fmt.Sprintf("0x%02X", uint8(abort.Opcode)),
+			"opcode_name": abort.Opcode.String(),
+			"reason":      abort.Reason.String(),
+		}
+	}
+	return nil
+}
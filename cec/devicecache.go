@@ -0,0 +1,91 @@
+package cec
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DeviceCache holds the last Device fetched for each logical address, so
+// callers that can tolerate slightly stale data can skip the several libcec
+// round trips GetDeviceInfo makes. It is safe for concurrent use.
+type DeviceCache struct {
+	mu      sync.Mutex
+	entries map[LogicalAddress]cachedDevice
+}
+
+type cachedDevice struct {
+	device  Device
+	fetched time.Time
+}
+
+// NewDeviceCache creates an empty DeviceCache.
+func NewDeviceCache() *DeviceCache {
+	return &DeviceCache{entries: make(map[LogicalAddress]cachedDevice)}
+}
+
+// Get returns a copy of the cached device for addr if it was fetched less
+// than maxAge ago.
+func (dc *DeviceCache) Get(addr LogicalAddress, maxAge time.Duration) (*Device, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	e, ok := dc.entries[addr]
+	if !ok || time.Since(e.fetched) >= maxAge {
+		return nil, false
+	}
+	device := e.device
+	return &device, true
+}
+
+// Put stores a copy of device, stamped with the current time.
+func (dc *DeviceCache) Put(device *Device) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.entries[device.LogicalAddress] = cachedDevice{device: *device, fetched: time.Now()}
+}
+
+// Invalidate drops the entry for addr.
+func (dc *DeviceCache) Invalidate(addr LogicalAddress) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	delete(dc.entries, addr)
+}
+
+// InvalidateAll drops every entry.
+func (dc *DeviceCache) InvalidateAll() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.entries = make(map[LogicalAddress]cachedDevice)
+}
+
+// invalidateSource drops the entry for addr and any entry still marked as
+// the active source, since a source change makes both stale.
+func (dc *DeviceCache) invalidateSource(addr LogicalAddress) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	delete(dc.entries, addr)
+	for a, e := range dc.entries {
+		if e.device.IsActiveSource {
+			delete(dc.entries, a)
+		}
+	}
+}
+
+// GetDeviceInfoCached returns the cached device info for addr if it is
+// younger than ttl, and otherwise fetches it with GetDeviceInfo. A ttl of
+// zero or less always fetches.
+func (c *Connection) GetDeviceInfoCached(addr LogicalAddress, ttl time.Duration) (*Device, error) {
+	if device, ok := c.devices.Get(addr, ttl); ok {
+		return device, nil
+	}
+	return c.GetDeviceInfo(addr)
+}
+
+// GetDeviceInfoCachedContext is GetDeviceInfoCached with the cache miss
+// fetched by GetDeviceInfoContext.
+func (c *Connection) GetDeviceInfoCachedContext(ctx context.Context, addr LogicalAddress, ttl time.Duration) (*Device, error) {
+	if device, ok := c.devices.Get(addr, ttl); ok {
+		return device, nil
+	}
+	return c.GetDeviceInfoContext(ctx, addr)
+}
@@ -0,0 +1,83 @@
+package cec
+
+import "fmt"
+
+// ErrorCode classifies a CECError. Each code is itself an error, so callers
+// can test for a class of failure with errors.Is:
+//
+//	if errors.Is(err, cec.ErrDeviceUnreachable) { ... }
+type ErrorCode int
+
+const (
+	// ErrNotInitialized means libcec could not be initialised or the
+	// connection is not open.
+	ErrNotInitialized ErrorCode = iota + 1
+	// ErrAdapterUnavailable means no adapter could be found or opened.
+	ErrAdapterUnavailable
+	// ErrTransmitFailed means libcec could not send a frame, or the
+	// destination didn't acknowledge it.
+	ErrTransmitFailed
+	// ErrDeviceUnreachable means a device didn't answer a query.
+	ErrDeviceUnreachable
+	// ErrInvalidArgument means a value was rejected before anything was
+	// sent on the bus.
+	ErrInvalidArgument
+	// ErrConfiguration means libcec rejected or couldn't report its
+	// configuration.
+	ErrConfiguration
+	// ErrTimeout means a device didn't reach the expected state in time.
+	ErrTimeout
+)
+
+var errorCodeNames = map[ErrorCode]string{
+	ErrNotInitialized:     "not initialized",
+	ErrAdapterUnavailable: "adapter unavailable",
+	ErrTransmitFailed:     "transmit failed",
+	ErrDeviceUnreachable:  "device unreachable",
+	ErrInvalidArgument:    "invalid argument",
+	ErrConfiguration:      "configuration error",
+	ErrTimeout:            "timeout",
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("error code %d", int(c))
+}
+
+func (c ErrorCode) Error() string {
+	return "cec: " + c.String()
+}
+
+// CECError is the error returned by Connection methods. Code says what kind
+// of failure it was; Message is the human-readable detail.
+type CECError struct {
+	Code    ErrorCode
+	Message string
+	Err     error
+}
+
+func (e *CECError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *CECError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is e's ErrorCode, so errors.Is(err, code) works
+// through any amount of wrapping.
+func (e *CECError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.Code
+}
+
+// newError returns a CECError with the given code and formatted message.
+func newError(code ErrorCode, format string, args ...interface{}) error {
+	return &CECError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -1,50 +1,92 @@
 package cec
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Helper functions for common operations
 
-// GetDeviceInfo retrieves comprehensive information about a device
+// GetDeviceInfo retrieves comprehensive information about a device. The
+// result also refreshes the cache used by GetDeviceInfoCached.
 func (c *Connection) GetDeviceInfo(address LogicalAddress) (*Device, error) {
-	device := &Device{
-		LogicalAddress: address,
-		IsActive:       c.IsActiveDevice(address),
-		IsActiveSource: c.IsActiveSource(address),
-	}
-
-	// Get physical address
-	if physAddr, err := c.GetDevicePhysicalAddress(address); err == nil {
-		device.PhysicalAddress = physAddr
-	}
+	return c.getDeviceInfo(context.Background(), address)
+}
 
-	// Get vendor ID
-	if vendorId, err := c.GetDeviceVendorId(address); err == nil {
-		device.VendorID = vendorId
+// GetDeviceInfoContext is GetDeviceInfo, but gives up with an ErrTimeout
+// error wrapping ctx.Err() once ctx is done. libcec calls can't be
+// interrupted, so ctx is checked before each query: a query already in
+// libcec finishes first. A cancelled lookup doesn't update the cache.
+func (c *Connection) GetDeviceInfoContext(ctx context.Context, address LogicalAddress) (*Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(address, err)
 	}
+	return c.getDeviceInfo(ctx, address)
+}
 
-	// Get CEC version
-	if version, err := c.GetDeviceCecVersion(address); err == nil {
-		device.CECVersion = version
-	}
+func contextError(address LogicalAddress, err error) error {
+	return &CECError{Code: ErrTimeout, Message: fmt.Sprintf("device info for %s not retrieved", address.String()), Err: err}
+}
 
-	// Get power status
-	if power, err := c.GetDevicePowerStatus(address); err == nil {
-		device.PowerStatus = power
+// getDeviceInfo runs the GetDeviceInfo queries, checking ctx before each.
+func (c *Connection) getDeviceInfo(ctx context.Context, address LogicalAddress) (*Device, error) {
+	device := &Device{
+		LogicalAddress: address,
+		IsActive:       c.IsActiveDevice(address),
+		IsActiveSource: c.IsActiveSource(address),
 	}
 
-	// Get OSD name
-	if name, err := c.GetDeviceOSDName(address); err == nil {
-		device.OSDName = name
+	queries := []func(){
+		// Get physical address
+		func() {
+			if physAddr, err := c.GetDevicePhysicalAddress(address); err == nil {
+				device.PhysicalAddress = physAddr
+			}
+		},
+		// Get vendor ID
+		func() {
+			if vendorId, err := c.GetDeviceVendorId(address); err == nil {
+				device.VendorID = vendorId
+			}
+		},
+		// Get CEC version
+		func() {
+			if version, err := c.GetDeviceCecVersion(address); err == nil {
+				device.CECVersion = version
+			}
+		},
+		// Get power status
+		func() {
+			if power, err := c.GetDevicePowerStatus(address); err == nil {
+				device.PowerStatus = power
+			}
+		},
+		// Get OSD name
+		func() {
+			if name, err := c.GetDeviceOSDName(address); err == nil {
+				device.OSDName = name
+			}
+		},
+		// Get menu language
+		func() {
+			if lang, err := c.GetDeviceMenuLanguage(address); err == nil {
+				device.MenuLanguage = lang
+			}
+		},
 	}
-
-	// Get menu language
-	if lang, err := c.GetDeviceMenuLanguage(address); err == nil {
-		device.MenuLanguage = lang
+	for _, query := range queries {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(address, err)
+		}
+		query()
 	}
 
+	c.devices.Put(device)
 	return device, nil
 }
 
@@ -75,19 +117,40 @@ func (c *Connection) GetAllDevicesNoRescan() ([]*Device, error) {
 	return devices, nil
 }
 
+// DefaultPowerPollInterval is how often WaitForDeviceReady queries the
+// device's power status.
+const DefaultPowerPollInterval = 500 * time.Millisecond
+
 // WaitForDeviceReady waits for a device to reach a specific power state
 func (c *Connection) WaitForDeviceReady(address LogicalAddress, targetState PowerStatus, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.WaitForDeviceReadyContext(ctx, address, targetState, DefaultPowerPollInterval)
+}
 
-	for time.Now().Before(deadline) {
+// WaitForDeviceReadyContext queries the device's power status every poll
+// until it reports targetState, and returns an ErrTimeout error wrapping
+// ctx.Err() once ctx is done. A poll of zero or less uses
+// DefaultPowerPollInterval.
+func (c *Connection) WaitForDeviceReadyContext(ctx context.Context, address LogicalAddress, targetState PowerStatus, poll time.Duration) error {
+	if poll <= 0 {
+		poll = DefaultPowerPollInterval
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return &CECError{Code: ErrTimeout, Message: fmt.Sprintf("timeout waiting for device %d to reach state %v", address, targetState), Err: ctx.Err()}
+		case <-timer.C:
+		}
 		status, err := c.GetDevicePowerStatus(address)
 		if err == nil && status == targetState {
 			return nil
 		}
-		time.Sleep(500 * time.Millisecond)
+		timer.Reset(poll)
 	}
-
-	return fmt.Errorf("timeout waiting for device %d to reach state %v", address, targetState)
 }
 
 // getOwnAddress returns the adapter's own logical address on the CEC bus.
@@ -101,56 +164,207 @@ func (c *Connection) getOwnAddress() LogicalAddress {
 	return LogicalAddressFreeUse
 }
 
-// sendImageViewOn sends Image View On (0x04) to the TV to wake it up and
-// ensure it is ready to process source-switching commands.
-func (c *Connection) sendImageViewOn() error {
+// PingDevice sends a CEC poll message (a frame with no opcode) to a device
+// and reports whether it was acknowledged. This is the standard CEC presence
+// check and, unlike Give Device Power Status, has no side effects on the
+// device. The error is only non-nil if address can't be polled.
+func (c *Connection) PingDevice(address LogicalAddress) (bool, error) {
+	if address >= LogicalAddressBroadcast {
+		return false, newError(ErrInvalidArgument, "cannot ping logical address %d", address)
+	}
+	cmd := &Command{
+		Initiator:   c.getOwnAddress(),
+		Destination: address,
+	}
+	return c.transmit(cmd, false) == nil, nil
+}
+
+// WakeTV sends Image View On (0x04) or Text View On (0x0D) to the TV to
+// wake it up and ensure it is ready to process source-switching commands.
+// Some TVs only react to one of the two.
+func (c *Connection) WakeTV(mode WakeMode) error {
+	opcode := OpcodeImageViewOn
+	if mode == WakeTextViewOn {
+		opcode = OpcodeTextViewOn
+	}
 	cmd := &Command{
 		Initiator:   c.getOwnAddress(),
 		Destination: LogicalAddressTV,
-		Opcode:      OpcodeImageViewOn,
+		Opcode:      opcode,
 		OpcodeSet:   true,
 	}
 	return c.Transmit(cmd)
 }
 
+// SwitchOptions controls how the SwitchTo* helpers change the TV input.
+type SwitchOptions struct {
+	// Wake sends Image View On (or Text View On, see WakeMode) to the TV
+	// and waits briefly before switching. Disabling it makes the switch
+	// faster and avoids powering on the TV, but a TV in standby may ignore
+	// the switch entirely.
+	Wake bool
+	// WakeMode selects the opcode Wake sends.
+	WakeMode WakeMode
+
+	// Retries re-sends the switch up to this many more times for TVs that
+	// ignore the first attempt while waking up. After each attempt the
+	// helper waits RetryDelay and stops early once the TV reports the new
+	// active source. Zero sends once.
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// MaxSwitchRetries bounds SwitchOptions.Retries.
+const MaxSwitchRetries = 10
+
+// DefaultSwitchRetryDelay is the delay between switch attempts when
+// SwitchOptions.RetryDelay is zero.
+const DefaultSwitchRetryDelay = time.Second
+
+// switchWakeDelay is how long a switch waits after waking the TV.
+const switchWakeDelay = 300 * time.Millisecond
+
+// Attempts returns how many times a switch with these options sends the
+// switch at most.
+func (o SwitchOptions) Attempts() int {
+	retries := o.Retries
+	if retries < 0 {
+		retries = 0
+	}
+	if retries > MaxSwitchRetries {
+		retries = MaxSwitchRetries
+	}
+	return retries + 1
+}
+
+// Pauses returns the longest a switch with these options spends waiting
+// between libcec calls: after waking the TV and before each retry.
+func (o SwitchOptions) Pauses() time.Duration {
+	var d time.Duration
+	if o.Wake {
+		d = switchWakeDelay
+	}
+	delay := o.RetryDelay
+	if delay <= 0 {
+		delay = DefaultSwitchRetryDelay
+	}
+	return d + time.Duration(o.Attempts()-1)*delay
+}
+
+// DefaultSwitchOptions returns the options used by SwitchToDevice and
+// SwitchToHDMIPort.
+func DefaultSwitchOptions() SwitchOptions {
+	return SwitchOptions{Wake: true}
+}
+
+// switchWithRetries calls send once, then again up to opts.Retries times
+// until switched reports success. A send error ends the attempts only when
+// no earlier attempt got through.
+func switchWithRetries(opts SwitchOptions, send func() error, switched func() bool) error {
+	retries := opts.Attempts() - 1
+	delay := opts.RetryDelay
+	if delay <= 0 {
+		delay = DefaultSwitchRetryDelay
+	}
+
+	err := send()
+	for i := 0; i < retries; i++ {
+		time.Sleep(delay)
+		if err == nil && switched() {
+			return nil
+		}
+		if retryErr := send(); retryErr == nil {
+			err = nil
+		}
+	}
+	return err
+}
+
+// wakeBeforeSwitch wakes the TV so it processes a following source switch.
+func (c *Connection) wakeBeforeSwitch(opts SwitchOptions) {
+	if !opts.Wake {
+		return
+	}
+	c.WakeTV(opts.WakeMode)
+	time.Sleep(switchWakeDelay)
+}
+
 // SwitchToHDMIPort switches TV input to a specific HDMI port.
 // Uses libcec's built-in SetHDMIPort as the primary method (which handles
 // CEC protocol correctly), with an Active Source broadcast as fallback.
 func (c *Connection) SwitchToHDMIPort(port uint8) error {
-	if port < 1 || port > 15 {
-		return fmt.Errorf("invalid HDMI port %d (must be 1-15)", port)
-	}
+	return c.SwitchToHDMIPortWithOptions(port, DefaultSwitchOptions())
+}
 
-	// Wake up the TV first so it processes the source switch
-	c.sendImageViewOn()
-	time.Sleep(300 * time.Millisecond)
+// MaxHDMIPort is the highest HDMI port number a physical address can encode.
+const MaxHDMIPort = 15
 
-	// Primary: use libcec's built-in HDMI port switching
-	if err := c.SetHDMIPort(LogicalAddressTV, port); err == nil {
-		return nil
+// ValidateHDMIPort checks that port is 1-MaxHDMIPort.
+func ValidateHDMIPort(port uint8) error {
+	if port < 1 || port > MaxHDMIPort {
+		return newError(ErrInvalidArgument, "invalid HDMI port %d (must be 1-%d)", port, MaxHDMIPort)
 	}
+	return nil
+}
 
-	// Fallback: send Active Source broadcast with the port's physical address
-	physicalAddress := uint16(port) << 12
-	cmd := &Command{
-		Initiator:   c.getOwnAddress(),
-		Destination: LogicalAddressBroadcast,
-		Opcode:      OpcodeActiveSource,
-		OpcodeSet:   true,
-		Parameters: []uint8{
-			uint8(physicalAddress >> 8),
-			uint8(physicalAddress & 0xFF),
-		},
+// SwitchToHDMIPortWithOptions is SwitchToHDMIPort with explicit switch options.
+func (c *Connection) SwitchToHDMIPortWithOptions(port uint8, opts SwitchOptions) error {
+	if err := ValidateHDMIPort(port); err != nil {
+		return err
 	}
 
-	return c.Transmit(cmd)
+	// Wake up the TV first so it processes the source switch
+	c.wakeBeforeSwitch(opts)
+
+	return switchWithRetries(opts, func() error {
+		// Primary: use libcec's built-in HDMI port switching
+		if err := c.SetHDMIPort(LogicalAddressTV, port); err == nil {
+			return nil
+		}
+
+		// Fallback: send Active Source broadcast with the port's physical address
+		physicalAddress := uint16(port) << 12
+		cmd := &Command{
+			Initiator:   c.getOwnAddress(),
+			Destination: LogicalAddressBroadcast,
+			Opcode:      OpcodeActiveSource,
+			OpcodeSet:   true,
+			Parameters: []uint8{
+				uint8(physicalAddress >> 8),
+				uint8(physicalAddress & 0xFF),
+			},
+		}
+
+		return c.Transmit(cmd)
+	}, func() bool {
+		physAddr, ok := c.activeSourcePhysicalAddress()
+		return ok && uint8((physAddr>>12)&0xF) == port
+	})
+}
+
+// activeSourcePhysicalAddress returns the physical address of the current
+// active source, or false if it is unknown.
+func (c *Connection) activeSourcePhysicalAddress() (uint16, bool) {
+	active, err := c.GetActiveSource()
+	if err != nil || active >= LogicalAddressBroadcast {
+		return 0, false
+	}
+	physAddr, err := c.GetDevicePhysicalAddress(active)
+	if err != nil || physAddr == 0xFFFF {
+		return 0, false
+	}
+	return physAddr, true
 }
 
 // SwitchToDevice switches to a specific device by its logical address
 func (c *Connection) SwitchToDevice(address LogicalAddress) error {
+	return c.SwitchToDeviceWithOptions(address, DefaultSwitchOptions())
+}
+
+// SwitchToDeviceWithOptions is SwitchToDevice with explicit switch options.
+func (c *Connection) SwitchToDeviceWithOptions(address LogicalAddress, opts SwitchOptions) error {
 	// Wake up the TV so it is ready to process the source switch
-	c.sendImageViewOn()
-	time.Sleep(300 * time.Millisecond)
+	c.wakeBeforeSwitch(opts)
 
 	// Get device's physical address
 	physAddr, err := c.GetDevicePhysicalAddress(address)
@@ -171,6 +385,64 @@ func (c *Connection) SwitchToDevice(address LogicalAddress) error {
 		},
 	}
 
+	return switchWithRetries(opts, func() error {
+		return c.Transmit(cmd)
+	}, func() bool {
+		active, ok := c.activeSourcePhysicalAddress()
+		return ok && active == physAddr
+	})
+}
+
+// SetStreamPath broadcasts Set Stream Path (0x86) for a physical address.
+// Where Active Source announces that a device wants to be shown, Set Stream
+// Path is the TV's own routing request, so displays that ignore Active Source
+// from other devices often honour it.
+func (c *Connection) SetStreamPath(physicalAddress uint16) error {
+	if physicalAddress == 0xFFFF {
+		return newError(ErrInvalidArgument, "invalid physical address %s", PhysicalAddressToString(physicalAddress))
+	}
+	cmd := &Command{
+		Initiator:   c.getOwnAddress(),
+		Destination: LogicalAddressBroadcast,
+		Opcode:      OpcodeSetStreamPath,
+		OpcodeSet:   true,
+		Parameters: []uint8{
+			uint8(physicalAddress >> 8),
+			uint8(physicalAddress & 0xFF),
+		},
+	}
+	return c.Transmit(cmd)
+}
+
+// SetAudioRate sends Set Audio Rate (0x9A) to the audio system.
+func (c *Connection) SetAudioRate(rate AudioRate) error {
+	if rate > AudioRateNarrowSlow {
+		return newError(ErrInvalidArgument, "invalid audio rate 0x%02X", uint8(rate))
+	}
+	cmd := &Command{
+		Initiator:   c.getOwnAddress(),
+		Destination: LogicalAddressAudioSystem,
+		Opcode:      OpcodeSetAudioRate,
+		OpcodeSet:   true,
+		Parameters:  []uint8{uint8(rate)},
+	}
+	return c.Transmit(cmd)
+}
+
+// SetAudioVolume sends Set Audio Volume Level (0x73, CEC 2.0) to the audio
+// system, asking it to change to an absolute volume of 0-100. Audio systems
+// that predate CEC 2.0 answer with Feature Abort; use SetVolume for those.
+func (c *Connection) SetAudioVolume(level uint8) error {
+	if level > maxAudioVolume {
+		return newError(ErrInvalidArgument, "invalid volume level %d (must be 0-%d)", level, maxAudioVolume)
+	}
+	cmd := &Command{
+		Initiator:   c.getOwnAddress(),
+		Destination: LogicalAddressAudioSystem,
+		Opcode:      OpcodeSetAudioVolumeLevel,
+		OpcodeSet:   true,
+		Parameters:  []uint8{level},
+	}
 	return c.Transmit(cmd)
 }
 
@@ -196,12 +468,22 @@ type PortInfo struct {
 	Devices []LogicalAddress `json:"devices"`
 }
 
+// AddressConflict describes two or more logical addresses that report the
+// same physical address. This usually means an HDMI misconfiguration (e.g. a
+// switch or AVR repeating its own address), although a single device holding
+// several logical addresses will also show up here.
+type AddressConflict struct {
+	PhysicalAddress uint16           `json:"physical_address"`
+	Devices         []LogicalAddress `json:"devices"`
+}
+
 // BusTopology describes the HDMI bus as seen through CEC.
 type BusTopology struct {
-	OwnAddress     LogicalAddress `json:"own_address"`
-	OwnPort        uint8          `json:"own_port"`          // HDMI port the adapter is on (0 = unknown)
-	ActivePorts    []PortInfo     `json:"active_ports"`      // ports with at least one device
-	KnownPortCount uint8          `json:"known_port_count"`  // highest port number observed
+	OwnAddress     LogicalAddress    `json:"own_address"`
+	OwnPort        uint8             `json:"own_port"`         // HDMI port the adapter is on (0 = unknown)
+	ActivePorts    []PortInfo        `json:"active_ports"`     // ports with at least one device
+	KnownPortCount uint8             `json:"known_port_count"` // highest port number observed
+	Conflicts      []AddressConflict `json:"conflicts"`        // devices sharing a physical address
 }
 
 // GetBusTopology builds a topology of the CEC bus by inspecting the physical
@@ -221,6 +503,7 @@ func (c *Connection) GetBusTopology() *BusTopology {
 
 	// Collect all active devices and group by port
 	portMap := make(map[uint8][]LogicalAddress)
+	physMap := make(map[uint16][]LogicalAddress)
 	for _, addr := range c.GetActiveDevices() {
 		// Skip TV (address 0) — it IS the display, not on a port
 		if addr == LogicalAddressTV {
@@ -230,6 +513,7 @@ func (c *Connection) GetBusTopology() *BusTopology {
 		if err != nil || physAddr == 0 || physAddr == 0xFFFF {
 			continue
 		}
+		physMap[physAddr] = append(physMap[physAddr], addr)
 		port := uint8((physAddr >> 12) & 0xF)
 		if port == 0 {
 			continue // 0.x.x.x means internal / unknown
@@ -247,9 +531,172 @@ func (c *Connection) GetBusTopology() *BusTopology {
 		}
 	}
 
+	topo.Conflicts = findAddressConflicts(physMap)
+
 	return topo
 }
 
+// TopologyRefresh reports how a RefreshBusTopology call went.
+type TopologyRefresh struct {
+	Queried  []LogicalAddress `json:"queried"`  // devices asked for their physical address
+	Failed   []LogicalAddress `json:"failed"`   // devices that didn't acknowledge the request
+	Skipped  []LogicalAddress `json:"skipped"`  // devices not queried before the deadline
+	Duration time.Duration    `json:"duration"` // time spent refreshing
+}
+
+// topologyRefreshSettle is how long RefreshBusTopology waits for Report
+// Physical Address replies after the last request.
+const topologyRefreshSettle = 500 * time.Millisecond
+
+// RefreshBusTopology asks every active device to report its physical address
+// again (Give Physical Address) and then rebuilds the topology from the
+// answers. Unlike a full rescan it only refreshes physical addresses. Devices
+// not queried before timeout elapses keep their cached address.
+func (c *Connection) RefreshBusTopology(timeout time.Duration) (*BusTopology, *TopologyRefresh) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	refresh := &TopologyRefresh{
+		Queried: make([]LogicalAddress, 0),
+		Failed:  make([]LogicalAddress, 0),
+		Skipped: make([]LogicalAddress, 0),
+	}
+
+	own := c.getOwnAddress()
+	for _, addr := range c.GetActiveDevices() {
+		if addr == own {
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			refresh.Skipped = append(refresh.Skipped, addr)
+			continue
+		}
+		cmd := &Command{
+			Initiator:   own,
+			Destination: addr,
+			Opcode:      OpcodeGivePhysicalAddress,
+			OpcodeSet:   true,
+		}
+		refresh.Queried = append(refresh.Queried, addr)
+		if err := c.Transmit(cmd); err != nil {
+			refresh.Failed = append(refresh.Failed, addr)
+		}
+	}
+
+	// Give the replies a moment to arrive, without overrunning the deadline
+	if len(refresh.Queried) > len(refresh.Failed) {
+		settle := time.Until(deadline)
+		if settle > topologyRefreshSettle {
+			settle = topologyRefreshSettle
+		}
+		if settle > 0 {
+			time.Sleep(settle)
+		}
+	}
+
+	topo := c.GetBusTopology()
+	refresh.Duration = time.Since(start)
+	return topo, refresh
+}
+
+// findAddressConflicts returns one AddressConflict for every physical address
+// claimed by more than one logical address, sorted by physical address.
+func findAddressConflicts(physMap map[uint16][]LogicalAddress) []AddressConflict {
+	conflicts := make([]AddressConflict, 0)
+	for physAddr, addrs := range physMap {
+		if len(addrs) > 1 {
+			conflicts = append(conflicts, AddressConflict{PhysicalAddress: physAddr, Devices: addrs})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].PhysicalAddress < conflicts[j].PhysicalAddress
+	})
+	return conflicts
+}
+
+// AdapterInfo compares how the adapter was configured with what it detected
+// on the bus. A mismatch between the two is a common cause of source
+// switching selecting the wrong input.
+type AdapterInfo struct {
+	LogicalAddresses          []LogicalAddress `json:"logical_addresses"`
+	BaseDevice                LogicalAddress   `json:"base_device"`                 // device the adapter is connected to
+	HDMIPort                  uint8            `json:"hdmi_port"`                   // port on the base device (0 = auto-detect)
+	ConfiguredPhysicalAddress uint16           `json:"configured_physical_address"` // from libcec's configuration (0xFFFF = auto-detect)
+	ExpectedPhysicalAddress   uint16           `json:"expected_physical_address"`   // derived from base device + HDMI port (0xFFFF = unknown)
+	DetectedPhysicalAddress   uint16           `json:"detected_physical_address"`   // reported for our own logical address (0xFFFF = unknown)
+	MenuLanguage              string           `json:"menu_language"`               // menu language the adapter advertises
+	Mismatches                []string         `json:"mismatches"`                  // human readable disagreements
+}
+
+// GetAdapterInfo reads the adapter's current configuration and compares the
+// configured base device, HDMI port and physical address with the physical
+// address detected on the bus.
+func (c *Connection) GetAdapterInfo() (*AdapterInfo, error) {
+	config, err := c.GetCurrentConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &AdapterInfo{
+		LogicalAddresses:          c.GetLogicalAddresses(),
+		BaseDevice:                config.BaseDevice,
+		HDMIPort:                  config.HDMIPort,
+		ConfiguredPhysicalAddress: config.PhysicalAddress,
+		ExpectedPhysicalAddress:   0xFFFF,
+		DetectedPhysicalAddress:   0xFFFF,
+		MenuLanguage:              config.MenuLanguage,
+		Mismatches:                make([]string, 0),
+	}
+
+	own := c.getOwnAddress()
+	if own != LogicalAddressFreeUse && own != LogicalAddressBroadcast {
+		if physAddr, err := c.GetDevicePhysicalAddress(own); err == nil {
+			info.DetectedPhysicalAddress = physAddr
+		}
+	}
+
+	if config.HDMIPort > 0 && config.HDMIPort <= 15 {
+		if basePhys, err := c.GetDevicePhysicalAddress(config.BaseDevice); err == nil {
+			if child, ok := ChildPhysicalAddress(basePhys, config.HDMIPort); ok {
+				info.ExpectedPhysicalAddress = child
+			}
+		}
+	}
+
+	detected := info.DetectedPhysicalAddress
+	if detected != 0xFFFF {
+		configured := info.ConfiguredPhysicalAddress
+		if configured != 0 && configured != 0xFFFF && configured != detected {
+			info.Mismatches = append(info.Mismatches, fmt.Sprintf(
+				"configured physical address %s differs from detected %s",
+				PhysicalAddressToString(configured), PhysicalAddressToString(detected)))
+		}
+		expected := info.ExpectedPhysicalAddress
+		if expected != 0xFFFF && expected != detected {
+			info.Mismatches = append(info.Mismatches, fmt.Sprintf(
+				"base device %s port %d implies physical address %s but %s was detected",
+				config.BaseDevice.String(), config.HDMIPort,
+				PhysicalAddressToString(expected), PhysicalAddressToString(detected)))
+		}
+	}
+
+	return info, nil
+}
+
+// ChildPhysicalAddress returns the physical address of the device on the
+// given HDMI port of parent, or false if parent is invalid or already at the
+// maximum depth of the HDMI tree.
+func ChildPhysicalAddress(parent uint16, port uint8) (uint16, bool) {
+	if parent == 0xFFFF || port == 0 || port > 15 {
+		return 0, false
+	}
+	for shift := 12; shift >= 0; shift -= 4 {
+		if (parent>>uint(shift))&0xF == 0 {
+			return parent | uint16(port)<<uint(shift), true
+		}
+	}
+	return 0, false
+}
+
 // DeviceTypeForAddress returns the expected DeviceType for a logical address.
 func DeviceTypeForAddress(addr LogicalAddress) DeviceType {
 	switch addr {
@@ -268,37 +715,83 @@ func DeviceTypeForAddress(addr LogicalAddress) DeviceType {
 	}
 }
 
+// builtinVendorNames maps well-known CEC vendor IDs to names.
+var builtinVendorNames = map[uint64]string{
+	0x000039: "Toshiba",
+	0x0000F0: "Samsung",
+	0x00044B: "NVIDIA",
+	0x0005CD: "Denon",
+	0x000678: "Marantz",
+	0x000982: "Loewe",
+	0x0009B0: "Onkyo",
+	0x000CB8: "Medion",
+	0x000CE7: "Toshiba",
+	0x000D4B: "Roku",
+	0x0010FA: "Apple",
+	0x001582: "Pulse Eight",
+	0x001950: "Google",
+	0x0019FB: "Harman Kardon",
+	0x001A11: "Akai",
+	0x0020C7: "AOC",
+	0x002467: "Panasonic",
+	0x008045: "Philips",
+	0x00903E: "Pioneer",
+	0x009053: "LG",
+	0x00A0DE: "Sharp",
+	0x00D0D5: "Vizio",
+	0x00E036: "Harman Kardon",
+	0x00E091: "Yamaha",
+	0x08001F: "Sony",
+	0x080046: "Sony",
+	0x18C086: "Broadcom",
+	0x534850: "Sharp",
+	0x6B746D: "Vizio",
+	0x8065E9: "Benq",
+	0x9C645E: "Daewoo",
+}
+
+var (
+	vendorNamesMu    sync.RWMutex
+	extraVendorNames map[uint64]string
+)
+
+// SetVendorNames adds vendor names on top of the built-in ones, replacing
+// any set by an earlier call. A name given here wins over the built-in
+// name for the same ID.
+func SetVendorNames(names map[uint64]string) {
+	extra := make(map[uint64]string, len(names))
+	for id, name := range names {
+		extra[id] = name
+	}
+	vendorNamesMu.Lock()
+	extraVendorNames = extra
+	vendorNamesMu.Unlock()
+}
+
+// VendorNames returns the effective vendor ID to name map: the built-in
+// names merged with those given to SetVendorNames.
+func VendorNames() map[uint64]string {
+	vendorNamesMu.RLock()
+	defer vendorNamesMu.RUnlock()
+	names := make(map[uint64]string, len(builtinVendorNames)+len(extraVendorNames))
+	for id, name := range builtinVendorNames {
+		names[id] = name
+	}
+	for id, name := range extraVendorNames {
+		names[id] = name
+	}
+	return names
+}
+
 // GetVendorName returns a human-readable vendor name
 func GetVendorName(vendorId uint64) string {
-	vendors := map[uint64]string{
-		0x000039: "Toshiba",
-		0x0000F0: "Samsung",
-		0x0005CD: "Denon",
-		0x000678: "Marantz",
-		0x000982: "Loewe",
-		0x0009B0: "Onkyo",
-		0x000CB8: "Medion",
-		0x000CE7: "Toshiba",
-		0x001582: "Pulse Eight",
-		0x001950: "Google",
-		0x001A11: "Akai",
-		0x0020C7: "AOC",
-		0x002467: "Panasonic",
-		0x008045: "Philips",
-		0x00903E: "Pioneer",
-		0x009053: "LG",
-		0x00A0DE: "Sharp",
-		0x00D0D5: "Vizio",
-		0x00E036: "Harman Kardon",
-		0x00E091: "Yamaha",
-		0x08001F: "Sony",
-		0x18C086: "Broadcom",
-		0x6B746D: "Vizio",
-		0x8065E9: "Benq",
-		0x9C645E: "Daewoo",
-	}
-
-	if name, ok := vendors[vendorId]; ok {
+	vendorNamesMu.RLock()
+	name, ok := extraVendorNames[vendorId]
+	vendorNamesMu.RUnlock()
+	if ok {
+		return name
+	}
+	if name, ok := builtinVendorNames[vendorId]; ok {
 		return name
 	}
 	return fmt.Sprintf("Unknown (0x%06X)", vendorId)
@@ -322,21 +815,105 @@ func ParsePhysicalAddress(addrStr string) (uint16, error) {
 	}
 
 	if a > 15 || b > 15 || c > 15 || d > 15 {
-		return 0, fmt.Errorf("invalid physical address components (must be 0-15)")
+		return 0, newError(ErrInvalidArgument, "invalid physical address components (must be 0-15)")
 	}
 
 	return (a << 12) | (b << 8) | (c << 4) | d, nil
 }
 
+// IsValidMenuLanguage reports whether code is a syntactically valid ISO 639-2
+// language code as used by CEC Set Menu Language: three lowercase ASCII
+// letters, e.g. "eng" or "deu".
+func IsValidMenuLanguage(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < 'a' || code[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxDeviceNameLength is the longest OSD name, in bytes, that the adapter
+// advertises. Longer names are truncated by TruncateDeviceName.
+const MaxDeviceNameLength = 13
+
+// ValidateDeviceName checks that name can be used as the adapter's OSD name:
+// it must be non-empty, valid UTF-8 and free of control characters. Length
+// is not checked; see TruncateDeviceName.
+func ValidateDeviceName(name string) error {
+	if name == "" {
+		return newError(ErrInvalidArgument, "device name must not be empty")
+	}
+	if !utf8.ValidString(name) {
+		return newError(ErrInvalidArgument, "device name is not valid UTF-8")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return newError(ErrInvalidArgument, "device name contains control character %U", r)
+		}
+	}
+	return nil
+}
+
+// TruncateDeviceName shortens name to at most MaxDeviceNameLength bytes
+// without splitting a multibyte UTF-8 character.
+func TruncateDeviceName(name string) string {
+	if len(name) <= MaxDeviceNameLength {
+		return name
+	}
+	n := MaxDeviceNameLength
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+	return name[:n]
+}
+
+// MaxOSDStringLength is the longest Set OSD String message, in bytes, that
+// fits in one CEC frame.
+const MaxOSDStringLength = 13
+
+// ValidateOSDString checks that message can be sent with SetOSDString: at
+// most MaxOSDStringLength bytes of printable ASCII, which is all CEC allows.
+// Anything else would be truncated or mangled by the display.
+func ValidateOSDString(message string) error {
+	if len(message) > MaxOSDStringLength {
+		return newError(ErrInvalidArgument, "OSD message is %d bytes (max %d)", len(message), MaxOSDStringLength)
+	}
+	for i := 0; i < len(message); i++ {
+		if message[i] < 0x20 || message[i] > 0x7E {
+			return newError(ErrInvalidArgument, "OSD message has non-printable or non-ASCII byte 0x%02X at offset %d", message[i], i)
+		}
+	}
+	return nil
+}
+
+// ClearOSDString removes a message previously shown on a device with
+// SetOSDString, such as one displayed with DisplayControlUntilCleared.
+func (c *Connection) ClearOSDString(address LogicalAddress) error {
+	return c.SetOSDString(address, DisplayControlClearPrevious, "")
+}
+
+// DefaultButtonHold is how long SendButton holds a key down.
+const DefaultButtonHold = 100 * time.Millisecond
+
 // SendButton sends a button press and release
 func (c *Connection) SendButton(address LogicalAddress, key Keycode) error {
+	return c.SendButtonTimed(address, key, DefaultButtonHold)
+}
+
+// SendButtonTimed sends a button press, holds it for hold, then sends the
+// release. Some devices only register menu navigation with holds of around
+// 500ms.
+func (c *Connection) SendButtonTimed(address LogicalAddress, key Keycode, hold time.Duration) error {
 	// Send press
 	if err := c.SendKeypress(address, key, false); err != nil {
 		return err
 	}
 
-	// Wait a bit
-	time.Sleep(100 * time.Millisecond)
+	time.Sleep(hold)
 
 	// Send release
 	return c.SendKeyRelease(address, false)
@@ -347,6 +924,73 @@ func (c *Connection) NavigateMenu(address LogicalAddress, direction Keycode) err
 	return c.SendButton(address, direction)
 }
 
+// AudioVolumeUnknown is the volume an audio system reports (or libcec
+// returns) when the volume and mute state are not known.
+const AudioVolumeUnknown = 0x7F
+
+const (
+	audioMuteMask   = 0x80
+	audioVolumeMask = 0x7F
+	maxAudioVolume  = 100
+)
+
+// ErrAudioStatusUnknown is returned when the audio system's volume and mute
+// state are unknown, usually because there is no audio system on the bus.
+// It also matches ErrDeviceUnreachable.
+var ErrAudioStatusUnknown error = &CECError{Code: ErrDeviceUnreachable, Message: "audio status unknown"}
+
+// DecodeAudioStatus decodes an Audio Status byte as sent in Report Audio
+// Status: bit 7 is the mute flag and bits 0-6 the volume, 0-100. A volume of
+// 0x7F means the status is unknown and returns ErrAudioStatusUnknown;
+// 0x65-0x7E are reserved and return an ErrInvalidArgument error.
+func DecodeAudioStatus(status uint8) (volume uint8, muted bool, err error) {
+	volume = status & audioVolumeMask
+	if volume == AudioVolumeUnknown {
+		return 0, false, ErrAudioStatusUnknown
+	}
+	if volume > maxAudioVolume {
+		return 0, false, newError(ErrInvalidArgument, "reserved audio volume 0x%02X", volume)
+	}
+	return volume, status&audioMuteMask != 0, nil
+}
+
+// audioMuteState reads the audio system's mute state. known is false when
+// the audio system doesn't report its status.
+func (c *Connection) audioMuteState() (muted bool, known bool) {
+	_, muted, err := c.GetAudioStatus()
+	if err != nil {
+		return false, false
+	}
+	return muted, true
+}
+
+// ToggleMuteWithStatus toggles mute on the audio system and returns the
+// resulting mute state. When the current state is known an explicit mute or
+// unmute is sent instead of a blind toggle. The returned state is nil if the
+// audio system doesn't report whether it is muted.
+func (c *Connection) ToggleMuteWithStatus() (*bool, error) {
+	wasMuted, known := c.audioMuteState()
+
+	var err error
+	switch {
+	case !known:
+		err = c.AudioToggleMute()
+	case wasMuted:
+		err = c.AudioUnmute()
+	default:
+		err = c.AudioMute()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	muted, known := c.audioMuteState()
+	if !known {
+		return nil, nil
+	}
+	return &muted, nil
+}
+
 // SetVolume sets absolute volume (if supported by device)
 // This is a helper that sends multiple volume up/down commands
 func (c *Connection) SetVolume(targetLevel int, currentLevel int) error {
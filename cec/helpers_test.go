@@ -0,0 +1,164 @@
+package cec
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestValidateDeviceName(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		ok    bool
+	}{
+		{"ascii", "CEC Bridge", true},
+		{"multibyte", "Wohnzimmer-Café", true},
+		{"over length", "A very long device name", true},
+		{"empty", "", false},
+		{"invalid utf-8", "Bridge\xff", false},
+		{"control character", "Bridge\n", false},
+		{"nul", "Bri\x00dge", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDeviceName(tt.input)
+			if tt.ok && err != nil {
+				t.Errorf("ValidateDeviceName(%q) = %v, want nil", tt.input, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("ValidateDeviceName(%q) = %v, want ErrInvalidArgument", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestTruncateDeviceName(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"short", "CEC Bridge", "CEC Bridge"},
+		{"exactly 13 bytes", "ABCDEFGHIJKLM", "ABCDEFGHIJKLM"},
+		{"over length", "ABCDEFGHIJKLMNOP", "ABCDEFGHIJKLM"},
+		{"multibyte fits", "Café", "Café"},
+		// "é" is 2 bytes and would straddle the 13-byte limit
+		{"multibyte at boundary", "ABCDEFGHIJKLé", "ABCDEFGHIJKL"},
+		// each "€" is 3 bytes: four fit in 12 bytes, a fifth would need 15
+		{"three-byte runes", "€€€€€€", "€€€€"},
+		// the 4-byte emoji would end at byte 14 and doesn't fit
+		{"four-byte rune", "Living Rm 📺", "Living Rm "},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateDeviceName(tt.input)
+			if got != tt.want {
+				t.Errorf("TruncateDeviceName(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if len(got) > MaxDeviceNameLength {
+				t.Errorf("TruncateDeviceName(%q) is %d bytes, max %d", tt.input, len(got), MaxDeviceNameLength)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("TruncateDeviceName(%q) = %q is not valid UTF-8", tt.input, got)
+			}
+		})
+	}
+}
+
+func TestDecodeAudioStatus(t *testing.T) {
+	tests := []struct {
+		status  uint8
+		volume  uint8
+		muted   bool
+		unknown bool
+		wantErr bool
+	}{
+		{status: 0x00, volume: 0},
+		{status: 0x32, volume: 50},
+		{status: 0x64, volume: 100},
+		{status: 0x80, volume: 0, muted: true},
+		{status: 0xB2, volume: 50, muted: true},
+		{status: 0xE4, volume: 100, muted: true},
+		{status: 0x65, wantErr: true},
+		{status: 0x7E, wantErr: true},
+		{status: 0xFE, wantErr: true},
+		{status: 0x7F, unknown: true, wantErr: true},
+		{status: 0xFF, unknown: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("0x%02X", tt.status), func(t *testing.T) {
+			volume, muted, err := DecodeAudioStatus(tt.status)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("DecodeAudioStatus(0x%02X) = %d, %v, want an error", tt.status, volume, muted)
+				}
+				if got := errors.Is(err, ErrAudioStatusUnknown); got != tt.unknown {
+					t.Errorf("errors.Is(%v, ErrAudioStatusUnknown) = %v, want %v", err, got, tt.unknown)
+				}
+				if got := errors.Is(err, ErrInvalidArgument); got == tt.unknown {
+					t.Errorf("errors.Is(%v, ErrInvalidArgument) = %v, want %v", err, got, !tt.unknown)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeAudioStatus(0x%02X): %v", tt.status, err)
+			}
+			if volume != tt.volume || muted != tt.muted {
+				t.Errorf("DecodeAudioStatus(0x%02X) = %d, %v, want %d, %v", tt.status, volume, muted, tt.volume, tt.muted)
+			}
+		})
+	}
+}
+
+func TestValidateHDMIPort(t *testing.T) {
+	tests := []struct {
+		port uint8
+		ok   bool
+	}{
+		{0, false},
+		{1, true},
+		{4, true},
+		{15, true},
+		{16, false},
+	}
+	for _, tt := range tests {
+		err := ValidateHDMIPort(tt.port)
+		if tt.ok && err != nil {
+			t.Errorf("ValidateHDMIPort(%d) = %v, want nil", tt.port, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("ValidateHDMIPort(%d) = %v, want ErrInvalidArgument", tt.port, err)
+		}
+	}
+}
+
+func TestValidateOSDString(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		ok      bool
+	}{
+		{"empty", "", true},
+		{"short", "Hello", true},
+		{"13 characters", "ABCDEFGHIJKLM", true},
+		{"14 characters", "ABCDEFGHIJKLMN", false},
+		{"printable punctuation", "~ !Vol: 42% ~", true},
+		{"non-ascii", "Café", false},
+		{"control character", "Line\nbreak", false},
+		{"delete", "Del\x7f", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOSDString(tt.message)
+			if tt.ok && err != nil {
+				t.Errorf("ValidateOSDString(%q) = %v, want nil", tt.message, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("ValidateOSDString(%q) = %v, want ErrInvalidArgument", tt.message, err)
+			}
+		})
+	}
+}
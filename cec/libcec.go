@@ -6,6 +6,15 @@ package cec
 #include <libcec/cecc.h>
 #include <stdlib.h>
 
+// Latest Pulse-Eight adapter firmware known to libcec. Headers that don't
+// define it get 0, which disables the outdated-firmware check.
+#ifndef CEC_LATEST_ADAPTER_FW_VERSION
+#define CEC_LATEST_ADAPTER_FW_VERSION 0
+#endif
+#ifndef CEC_LATEST_ADAPTER_FW_DATE
+#define CEC_LATEST_ADAPTER_FW_DATE 0
+#endif
+
 // Callback forwarders
 extern void goLogMessageCallback(void*, const cec_log_message*);
 extern void goKeyPressCallback(void*, const cec_keypress*);
@@ -30,8 +39,7 @@ static ICECCallbacks* createCallbacks() {
 */
 import "C"
 import (
-	"errors"
-	"fmt"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -42,6 +50,8 @@ type Connection struct {
 	handle      C.libcec_connection_t
 	config      *Configuration
 	callbacks   CallbackHandler
+	devices     *DeviceCache
+	onTransmit  func(command *Command, err error)
 	mu          sync.Mutex
 	initialized bool
 }
@@ -56,6 +66,30 @@ type Configuration struct {
 	ClientVersion     uint32
 	ServerVersion     uint32
 	TryLogicalAddress LogicalAddress
+	MenuLanguage      string // ISO 639-2 code advertised by the adapter (empty = libcec default)
+	FirmwareVersion   uint16 // adapter firmware version, read-only (0 = not reported)
+	FirmwareBuildDate uint32 // adapter firmware build date as a Unix timestamp, read-only
+}
+
+// Latest Pulse-Eight adapter firmware known to the libcec capi was built
+// against (0 when the headers don't say).
+const (
+	LatestAdapterFirmwareVersion = uint16(C.CEC_LATEST_ADAPTER_FW_VERSION)
+	LatestAdapterFirmwareDate    = uint32(C.CEC_LATEST_ADAPTER_FW_DATE)
+)
+
+// FirmwareUpgradeRecommended reports whether an adapter running firmware
+// version with the given build date is older than the latest firmware libcec
+// knows about. Adapters that don't report a firmware version (anything other
+// than Pulse-Eight USB-CEC adapters) never need an upgrade.
+func FirmwareUpgradeRecommended(version uint16, buildDate uint32) bool {
+	if version == 0 || LatestAdapterFirmwareVersion == 0 {
+		return false
+	}
+	if version != LatestAdapterFirmwareVersion {
+		return version < LatestAdapterFirmwareVersion
+	}
+	return buildDate != 0 && LatestAdapterFirmwareDate != 0 && buildDate < LatestAdapterFirmwareDate
 }
 
 // CallbackHandler interface for handling CEC events
@@ -103,21 +137,23 @@ func OpenWithConfig(config *Configuration) (*Connection, error) {
 	conn := &Connection{
 		config:    config,
 		callbacks: &DefaultCallbackHandler{},
+		devices:   NewDeviceCache(),
 	}
 
 	// Create libcec configuration
 	cConfig := C.libcec_configuration{}
 	C.libcec_clear_configuration(&cConfig)
 
-	cDeviceName := C.CString(config.DeviceName)
-	defer C.free(unsafe.Pointer(cDeviceName))
-	C.strncpy(&cConfig.strDeviceName[0], cDeviceName, 13)
+	if err := setDeviceName(&cConfig, config.DeviceName); err != nil {
+		return nil, err
+	}
 
 	cConfig.deviceTypes.types[0] = C.cec_device_type(config.DeviceType)
 	cConfig.iPhysicalAddress = C.uint16_t(config.PhysicalAddress)
 	cConfig.baseDevice = C.cec_logical_address(config.BaseDevice)
 	cConfig.iHDMIPort = C.uint8_t(config.HDMIPort)
 	cConfig.clientVersion = C.uint32_t(config.ClientVersion)
+	setMenuLanguage(&cConfig, config.MenuLanguage)
 
 	// Create callbacks
 	callbacks := C.createCallbacks()
@@ -126,7 +162,7 @@ func OpenWithConfig(config *Configuration) (*Connection, error) {
 	// Initialize libcec
 	conn.handle = C.libcec_initialise(&cConfig)
 	if conn.handle == nil {
-		return nil, errors.New("failed to initialize libcec")
+		return nil, newError(ErrNotInitialized, "failed to initialize libcec")
 	}
 
 	// Register connection for callbacks
@@ -145,24 +181,67 @@ func (c *Connection) SetCallbackHandler(handler CallbackHandler) {
 	c.callbacks = handler
 }
 
-// FindAdapters lists available CEC adapters
+// FindAdapters lists available CEC adapters, up to MaxAdapters.
 func (c *Connection) FindAdapters() ([]Adapter, error) {
-	var adapters [10]C.cec_adapter
-	count := C.libcec_find_adapters(c.handle, &adapters[0], 10, nil)
+	return collectAdapters(c.findAdaptersInto)
+}
+
+const (
+	// initialAdapterBuffer is the number of adapters FindAdapters makes
+	// room for on its first detection pass.
+	initialAdapterBuffer = 10
+	// MaxAdapters is the most adapters FindAdapters can return, limited by
+	// libcec reporting the count as an int8.
+	MaxAdapters = 127
+)
 
+// findAdaptersInto runs one libcec detection pass with room for size
+// adapters. It returns the adapters written to the buffer and the count
+// libcec reported, which may exceed size when the buffer was too small.
+func (c *Connection) findAdaptersInto(size int) ([]Adapter, int) {
+	buf := make([]C.cec_adapter, size)
+	count := int(C.libcec_find_adapters(c.handle, &buf[0], C.uint8_t(size), nil))
 	if count < 0 {
-		return nil, errors.New("failed to find adapters")
+		return nil, count
 	}
 
-	result := make([]Adapter, count)
-	for i := 0; i < int(count); i++ {
+	n := count
+	if n > size {
+		n = size
+	}
+	result := make([]Adapter, n)
+	for i := 0; i < n; i++ {
 		result[i] = Adapter{
-			Path: C.GoString(&adapters[i].path[0]),
-			Comm: C.GoString(&adapters[i].comm[0]),
+			Path: C.GoString(&buf[i].path[0]),
+			Comm: C.GoString(&buf[i].comm[0]),
 		}
 	}
+	return result, count
+}
 
-	return result, nil
+// collectAdapters calls find with a growing buffer until every detected
+// adapter fits or MaxAdapters is reached. A full buffer is treated as
+// possibly truncated, since libcec may cap the count at the buffer size.
+func collectAdapters(find func(size int) ([]Adapter, int)) ([]Adapter, error) {
+	size := initialAdapterBuffer
+	for {
+		adapters, count := find(size)
+		if count < 0 {
+			return nil, newError(ErrAdapterUnavailable, "failed to find adapters")
+		}
+		if count < size || size >= MaxAdapters {
+			return adapters, nil
+		}
+
+		next := size * 2
+		if count > next {
+			next = count
+		}
+		if next > MaxAdapters {
+			next = MaxAdapters
+		}
+		size = next
+	}
 }
 
 // OpenAdapter opens a connection to a specific adapter
@@ -171,7 +250,7 @@ func (c *Connection) OpenAdapter(adapterPath string) error {
 	defer C.free(unsafe.Pointer(cPath))
 
 	if C.libcec_open(c.handle, cPath, 5000) == 0 {
-		return errors.New("failed to open adapter")
+		return newError(ErrAdapterUnavailable, "failed to open adapter")
 	}
 
 	return nil
@@ -197,7 +276,7 @@ func (c *Connection) Close() error {
 // PowerOn powers on a device
 func (c *Connection) PowerOn(address LogicalAddress) error {
 	if C.libcec_power_on_devices(c.handle, C.cec_logical_address(address)) == 0 {
-		return fmt.Errorf("failed to power on device %d", address)
+		return newError(ErrTransmitFailed, "failed to power on device %d", address)
 	}
 	return nil
 }
@@ -205,7 +284,7 @@ func (c *Connection) PowerOn(address LogicalAddress) error {
 // Standby puts a device in standby mode
 func (c *Connection) Standby(address LogicalAddress) error {
 	if C.libcec_standby_devices(c.handle, C.cec_logical_address(address)) == 0 {
-		return fmt.Errorf("failed to standby device %d", address)
+		return newError(ErrTransmitFailed, "failed to standby device %d", address)
 	}
 	return nil
 }
@@ -213,7 +292,7 @@ func (c *Connection) Standby(address LogicalAddress) error {
 // SetActiveSource sets the active source
 func (c *Connection) SetActiveSource(deviceType DeviceType) error {
 	if C.libcec_set_active_source(c.handle, C.cec_device_type(deviceType)) == 0 {
-		return errors.New("failed to set active source")
+		return newError(ErrTransmitFailed, "failed to set active source")
 	}
 	return nil
 }
@@ -221,7 +300,7 @@ func (c *Connection) SetActiveSource(deviceType DeviceType) error {
 // SetInactiveView marks as inactive view
 func (c *Connection) SetInactiveView() error {
 	if C.libcec_set_inactive_view(c.handle) == 0 {
-		return errors.New("failed to set inactive view")
+		return newError(ErrTransmitFailed, "failed to set inactive view")
 	}
 	return nil
 }
@@ -233,7 +312,7 @@ func (c *Connection) VolumeUp(sendRelease bool) error {
 		release = 1
 	}
 	if C.libcec_volume_up(c.handle, release) == 0 {
-		return errors.New("failed to increase volume")
+		return newError(ErrTransmitFailed, "failed to increase volume")
 	}
 	return nil
 }
@@ -245,7 +324,7 @@ func (c *Connection) VolumeDown(sendRelease bool) error {
 		release = 1
 	}
 	if C.libcec_volume_down(c.handle, release) == 0 {
-		return errors.New("failed to decrease volume")
+		return newError(ErrTransmitFailed, "failed to decrease volume")
 	}
 	return nil
 }
@@ -253,7 +332,7 @@ func (c *Connection) VolumeDown(sendRelease bool) error {
 // AudioToggleMute toggles mute
 func (c *Connection) AudioToggleMute() error {
 	if C.libcec_audio_toggle_mute(c.handle) == 0 {
-		return errors.New("failed to toggle mute")
+		return newError(ErrTransmitFailed, "failed to toggle mute")
 	}
 	return nil
 }
@@ -261,7 +340,7 @@ func (c *Connection) AudioToggleMute() error {
 // AudioMute mutes audio
 func (c *Connection) AudioMute() error {
 	if C.libcec_audio_mute(c.handle) == 0 {
-		return errors.New("failed to mute audio")
+		return newError(ErrTransmitFailed, "failed to mute audio")
 	}
 	return nil
 }
@@ -269,7 +348,7 @@ func (c *Connection) AudioMute() error {
 // AudioUnmute unmutes audio
 func (c *Connection) AudioUnmute() error {
 	if C.libcec_audio_unmute(c.handle) == 0 {
-		return errors.New("failed to unmute audio")
+		return newError(ErrTransmitFailed, "failed to unmute audio")
 	}
 	return nil
 }
@@ -278,7 +357,7 @@ func (c *Connection) AudioUnmute() error {
 func (c *Connection) GetDevicePowerStatus(address LogicalAddress) (PowerStatus, error) {
 	status := C.libcec_get_device_power_status(c.handle, C.cec_logical_address(address))
 	if status == C.CEC_POWER_STATUS_UNKNOWN {
-		return PowerStatusUnknown, errors.New("failed to get power status")
+		return PowerStatusUnknown, newError(ErrDeviceUnreachable, "failed to get power status")
 	}
 	return PowerStatus(status), nil
 }
@@ -298,7 +377,7 @@ func (c *Connection) IsActiveSource(address LogicalAddress) bool {
 func (c *Connection) GetDeviceVendorId(address LogicalAddress) (uint64, error) {
 	vendorId := C.libcec_get_device_vendor_id(c.handle, C.cec_logical_address(address))
 	if vendorId == C.CEC_VENDOR_UNKNOWN {
-		return 0, errors.New("failed to get vendor ID")
+		return 0, newError(ErrDeviceUnreachable, "failed to get vendor ID")
 	}
 	return uint64(vendorId), nil
 }
@@ -307,7 +386,7 @@ func (c *Connection) GetDeviceVendorId(address LogicalAddress) (uint64, error) {
 func (c *Connection) GetDevicePhysicalAddress(address LogicalAddress) (uint16, error) {
 	addr := C.libcec_get_device_physical_address(c.handle, C.cec_logical_address(address))
 	if addr == C.CEC_INVALID_PHYSICAL_ADDRESS {
-		return 0, errors.New("failed to get physical address")
+		return 0, newError(ErrDeviceUnreachable, "failed to get physical address")
 	}
 	return uint16(addr), nil
 }
@@ -316,7 +395,7 @@ func (c *Connection) GetDevicePhysicalAddress(address LogicalAddress) (uint16, e
 func (c *Connection) GetDeviceOSDName(address LogicalAddress) (string, error) {
 	var name [14]C.char
 	if C.libcec_get_device_osd_name(c.handle, C.cec_logical_address(address), &name[0]) == 0 {
-		return "", errors.New("failed to get OSD name")
+		return "", newError(ErrDeviceUnreachable, "failed to get OSD name")
 	}
 	return C.GoString(&name[0]), nil
 }
@@ -325,7 +404,7 @@ func (c *Connection) GetDeviceOSDName(address LogicalAddress) (string, error) {
 func (c *Connection) GetDeviceMenuLanguage(address LogicalAddress) (string, error) {
 	var lang [4]C.char
 	if C.libcec_get_device_menu_language(c.handle, C.cec_logical_address(address), &lang[0]) == 0 {
-		return "", errors.New("failed to get menu language")
+		return "", newError(ErrDeviceUnreachable, "failed to get menu language")
 	}
 	return C.GoString(&lang[0]), nil
 }
@@ -334,7 +413,7 @@ func (c *Connection) GetDeviceMenuLanguage(address LogicalAddress) (string, erro
 func (c *Connection) GetDeviceCecVersion(address LogicalAddress) (CECVersion, error) {
 	version := C.libcec_get_device_cec_version(c.handle, C.cec_logical_address(address))
 	if version == C.CEC_VERSION_UNKNOWN {
-		return CECVersionUnknown, errors.New("failed to get CEC version")
+		return CECVersionUnknown, newError(ErrDeviceUnreachable, "failed to get CEC version")
 	}
 	return CECVersion(version), nil
 }
@@ -357,23 +436,46 @@ func (c *Connection) IsActiveDevice(address LogicalAddress) bool {
 	return C.libcec_is_active_device(c.handle, C.cec_logical_address(address)) == 1
 }
 
-// Transmit sends a raw CEC command
+// Transmit sends a raw CEC command. The opcode is always sent, whatever
+// OpcodeSet says; use PingDevice to send a poll.
 func (c *Connection) Transmit(command *Command) error {
+	return c.transmit(command, true)
+}
+
+// transmit sends command, with its opcode only if withOpcode is set. A
+// frame without an opcode is a poll.
+func (c *Connection) transmit(command *Command, withOpcode bool) error {
 	cCmd := C.cec_command{}
 	cCmd.initiator = C.cec_logical_address(command.Initiator)
 	cCmd.destination = C.cec_logical_address(command.Destination)
 	cCmd.opcode = C.cec_opcode(command.Opcode)
-	cCmd.opcode_set = 1
+	if withOpcode {
+		cCmd.opcode_set = 1
+	}
 	cCmd.parameters.size = C.uint8_t(len(command.Parameters))
+	if command.TransmitTimeout > 0 {
+		cCmd.transmit_timeout = C.int32_t(command.TransmitTimeout)
+	}
 
 	for i, param := range command.Parameters {
 		cCmd.parameters.data[i] = C.uint8_t(param)
 	}
 
+	var err error
 	if C.libcec_transmit(c.handle, &cCmd) == 0 {
-		return errors.New("failed to transmit command")
+		err = newError(ErrTransmitFailed, "failed to transmit command")
 	}
-	return nil
+	if c.onTransmit != nil {
+		c.onTransmit(command, err)
+	}
+	return err
+}
+
+// OnTransmit registers fn to be called after every Transmit with the
+// command and its result. Register it before the connection is shared
+// between goroutines.
+func (c *Connection) OnTransmit(fn func(command *Command, err error)) {
+	c.onTransmit = fn
 }
 
 // SendKeypress sends a keypress
@@ -385,7 +487,7 @@ func (c *Connection) SendKeypress(address LogicalAddress, key Keycode, wait bool
 
 	if C.libcec_send_keypress(c.handle, C.cec_logical_address(address),
 		C.cec_user_control_code(key), waitVal) == 0 {
-		return errors.New("failed to send keypress")
+		return newError(ErrTransmitFailed, "failed to send keypress")
 	}
 	return nil
 }
@@ -398,19 +500,28 @@ func (c *Connection) SendKeyRelease(address LogicalAddress, wait bool) error {
 	}
 
 	if C.libcec_send_key_release(c.handle, C.cec_logical_address(address), waitVal) == 0 {
-		return errors.New("failed to send key release")
+		return newError(ErrTransmitFailed, "failed to send key release")
 	}
 	return nil
 }
 
-// SetOSDString sets an OSD string
+// SetOSDString sets an OSD string. The message must pass ValidateOSDString.
 func (c *Connection) SetOSDString(address LogicalAddress, duration DisplayControl, message string) error {
+	switch duration {
+	case DisplayControlDefaultTime, DisplayControlUntilCleared, DisplayControlClearPrevious:
+	default:
+		return newError(ErrInvalidArgument, "invalid display control 0x%02X", uint8(duration))
+	}
+	if err := ValidateOSDString(message); err != nil {
+		return err
+	}
+
 	cMsg := C.CString(message)
 	defer C.free(unsafe.Pointer(cMsg))
 
 	if C.libcec_set_osd_string(c.handle, C.cec_logical_address(address),
 		C.cec_display_control(duration), cMsg) == 0 {
-		return errors.New("failed to set OSD string")
+		return newError(ErrTransmitFailed, "failed to set OSD string")
 	}
 	return nil
 }
@@ -423,7 +534,7 @@ func (c *Connection) SwitchMonitoring(enable bool) error {
 	}
 
 	if C.libcec_switch_monitoring(c.handle, val) == 0 {
-		return errors.New("failed to switch monitoring mode")
+		return newError(ErrConfiguration, "failed to switch monitoring mode")
 	}
 	return nil
 }
@@ -435,21 +546,26 @@ func (c *Connection) GetLibInfo() string {
 
 // SetConfiguration updates the configuration
 func (c *Connection) SetConfiguration(config *Configuration) error {
+	// Start from the current configuration so settings not covered by
+	// Configuration (notably the callbacks) are preserved.
 	cConfig := C.libcec_configuration{}
-	C.libcec_clear_configuration(&cConfig)
+	if C.libcec_get_current_configuration(c.handle, &cConfig) == 0 {
+		C.libcec_clear_configuration(&cConfig)
+	}
 
-	cDeviceName := C.CString(config.DeviceName)
-	defer C.free(unsafe.Pointer(cDeviceName))
-	C.strncpy(&cConfig.strDeviceName[0], cDeviceName, 13)
+	if err := setDeviceName(&cConfig, config.DeviceName); err != nil {
+		return err
+	}
 
 	cConfig.deviceTypes.types[0] = C.cec_device_type(config.DeviceType)
 	cConfig.iPhysicalAddress = C.uint16_t(config.PhysicalAddress)
 	cConfig.baseDevice = C.cec_logical_address(config.BaseDevice)
 	cConfig.iHDMIPort = C.uint8_t(config.HDMIPort)
 	cConfig.clientVersion = C.uint32_t(config.ClientVersion)
+	setMenuLanguage(&cConfig, config.MenuLanguage)
 
 	if C.libcec_set_configuration(c.handle, &cConfig) == 0 {
-		return errors.New("failed to set configuration")
+		return newError(ErrConfiguration, "failed to set configuration")
 	}
 
 	c.config = config
@@ -460,7 +576,7 @@ func (c *Connection) SetConfiguration(config *Configuration) error {
 func (c *Connection) GetCurrentConfiguration() (*Configuration, error) {
 	var cConfig C.libcec_configuration
 	if C.libcec_get_current_configuration(c.handle, &cConfig) == 0 {
-		return nil, errors.New("failed to get current configuration")
+		return nil, newError(ErrConfiguration, "failed to get current configuration")
 	}
 
 	config := &Configuration{
@@ -471,21 +587,54 @@ func (c *Connection) GetCurrentConfiguration() (*Configuration, error) {
 		HDMIPort:        uint8(cConfig.iHDMIPort),
 		ClientVersion:   uint32(cConfig.clientVersion),
 		ServerVersion:   uint32(cConfig.serverVersion),
+		MenuLanguage:    strings.TrimRight(C.GoStringN(&cConfig.strDeviceLanguage[0], 3), "\x00"),
+
+		FirmwareVersion:   uint16(cConfig.iFirmwareVersion),
+		FirmwareBuildDate: uint32(cConfig.iFirmwareBuildDate),
 	}
 
 	return config, nil
 }
 
-// GetAudioStatus returns the current audio status from the audio system.
-// Returns volume level (0-100) and muted state.
+// setDeviceName validates name and copies it into strDeviceName, truncated
+// to MaxDeviceNameLength bytes on a UTF-8 character boundary.
+func setDeviceName(cConfig *C.libcec_configuration, name string) error {
+	if err := ValidateDeviceName(name); err != nil {
+		return err
+	}
+	name = TruncateDeviceName(name)
+	for i := range cConfig.strDeviceName {
+		cConfig.strDeviceName[i] = 0
+	}
+	for i := 0; i < len(name); i++ {
+		cConfig.strDeviceName[i] = C.char(name[i])
+	}
+	return nil
+}
+
+// setMenuLanguage copies an ISO 639-2 code into strDeviceLanguage, which
+// holds exactly three characters without a terminating NUL. An empty code
+// leaves libcec's default in place.
+func setMenuLanguage(cConfig *C.libcec_configuration, lang string) {
+	if lang == "" {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		var ch C.char
+		if i < len(lang) {
+			ch = C.char(lang[i])
+		}
+		cConfig.strDeviceLanguage[i] = ch
+	}
+}
+
+// GetAudioStatus asks the audio system for its volume (0-100) and mute
+// state using libcec_audio_get_status. It returns ErrAudioStatusUnknown
+// when libcec reports CEC_AUDIO_VOLUME_STATUS_UNKNOWN, e.g. because there
+// is no audio system. See DecodeAudioStatus for the byte layout.
 func (c *Connection) GetAudioStatus() (volume uint8, muted bool, err error) {
 	status := C.libcec_audio_get_status(c.handle)
-	// Return value is a uint8: bit 7 = muted, bits 0-6 = volume percentage.
-	// A return of 0 with no audio system present is indistinguishable from
-	// "volume 0, not muted", so we just return what libcec gives us.
-	muted = (status & 0x80) != 0
-	volume = uint8(status & 0x7F)
-	return volume, muted, nil
+	return DecodeAudioStatus(uint8(status))
 }
 
 // PollDevice sends a POLL message to check if a device is present on the bus.
@@ -497,18 +646,32 @@ func (c *Connection) PollDevice(address LogicalAddress) bool {
 // SetHDMIPort tells libcec to switch input on the base device to the given
 // HDMI port. baseDevice is typically LogicalAddressTV (0). This uses libcec's
 // built-in protocol handling which is more reliable than raw commands.
+// The port must be 1-15.
 func (c *Connection) SetHDMIPort(baseDevice LogicalAddress, port uint8) error {
+	if err := ValidateHDMIPort(port); err != nil {
+		return err
+	}
 	if C.libcec_set_hdmi_port(c.handle, C.cec_logical_address(baseDevice), C.uint8_t(port)) == 0 {
-		return errors.New("failed to set HDMI port")
+		return newError(ErrDeviceUnreachable, "libcec could not switch %s to HDMI port %d (the device may not be on the bus or the port may not exist)", baseDevice, port)
 	}
 	return nil
 }
 
+// DefaultRescanSettle is how long RescanDevices waits for devices to answer.
+const DefaultRescanSettle = 1 * time.Second
+
 // RescanDevices rescans for devices
 func (c *Connection) RescanDevices() error {
+	return c.RescanDevicesWithSettle(DefaultRescanSettle)
+}
+
+// RescanDevicesWithSettle rescans for devices and then waits settle for
+// them to respond before returning.
+func (c *Connection) RescanDevicesWithSettle(settle time.Duration) error {
 	C.libcec_rescan_devices(c.handle)
+	c.devices.InvalidateAll()
 	// Give devices time to respond
-	time.Sleep(1 * time.Second)
+	time.Sleep(settle)
 	return nil
 }
 
@@ -0,0 +1,81 @@
+package cec
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// fakeFinder returns a find function for collectAdapters that reports
+// total adapters and fills at most size of them, like libcec does.
+func fakeFinder(total int, sizes *[]int) func(size int) ([]Adapter, int) {
+	return func(size int) ([]Adapter, int) {
+		*sizes = append(*sizes, size)
+		n := total
+		if n < 0 {
+			return nil, total
+		}
+		if n > size {
+			n = size
+		}
+		adapters := make([]Adapter, n)
+		for i := range adapters {
+			adapters[i] = Adapter{Path: fmt.Sprintf("/dev/ttyACM%d", i), Comm: "RPi"}
+		}
+		return adapters, total
+	}
+}
+
+func TestCollectAdapters(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		want  int
+		sizes []int
+	}{
+		{"none", 0, 0, []int{initialAdapterBuffer}},
+		{"fits first pass", 3, 3, []int{initialAdapterBuffer}},
+		{"exactly fills first pass", initialAdapterBuffer, initialAdapterBuffer, []int{10, 20}},
+		{"more than the first buffer", 15, 15, []int{10, 20}},
+		{"grows to the reported count", 50, 50, []int{10, 50, 100}},
+		{"capped at MaxAdapters", 200, MaxAdapters, []int{10, MaxAdapters}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sizes []int
+			got, err := collectAdapters(fakeFinder(tt.total, &sizes))
+			if err != nil {
+				t.Fatalf("collectAdapters: %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d adapters, want %d", len(got), tt.want)
+			}
+			for i, a := range got {
+				if want := fmt.Sprintf("/dev/ttyACM%d", i); a.Path != want {
+					t.Errorf("adapter %d path = %q, want %q", i, a.Path, want)
+				}
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.sizes) {
+				t.Errorf("buffer sizes = %v, want %v", sizes, tt.sizes)
+			}
+		})
+	}
+}
+
+func TestCollectAdaptersError(t *testing.T) {
+	var sizes []int
+	if _, err := collectAdapters(fakeFinder(-1, &sizes)); !errors.Is(err, ErrAdapterUnavailable) {
+		t.Errorf("err = %v, want ErrAdapterUnavailable", err)
+	}
+}
+
+func TestSetHDMIPortRejectsInvalidPorts(t *testing.T) {
+	// Invalid ports are rejected before libcec is called, so a zero
+	// Connection is enough.
+	c := &Connection{}
+	for _, port := range []uint8{0, MaxHDMIPort + 1, 255} {
+		if err := c.SetHDMIPort(LogicalAddressTV, port); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("SetHDMIPort(TV, %d) = %v, want ErrInvalidArgument", port, err)
+		}
+	}
+}
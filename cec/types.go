@@ -4,6 +4,10 @@ package cec
 #include <libcec/cecc.h>
 */
 import "C"
+import (
+	"fmt"
+	"strings"
+)
 
 // LogicalAddress represents a CEC logical address (0-15)
 type LogicalAddress uint8
@@ -90,6 +94,53 @@ func (d DeviceType) String() string {
 	}
 }
 
+// deviceTypesByName maps the names ParseDeviceType accepts to device types.
+var deviceTypesByName = map[string]DeviceType{
+	"tv":               DeviceTypeTV,
+	"recording":        DeviceTypeRecordingDevice,
+	"recording_device": DeviceTypeRecordingDevice,
+	"tuner":            DeviceTypeTuner,
+	"playback":         DeviceTypePlaybackDevice,
+	"playback_device":  DeviceTypePlaybackDevice,
+	"audio":            DeviceTypeAudioSystem,
+	"audio_system":     DeviceTypeAudioSystem,
+}
+
+// ParseDeviceType looks up a device type by name, case-insensitively.
+// It accepts snake_case names such as "playback_device" or "playback" as
+// well as the String form ("Playback Device"). Reserved is not accepted.
+func ParseDeviceType(name string) (DeviceType, bool) {
+	key := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+	t, ok := deviceTypesByName[key]
+	return t, ok
+}
+
+// WakeMode selects the opcode used to wake the TV.
+type WakeMode uint8
+
+const (
+	WakeImageViewOn WakeMode = iota // Image View On (0x04)
+	WakeTextViewOn                  // Text View On (0x0D)
+)
+
+func (m WakeMode) String() string {
+	if m == WakeTextViewOn {
+		return "text_view_on"
+	}
+	return "image_view_on"
+}
+
+// ParseWakeMode looks up a wake mode by its String form, case-insensitively.
+func ParseWakeMode(name string) (WakeMode, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "image_view_on":
+		return WakeImageViewOn, true
+	case "text_view_on":
+		return WakeTextViewOn, true
+	}
+	return WakeImageViewOn, false
+}
+
 // PowerStatus represents device power status
 type PowerStatus uint8
 
@@ -207,12 +258,88 @@ const (
 	OpcodeGiveAudioStatus            Opcode = 0x71
 	OpcodeGiveSystemAudioModeStatus  Opcode = 0x7D
 	OpcodeReportAudioStatus          Opcode = 0x7A
+	OpcodeSetAudioVolumeLevel        Opcode = 0x73
 	OpcodeSetSystemAudioMode         Opcode = 0x72
 	OpcodeSystemAudioModeRequest     Opcode = 0x70
 	OpcodeSystemAudioModeStatus      Opcode = 0x7E
 	OpcodeSetAudioRate               Opcode = 0x9A
 )
 
+var opcodeNames = map[Opcode]string{
+	OpcodeActiveSource:              "ActiveSource",
+	OpcodeImageViewOn:               "ImageViewOn",
+	OpcodeTextViewOn:                "TextViewOn",
+	OpcodeInactiveSource:            "InactiveSource",
+	OpcodeRequestActiveSource:       "RequestActiveSource",
+	OpcodeRoutingChange:             "RoutingChange",
+	OpcodeRoutingInformation:        "RoutingInformation",
+	OpcodeSetStreamPath:             "SetStreamPath",
+	OpcodeStandby:                   "Standby",
+	OpcodeRecordOff:                 "RecordOff",
+	OpcodeRecordOn:                  "RecordOn",
+	OpcodeRecordStatus:              "RecordStatus",
+	OpcodeRecordTVScreen:            "RecordTVScreen",
+	OpcodeClearAnalogueTimer:        "ClearAnalogueTimer",
+	OpcodeClearDigitalTimer:         "ClearDigitalTimer",
+	OpcodeClearExternalTimer:        "ClearExternalTimer",
+	OpcodeSetAnalogueTimer:          "SetAnalogueTimer",
+	OpcodeSetDigitalTimer:           "SetDigitalTimer",
+	OpcodeSetExternalTimer:          "SetExternalTimer",
+	OpcodeSetTimerProgramTitle:      "SetTimerProgramTitle",
+	OpcodeTimerClearedStatus:        "TimerClearedStatus",
+	OpcodeTimerStatus:               "TimerStatus",
+	OpcodeCECVersion:                "CECVersion",
+	OpcodeGetCECVersion:             "GetCECVersion",
+	OpcodeGivePhysicalAddress:       "GivePhysicalAddress",
+	OpcodeGetMenuLanguage:           "GetMenuLanguage",
+	OpcodeReportPhysicalAddress:     "ReportPhysicalAddress",
+	OpcodeSetMenuLanguage:           "SetMenuLanguage",
+	OpcodeDeckControl:               "DeckControl",
+	OpcodeDeckStatus:                "DeckStatus",
+	OpcodeGiveDeckStatus:            "GiveDeckStatus",
+	OpcodePlay:                      "Play",
+	OpcodeGiveTunerDeviceStatus:     "GiveTunerDeviceStatus",
+	OpcodeSelectAnalogueService:     "SelectAnalogueService",
+	OpcodeSelectDigitalService:      "SelectDigitalService",
+	OpcodeTunerDeviceStatus:         "TunerDeviceStatus",
+	OpcodeTunerStepDecrement:        "TunerStepDecrement",
+	OpcodeTunerStepIncrement:        "TunerStepIncrement",
+	OpcodeDeviceVendorID:            "DeviceVendorID",
+	OpcodeGiveDeviceVendorID:        "GiveDeviceVendorID",
+	OpcodeVendorCommand:             "VendorCommand",
+	OpcodeVendorCommandWithID:       "VendorCommandWithID",
+	OpcodeVendorRemoteButtonDown:    "VendorRemoteButtonDown",
+	OpcodeVendorRemoteButtonUp:      "VendorRemoteButtonUp",
+	OpcodeSetOSDString:              "SetOSDString",
+	OpcodeGiveOSDName:               "GiveOSDName",
+	OpcodeSetOSDName:                "SetOSDName",
+	OpcodeMenuRequest:               "MenuRequest",
+	OpcodeMenuStatus:                "MenuStatus",
+	OpcodeUserControlPressed:        "UserControlPressed",
+	OpcodeUserControlReleased:       "UserControlReleased",
+	OpcodeGiveDevicePowerStatus:     "GiveDevicePowerStatus",
+	OpcodeReportPowerStatus:         "ReportPowerStatus",
+	OpcodeFeatureAbort:              "FeatureAbort",
+	OpcodeAbort:                     "Abort",
+	OpcodeGiveAudioStatus:           "GiveAudioStatus",
+	OpcodeGiveSystemAudioModeStatus: "GiveSystemAudioModeStatus",
+	OpcodeReportAudioStatus:         "ReportAudioStatus",
+	OpcodeSetAudioVolumeLevel:       "SetAudioVolumeLevel",
+	OpcodeSetSystemAudioMode:        "SetSystemAudioMode",
+	OpcodeSystemAudioModeRequest:    "SystemAudioModeRequest",
+	OpcodeSystemAudioModeStatus:     "SystemAudioModeStatus",
+	OpcodeSetAudioRate:              "SetAudioRate",
+}
+
+// String returns the opcode's name, such as "ReportPowerStatus", or
+// "Unknown(0xNN)" for opcodes without a constant.
+func (o Opcode) String() string {
+	if name, ok := opcodeNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(0x%02X)", uint8(o))
+}
+
 // Keycode represents CEC user control codes
 type Keycode uint8
 
@@ -275,6 +402,187 @@ const (
 	KeycodeF5                       Keycode = 0x75
 )
 
+// keycodeNames holds the canonical name of each user control code, as
+// returned by Keycode.Name and accepted by KeycodeByName.
+var keycodeNames = map[Keycode]string{
+	KeycodeSelect:             "select",
+	KeycodeUp:                 "up",
+	KeycodeDown:               "down",
+	KeycodeLeft:               "left",
+	KeycodeRight:              "right",
+	KeycodeRightUp:            "right_up",
+	KeycodeRightDown:          "right_down",
+	KeycodeLeftUp:             "left_up",
+	KeycodeLeftDown:           "left_down",
+	KeycodeRootMenu:           "root_menu",
+	KeycodeSetupMenu:          "setup_menu",
+	KeycodeContentsMenu:       "contents_menu",
+	KeycodeFavoriteMenu:       "favorite_menu",
+	KeycodeExit:               "exit",
+	Keycode0:                  "0",
+	Keycode1:                  "1",
+	Keycode2:                  "2",
+	Keycode3:                  "3",
+	Keycode4:                  "4",
+	Keycode5:                  "5",
+	Keycode6:                  "6",
+	Keycode7:                  "7",
+	Keycode8:                  "8",
+	Keycode9:                  "9",
+	KeycodeDot:                "dot",
+	KeycodeEnter:              "enter",
+	KeycodeClear:              "clear",
+	KeycodeChannelUp:          "channel_up",
+	KeycodeChannelDown:        "channel_down",
+	KeycodePreviousChannel:    "previous_channel",
+	KeycodeSoundSelect:        "sound_select",
+	KeycodeInputSelect:        "input_select",
+	KeycodeDisplayInformation: "display_information",
+	KeycodeHelp:               "help",
+	KeycodePageUp:             "page_up",
+	KeycodePageDown:           "page_down",
+	KeycodePower:              "power",
+	KeycodeVolumeUp:           "volume_up",
+	KeycodeVolumeDown:         "volume_down",
+	KeycodeMute:               "mute",
+	KeycodePlay:               "play",
+	KeycodeStop:               "stop",
+	KeycodePause:              "pause",
+	KeycodeRecord:             "record",
+	KeycodeRewind:             "rewind",
+	KeycodeFastForward:        "fast_forward",
+	KeycodeEject:              "eject",
+	KeycodeForward:            "forward",
+	KeycodeBackward:           "backward",
+	KeycodeAngle:              "angle",
+	KeycodeSubpicture:         "subpicture",
+	KeycodeF1Blue:             "blue",
+	KeycodeF2Red:              "red",
+	KeycodeF3Green:            "green",
+	KeycodeF4Yellow:           "yellow",
+	KeycodeF5:                 "f5",
+}
+
+// keycodeAliases are extra names KeycodeByName accepts. back, home and menu
+// are the names the HTTP and MQTT key commands have always used.
+var keycodeAliases = map[string]Keycode{
+	"back": KeycodeExit,
+	"home": KeycodeRootMenu,
+	"menu": KeycodeSetupMenu,
+	"f1":   KeycodeF1Blue,
+	"f2":   KeycodeF2Red,
+	"f3":   KeycodeF3Green,
+	"f4":   KeycodeF4Yellow,
+}
+
+var keycodesByName = func() map[string]Keycode {
+	m := make(map[string]Keycode, len(keycodeNames)+len(keycodeAliases))
+	for k, name := range keycodeNames {
+		m[name] = k
+	}
+	for name, k := range keycodeAliases {
+		m[name] = k
+	}
+	return m
+}()
+
+// KeycodeByName looks up a user control code by name, such as "select",
+// "channel_up", "5" or "red". Names are case-insensitive.
+func KeycodeByName(name string) (Keycode, bool) {
+	k, ok := keycodesByName[strings.ToLower(name)]
+	return k, ok
+}
+
+// Name returns the canonical name of the keycode, or "" if it has none.
+func (k Keycode) Name() string {
+	return keycodeNames[k]
+}
+
+// AbortReason is the reason operand of a Feature Abort message
+type AbortReason uint8
+
+const (
+	AbortReasonUnrecognizedOpcode  AbortReason = 0x00
+	AbortReasonNotInCorrectMode    AbortReason = 0x01
+	AbortReasonCannotProvideSource AbortReason = 0x02
+	AbortReasonInvalidOperand      AbortReason = 0x03
+	AbortReasonRefused             AbortReason = 0x04
+	AbortReasonUnableToDetermine   AbortReason = 0x05
+)
+
+func (r AbortReason) String() string {
+	switch r {
+	case AbortReasonUnrecognizedOpcode:
+		return "unrecognized opcode"
+	case AbortReasonNotInCorrectMode:
+		return "not in correct mode to respond"
+	case AbortReasonCannotProvideSource:
+		return "cannot provide source"
+	case AbortReasonInvalidOperand:
+		return "invalid operand"
+	case AbortReasonRefused:
+		return "refused"
+	case AbortReasonUnableToDetermine:
+		return "unable to determine"
+	default:
+		return "unknown"
+	}
+}
+
+// FeatureAbort is a decoded Feature Abort message: a device declining to
+// act on a message it received. It implements error so it can be returned
+// as the cause of a failed operation.
+type FeatureAbort struct {
+	Initiator LogicalAddress // device that refused
+	Opcode    Opcode         // opcode of the message that was refused
+	Reason    AbortReason
+}
+
+func (f *FeatureAbort) Error() string {
+	return fmt.Sprintf("%s refused opcode 0x%02X: %s", f.Initiator.String(), uint8(f.Opcode), f.Reason.String())
+}
+
+// ParseFeatureAbort decodes a Feature Abort command. It returns false if
+// command is not a well-formed Feature Abort.
+func ParseFeatureAbort(command *Command) (*FeatureAbort, bool) {
+	if command.Opcode != OpcodeFeatureAbort || !command.OpcodeSet || len(command.Parameters) < 2 {
+		return nil, false
+	}
+	return &FeatureAbort{
+		Initiator: command.Initiator,
+		Opcode:    Opcode(command.Parameters[0]),
+		Reason:    AbortReason(command.Parameters[1]),
+	}, true
+}
+
+// RoutingChange is a decoded Routing Change (0x80), broadcast by a switch
+// (often the TV) when its active input changes.
+type RoutingChange struct {
+	Initiator LogicalAddress
+	From      uint16 // physical address of the previously active input
+	To        uint16 // physical address of the newly active input
+}
+
+// FromPort returns the TV HDMI port of From, or 0 if it isn't behind one.
+func (r *RoutingChange) FromPort() uint8 { return uint8(r.From >> 12) }
+
+// ToPort returns the TV HDMI port of To, or 0 if it isn't behind one.
+func (r *RoutingChange) ToPort() uint8 { return uint8(r.To >> 12) }
+
+// ParseRoutingChange decodes a Routing Change command. It returns false if
+// command is not a well-formed Routing Change.
+func ParseRoutingChange(command *Command) (*RoutingChange, bool) {
+	if command.Opcode != OpcodeRoutingChange || !command.OpcodeSet || len(command.Parameters) < 4 {
+		return nil, false
+	}
+	p := command.Parameters
+	return &RoutingChange{
+		Initiator: command.Initiator,
+		From:      uint16(p[0])<<8 | uint16(p[1]),
+		To:        uint16(p[2])<<8 | uint16(p[3]),
+	}, true
+}
+
 // DisplayControl represents OSD display duration
 type DisplayControl uint8
 
@@ -285,6 +593,42 @@ const (
 	DisplayControlReserved       DisplayControl = 0xC0
 )
 
+// AudioRate is the operand of Set Audio Rate (0x9A), which asks an audio
+// system to adjust its sample rate to match a source running slightly fast
+// or slow. WRC is wide-range control (up to 1%), NRC narrow-range (0.1%).
+type AudioRate uint8
+
+const (
+	AudioRateOff            AudioRate = 0x00
+	AudioRateWideStandard   AudioRate = 0x01 // WRC 100%
+	AudioRateWideFast       AudioRate = 0x02 // WRC max 101%
+	AudioRateWideSlow       AudioRate = 0x03 // WRC min 99%
+	AudioRateNarrowStandard AudioRate = 0x04 // NRC 100%
+	AudioRateNarrowFast     AudioRate = 0x05 // NRC max 100.1%
+	AudioRateNarrowSlow     AudioRate = 0x06 // NRC min 99.9%
+)
+
+func (r AudioRate) String() string {
+	switch r {
+	case AudioRateOff:
+		return "Rate Control Off"
+	case AudioRateWideStandard:
+		return "Wide Range Standard"
+	case AudioRateWideFast:
+		return "Wide Range Fast"
+	case AudioRateWideSlow:
+		return "Wide Range Slow"
+	case AudioRateNarrowStandard:
+		return "Narrow Range Standard"
+	case AudioRateNarrowFast:
+		return "Narrow Range Fast"
+	case AudioRateNarrowSlow:
+		return "Narrow Range Slow"
+	default:
+		return "Unknown"
+	}
+}
+
 // MenuState represents menu state
 type MenuState uint8
 
@@ -322,6 +666,33 @@ func (l LogLevel) String() string {
 	}
 }
 
+// Includes reports whether a message at level m passes a minimum level of
+// l. libcec levels get less severe as their values grow, so
+// LogLevelWarning includes errors and warnings and LogLevelAll includes
+// everything.
+func (l LogLevel) Includes(m LogLevel) bool {
+	return m <= l
+}
+
+// ParseLogLevel looks up a log level by its String form, case-insensitively.
+func ParseLogLevel(name string) (LogLevel, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "error":
+		return LogLevelError, true
+	case "warning":
+		return LogLevelWarning, true
+	case "notice":
+		return LogLevelNotice, true
+	case "traffic":
+		return LogLevelTraffic, true
+	case "debug":
+		return LogLevelDebug, true
+	case "all":
+		return LogLevelAll, true
+	}
+	return LogLevelAll, false
+}
+
 // Alert represents CEC alert type
 type Alert int
 
@@ -342,16 +713,97 @@ type Parameter struct {
 
 // Command represents a CEC command
 type Command struct {
-	Initiator    LogicalAddress
-	Destination  LogicalAddress
-	Ack          bool
-	Eom          bool
-	Opcode       Opcode
-	OpcodeSet    bool
-	Parameters   []uint8
+	Initiator   LogicalAddress
+	Destination LogicalAddress
+	// Ack is true when the frame was acknowledged. For directly addressed
+	// frames that means the destination accepted it; broadcasts are never
+	// acknowledged.
+	Ack bool
+	// Eom is true when this frame carried the end-of-message bit.
+	Eom        bool
+	Opcode     Opcode
+	OpcodeSet  bool
+	Parameters []uint8
+	// TransmitTimeout is libcec's transmit_timeout in milliseconds: how long
+	// Transmit waits for the frame to be acknowledged (0 uses libcec's
+	// default). libcec doesn't timestamp received frames, so on commands
+	// passed to OnCommand it only echoes the timeout libcec filled in.
+	TransmitTimeout int64
+	// TransmitTime holds the same value as TransmitTimeout on commands
+	// passed to OnCommand; Transmit ignores it.
+	//
+	// Deprecated: it was never a timestamp. Use TransmitTimeout.
 	TransmitTime int64
 }
 
+// operandCount is the number of parameter bytes an opcode takes.
+type operandCount struct{ min, max int }
+
+// opcodeOperands lists the operand counts of opcodes with a fixed or
+// bounded frame layout. Opcodes not listed accept 0-MaxFrameParameters.
+var opcodeOperands = map[Opcode]operandCount{
+	OpcodeActiveSource:          {2, 2},
+	OpcodeInactiveSource:        {2, 2},
+	OpcodeSetStreamPath:         {2, 2},
+	OpcodeRoutingChange:         {4, 4},
+	OpcodeRoutingInformation:    {2, 2},
+	OpcodeReportPhysicalAddress: {3, 3},
+	OpcodeReportPowerStatus:     {1, 1},
+	OpcodeCECVersion:            {1, 1},
+	OpcodeDeviceVendorID:        {3, 3},
+	OpcodeSetMenuLanguage:       {3, 3},
+	OpcodeFeatureAbort:          {2, 2},
+	OpcodeUserControlPressed:    {1, MaxFrameParameters},
+	OpcodeSetOSDName:            {1, MaxFrameParameters},
+	OpcodeSetOSDString:          {1, MaxFrameParameters},
+	OpcodeVendorCommandWithID:   {3, MaxFrameParameters},
+	OpcodeSetAudioVolumeLevel:   {1, 1},
+	OpcodeReportAudioStatus:     {1, 1},
+	OpcodeSetAudioRate:          {1, 1},
+
+	OpcodeImageViewOn:           {0, 0},
+	OpcodeTextViewOn:            {0, 0},
+	OpcodeRequestActiveSource:   {0, 0},
+	OpcodeStandby:               {0, 0},
+	OpcodeGetCECVersion:         {0, 0},
+	OpcodeGivePhysicalAddress:   {0, 0},
+	OpcodeGetMenuLanguage:       {0, 0},
+	OpcodeGiveDeviceVendorID:    {0, 0},
+	OpcodeGiveOSDName:           {0, 0},
+	OpcodeGiveDevicePowerStatus: {0, 0},
+	OpcodeGiveAudioStatus:       {0, 0},
+	OpcodeUserControlReleased:   {0, 0},
+	OpcodeAbort:                 {0, 0},
+}
+
+// Validate checks that the command fits in one CEC frame and, for opcodes
+// with a known layout, has the right number of parameter bytes.
+func (cmd *Command) Validate() error {
+	if cmd.Initiator > LogicalAddressBroadcast {
+		return newError(ErrInvalidArgument, "invalid initiator %d (must be 0-15)", cmd.Initiator)
+	}
+	if cmd.Destination > LogicalAddressBroadcast {
+		return newError(ErrInvalidArgument, "invalid destination %d (must be 0-15)", cmd.Destination)
+	}
+	n := len(cmd.Parameters)
+	if !cmd.OpcodeSet {
+		if n > 0 {
+			return newError(ErrInvalidArgument, "a frame without an opcode cannot carry parameters")
+		}
+		return nil
+	}
+	if n > MaxFrameParameters {
+		return newError(ErrInvalidArgument, "too many parameter bytes: %d (max %d)", n, MaxFrameParameters)
+	}
+	if want, ok := opcodeOperands[cmd.Opcode]; ok && (n < want.min || n > want.max) {
+		if want.min == want.max {
+			return newError(ErrInvalidArgument, "opcode 0x%02X takes %d parameter bytes, got %d", uint8(cmd.Opcode), want.min, n)
+		}
+		return newError(ErrInvalidArgument, "opcode 0x%02X takes %d-%d parameter bytes, got %d", uint8(cmd.Opcode), want.min, want.max, n)
+	}
+	return nil
+}
+
 // Adapter represents a CEC adapter
 type Adapter struct {
 	Path string
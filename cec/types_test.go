@@ -0,0 +1,153 @@
+package cec
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeycodeNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		keycode Keycode
+	}{
+		{"select", 0x00},
+		{"up", 0x01},
+		{"down", 0x02},
+		{"left", 0x03},
+		{"right", 0x04},
+		{"right_up", 0x05},
+		{"right_down", 0x06},
+		{"left_up", 0x07},
+		{"left_down", 0x08},
+		{"root_menu", 0x09},
+		{"setup_menu", 0x0A},
+		{"contents_menu", 0x0B},
+		{"favorite_menu", 0x0C},
+		{"exit", 0x0D},
+		{"0", 0x20},
+		{"1", 0x21},
+		{"2", 0x22},
+		{"3", 0x23},
+		{"4", 0x24},
+		{"5", 0x25},
+		{"6", 0x26},
+		{"7", 0x27},
+		{"8", 0x28},
+		{"9", 0x29},
+		{"dot", 0x2A},
+		{"enter", 0x2B},
+		{"clear", 0x2C},
+		{"channel_up", 0x30},
+		{"channel_down", 0x31},
+		{"previous_channel", 0x32},
+		{"sound_select", 0x33},
+		{"input_select", 0x34},
+		{"display_information", 0x35},
+		{"help", 0x36},
+		{"page_up", 0x37},
+		{"page_down", 0x38},
+		{"power", 0x40},
+		{"volume_up", 0x41},
+		{"volume_down", 0x42},
+		{"mute", 0x43},
+		{"play", 0x44},
+		{"stop", 0x45},
+		{"pause", 0x46},
+		{"record", 0x47},
+		{"rewind", 0x48},
+		{"fast_forward", 0x49},
+		{"eject", 0x4A},
+		{"forward", 0x4B},
+		{"backward", 0x4C},
+		{"angle", 0x50},
+		{"subpicture", 0x51},
+		{"blue", 0x71},
+		{"red", 0x72},
+		{"green", 0x73},
+		{"yellow", 0x74},
+		{"f5", 0x75},
+	}
+	if len(tests) != len(keycodeNames) {
+		t.Errorf("table has %d keycodes, keycodeNames has %d", len(tests), len(keycodeNames))
+	}
+	for _, tt := range tests {
+		if got := tt.keycode.Name(); got != tt.name {
+			t.Errorf("Keycode(0x%02X).Name() = %q, want %q", uint8(tt.keycode), got, tt.name)
+		}
+		if got, ok := KeycodeByName(tt.name); !ok || got != tt.keycode {
+			t.Errorf("KeycodeByName(%q) = 0x%02X, %v, want 0x%02X", tt.name, uint8(got), ok, uint8(tt.keycode))
+		}
+	}
+}
+
+func TestKeycodeByNameAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		keycode Keycode
+	}{
+		{"back", 0x0D},
+		{"home", 0x09},
+		{"menu", 0x0A},
+		{"f1", 0x71},
+		{"f2", 0x72},
+		{"f3", 0x73},
+		{"f4", 0x74},
+	}
+	for _, tt := range tests {
+		if got, ok := KeycodeByName(tt.name); !ok || got != tt.keycode {
+			t.Errorf("KeycodeByName(%q) = 0x%02X, %v, want 0x%02X", tt.name, uint8(got), ok, uint8(tt.keycode))
+		}
+	}
+}
+
+func TestKeycodeByNameCaseAndUnknown(t *testing.T) {
+	if got, ok := KeycodeByName("Channel_Up"); !ok || got != KeycodeChannelUp {
+		t.Errorf("KeycodeByName(%q) = 0x%02X, %v, want ChannelUp", "Channel_Up", uint8(got), ok)
+	}
+	for _, name := range []string{"", "nope", "channel up", "f6"} {
+		if _, ok := KeycodeByName(name); ok {
+			t.Errorf("KeycodeByName(%q) found a keycode", name)
+		}
+	}
+	if name := Keycode(0x7F).Name(); name != "" {
+		t.Errorf("Keycode(0x7F).Name() = %q, want \"\"", name)
+	}
+}
+
+func TestCommandValidate(t *testing.T) {
+	bytes := func(n int) []uint8 { return make([]uint8, n) }
+	tests := []struct {
+		name string
+		cmd  Command
+		ok   bool
+	}{
+		{"image view on", Command{Destination: LogicalAddressTV, Opcode: OpcodeImageViewOn, OpcodeSet: true}, true},
+		{"image view on with operand", Command{Opcode: OpcodeImageViewOn, OpcodeSet: true, Parameters: bytes(1)}, false},
+		{"active source", Command{Destination: LogicalAddressBroadcast, Opcode: OpcodeActiveSource, OpcodeSet: true, Parameters: []uint8{0x10, 0x00}}, true},
+		{"active source short", Command{Opcode: OpcodeActiveSource, OpcodeSet: true, Parameters: []uint8{0x10}}, false},
+		{"active source long", Command{Opcode: OpcodeActiveSource, OpcodeSet: true, Parameters: bytes(3)}, false},
+		{"routing change", Command{Opcode: OpcodeRoutingChange, OpcodeSet: true, Parameters: bytes(4)}, true},
+		{"report physical address short", Command{Opcode: OpcodeReportPhysicalAddress, OpcodeSet: true, Parameters: bytes(2)}, false},
+		{"set osd name", Command{Opcode: OpcodeSetOSDName, OpcodeSet: true, Parameters: []uint8("capi")}, true},
+		{"set osd name empty", Command{Opcode: OpcodeSetOSDName, OpcodeSet: true}, false},
+		{"vendor command with id", Command{Opcode: OpcodeVendorCommandWithID, OpcodeSet: true, Parameters: bytes(MaxFrameParameters)}, true},
+		{"vendor command without id", Command{Opcode: OpcodeVendorCommandWithID, OpcodeSet: true, Parameters: bytes(2)}, false},
+		{"unlisted opcode, 14 bytes", Command{Opcode: Opcode(0x89), OpcodeSet: true, Parameters: bytes(14)}, true},
+		{"unlisted opcode, 15 bytes", Command{Opcode: Opcode(0x89), OpcodeSet: true, Parameters: bytes(15)}, false},
+		{"poll", Command{Initiator: LogicalAddressPlaybackDevice1, Destination: LogicalAddressTV}, true},
+		{"poll with parameters", Command{Destination: LogicalAddressTV, Parameters: bytes(1)}, false},
+		{"initiator 16", Command{Initiator: 16, Opcode: OpcodeStandby, OpcodeSet: true}, false},
+		{"destination 16", Command{Destination: 16, Opcode: OpcodeStandby, OpcodeSet: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cmd.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("Validate() = %v, want ErrInvalidArgument", err)
+			}
+		})
+	}
+}
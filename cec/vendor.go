@@ -0,0 +1,96 @@
+package cec
+
+import "fmt"
+
+// Vendor Command With ID (0xA0) frames carry a 3-byte vendor ID followed by
+// vendor-specific data, all within the 14 operand bytes of a single CEC
+// frame. CEC itself does not define how longer vendor commands are split:
+// each vendor has its own scheme, so callers that need more than one frame
+// build the frames themselves and SendVendorCommandWithID sends them in
+// order. Received frames are reported one at a time by ParseVendorCommand.
+const (
+	// MaxFrameParameters is the number of operand bytes in one CEC frame.
+	MaxFrameParameters = 14
+	// MaxVendorFrameData is the vendor data that fits in one Vendor
+	// Command With ID frame, after the vendor ID.
+	MaxVendorFrameData = MaxFrameParameters - 3
+	// MaxVendorFrames is the most frames SendVendorCommandWithID sends
+	// in one call.
+	MaxVendorFrames = 32
+)
+
+// VendorCommand is a single Vendor Command With ID frame.
+type VendorCommand struct {
+	Initiator   LogicalAddress
+	Destination LogicalAddress
+	VendorID    uint32
+	Payload     []uint8
+}
+
+// VendorCommandParameters checks a vendor ID and the vendor data of each
+// frame and returns the parameter list of every Vendor Command With ID
+// frame, in order.
+func VendorCommandParameters(vendorID uint32, frames [][]uint8) ([][]uint8, error) {
+	if vendorID > 0xFFFFFF {
+		return nil, newError(ErrInvalidArgument, "invalid vendor ID 0x%X (must be 24-bit)", vendorID)
+	}
+	if len(frames) == 0 {
+		return nil, newError(ErrInvalidArgument, "no vendor command frames")
+	}
+	if len(frames) > MaxVendorFrames {
+		return nil, newError(ErrInvalidArgument, "too many vendor command frames: %d (max %d)", len(frames), MaxVendorFrames)
+	}
+
+	params := make([][]uint8, len(frames))
+	for i, data := range frames {
+		if len(data) > MaxVendorFrameData {
+			return nil, newError(ErrInvalidArgument, "vendor command frame %d too long: %d bytes (max %d)", i+1, len(data), MaxVendorFrameData)
+		}
+		p := make([]uint8, 0, 3+len(data))
+		p = append(p, uint8(vendorID>>16), uint8(vendorID>>8), uint8(vendorID))
+		params[i] = append(p, data...)
+	}
+	return params, nil
+}
+
+// SendVendorCommandWithID sends one Vendor Command With ID frame per entry
+// of frames, in order, each carrying the vendor ID followed by that entry's
+// data. Splitting a longer vendor command into frames is up to the caller,
+// as the scheme is vendor-specific.
+func (c *Connection) SendVendorCommandWithID(destination LogicalAddress, vendorID uint32, frames [][]uint8) error {
+	params, err := VendorCommandParameters(vendorID, frames)
+	if err != nil {
+		return err
+	}
+
+	initiator := c.getOwnAddress()
+	for i, p := range params {
+		cmd := &Command{
+			Initiator:   initiator,
+			Destination: destination,
+			Opcode:      OpcodeVendorCommandWithID,
+			OpcodeSet:   true,
+			Parameters:  p,
+		}
+		if err := c.Transmit(cmd); err != nil {
+			return fmt.Errorf("vendor command frame %d of %d: %w", i+1, len(params), err)
+		}
+	}
+	return nil
+}
+
+// ParseVendorCommand returns the vendor ID and data of a received Vendor
+// Command With ID frame. It returns false for other commands and for
+// frames too short to hold a vendor ID.
+func ParseVendorCommand(command *Command) (*VendorCommand, bool) {
+	if !command.OpcodeSet || command.Opcode != OpcodeVendorCommandWithID || len(command.Parameters) < 3 {
+		return nil, false
+	}
+	params := command.Parameters
+	return &VendorCommand{
+		Initiator:   command.Initiator,
+		Destination: command.Destination,
+		VendorID:    uint32(params[0])<<16 | uint32(params[1])<<8 | uint32(params[2]),
+		Payload:     append([]uint8(nil), params[3:]...),
+	}, true
+}
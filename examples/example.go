@@ -24,9 +24,10 @@ func (h *ExampleHandler) OnKeyPress(key cec.Keycode, duration uint32) {
 }
 
 func (h *ExampleHandler) OnCommand(command *cec.Command) {
-	fmt.Printf("Command: %s -> %s, opcode: 0x%02X\n",
+	fmt.Printf("Command: %s -> %s, opcode: 0x%02X (%s)\n",
 		command.Initiator.String(),
 		command.Destination.String(),
+		uint8(command.Opcode),
 		command.Opcode)
 }
 
@@ -5,14 +5,15 @@ set -e
 ARCH=$(uname -m)
 case "$ARCH" in
   aarch64)       BINARY="capi-linux-arm64" ;;
-  armv7l|armv6l) BINARY="capi-linux-armv6" ;;
+  armv7l)        BINARY="capi-linux-armv7" ;;
+  armv6l)        BINARY="capi-linux-armv6" ;;
   *) echo "Unsupported architecture: $ARCH"; exit 1 ;;
 esac
 
 echo "Detected architecture: $ARCH -> $BINARY"
 
 # ── Get latest release download URL from GitHub API ───────────────────
-REPO="LukasParke/capi"
+REPO="${CAPI_UPDATE_REPO:-LukasParke/capi}"
 echo "Fetching latest release info..."
 RELEASE_JSON=$(curl -sSL --connect-timeout 10 --max-time 30 \
   "https://api.github.com/repos/$REPO/releases/latest")
@@ -66,6 +67,11 @@ download() {
 }
 
 BINARY_URL=$(asset_url "$BINARY")
+if [ -z "$BINARY_URL" ] && [ "$BINARY" = "capi-linux-armv7" ]; then
+  # Releases before the armv7 build only have armv6, which also runs here
+  BINARY="capi-linux-armv6"
+  BINARY_URL=$(asset_url "$BINARY")
+fi
 if [ -z "$BINARY_URL" ]; then
   echo "ERROR: Could not find download URL for $BINARY in latest release."
   echo "Check https://github.com/$REPO/releases"